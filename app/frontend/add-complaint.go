@@ -13,7 +13,6 @@ import (
 	"github.com/skypies/util/widget"
 
 	"github.com/skypies/complaints/pkg/complaintdb"
-	"github.com/skypies/complaints/pkg/flightid"
 )
 // {{{ kActivities = []string
 
@@ -188,9 +187,10 @@ func updateComplaintHandler(ctx context.Context, w http.ResponseWriter, r *http.
 		orig.Activity = new.Activity
 		orig.HeardSpeedbreaks = new.HeardSpeedbreaks
 
-		// If we're manually changing a flightnumber, wipe out all the other flight data
+		// If we're manually changing a flightnumber, wipe out all the other flight data (but
+		// keep the automatic pick to one side, for evaluating the selectors)
 		if newFlightNumber != orig.AircraftOverhead.FlightNumber {
-			orig.AircraftOverhead = flightid.Aircraft{FlightNumber: newFlightNumber}
+			orig.CorrectFlight(newFlightNumber)
 		}
 
 		// Compose a new timestamp, by inserting hew HH:MM:SS fragment into the old timestamp (date+nanoseconds)
@@ -15,9 +15,11 @@ import(
 
 	fdbui "github.com/skypies/flightdb/ui"
 
+	"github.com/skypies/complaints/pkg/airline"
 	"github.com/skypies/complaints/pkg/complaintdb"
 	"github.com/skypies/complaints/pkg/config"
 	"github.com/skypies/complaints/pkg/flightid"
+	"github.com/skypies/complaints/pkg/noiseclass"
 )
 
 
@@ -96,6 +98,14 @@ func main() {
 		port = "8080"
 	}
 
+	// A bad overlay for a bundled table isn't fatal; the bundled data still gets used
+	if _,err := airline.LoadDefaultRegistry(); err != nil {
+		log.Printf("%v", err)
+	}
+	if _,err := noiseclass.LoadDefaultTable(); err != nil {
+		log.Printf("%v", err)
+	}
+
 	fs := http.FileServer(http.Dir("./app/frontend/web/static"))
 	http.Handle("/static/", http.StripPrefix("/static/", fs))
 
@@ -12,6 +12,7 @@ import (
 	hw "github.com/skypies/util/handlerware"
 	"github.com/skypies/util/widget"
 	
+	"github.com/skypies/complaints/pkg/airline"
 	"github.com/skypies/complaints/pkg/complaintdb"
 )
 
@@ -139,6 +140,7 @@ func personalReportHandler(ctx context.Context, w http.ResponseWriter, r *http.R
 	var countsByHour [24]int
 	countsByDate := map[string]int{}
 	countsByAirline := map[string]int{}
+	countsByOperator := map[string]int{}
 	countsByAirport := map[string]int{}
 
 	iter := cdb.NewComplaintIterator(cdb.CQByEmail(sesh.Email).ByTimespan(start,end))
@@ -150,6 +152,15 @@ func personalReportHandler(ctx context.Context, w http.ResponseWriter, r *http.R
 		str := fmt.Sprintf("Time: %s, Loudness:%d, Speedbrakes:%v, Flight:%6.6s, Notes:%s",
 			c.Timestamp.Format("2006.01.02 15:04:05"), c.Loudness, c.HeardSpeedbreaks,
 			c.AircraftOverhead.FlightNumber, c.Description)
+		if c.Unscheduled {
+			str += fmt.Sprintf(", Unscheduled aircraft:%s", c.AircraftOverhead.BestIdent())
+		}
+		if op := c.AircraftOverhead.OperatorString(); op != "" && !c.FlightCorrected {
+			str += fmt.Sprintf(", Operator:%s", op)
+		}
+		if !c.Noise.IsZero() {
+			str += fmt.Sprintf(", EstimatedNoise:%.0fdBA", c.Noise.DB)
+		}
 
 		n++
 		complaintStrings = append(complaintStrings, str)
@@ -158,8 +169,13 @@ func personalReportHandler(ctx context.Context, w http.ResponseWriter, r *http.R
 		countsByDate[c.Timestamp.Format("2006.01.02")]++
 		countsByAirport[c.AircraftOverhead.Origin]++
 		countsByAirport[c.AircraftOverhead.Destination]++
-		if airline := c.AircraftOverhead.IATAAirlineCode(); airline != "" {
-			countsByAirline[airline]++
+		if code := c.AircraftOverhead.AirlineCode(); code != "" {
+			countsByAirline[code]++
+		}
+		if c.Unscheduled {
+			if op := c.AircraftOverhead.OperatorName(); op != "" {
+				countsByOperator[op]++
+			}
 		}
 	}
 	if iter.Err() != nil {
@@ -172,7 +188,14 @@ func personalReportHandler(ctx context.Context, w http.ResponseWriter, r *http.R
 
 	fmt.Fprintf(w, "\nDisturbance reports, counted by Airline (where known):\n")
 	for _,k := range keysByIntValDesc(countsByAirline) {
-		fmt.Fprintf(w, " %s: % 4d\n", k, countsByAirline[k])
+		fmt.Fprintf(w, " %-40.40s: % 4d\n", airline.Label(k), countsByAirline[k])
+	}
+
+	if len(countsByOperator) > 0 {
+		fmt.Fprintf(w, "\nUnscheduled aircraft, counted by registered operator (where known):\n")
+		for _,k := range keysByIntValDesc(countsByOperator) {
+			fmt.Fprintf(w, " %-40.40s: % 4d\n", k, countsByOperator[k])
+		}
 	}
 
 	fmt.Fprintf(w, "\nDisturbance reports, counted by Airport (where known):\n")
@@ -182,13 +205,55 @@ func personalReportHandler(ctx context.Context, w http.ResponseWriter, r *http.R
 		}
 	}
 
+	// Overflights of your house, whether you complained or not (see complaintdb/exposure.go)
+	exposures := map[string]complaintdb.DailyExposure{}
+	var overflightsByHour [24]int
+	overflightsByAirline := map[string]int{}
+	if des,err := cdb.GetDailyExposures(sesh.Email); err != nil {
+		cdb.Errorf("personalReport: %v", err)
+	} else {
+		s,e := date.Time2Datestring(start), date.Time2Datestring(end)
+		for _,de := range des {
+			if de.Datestring < s || de.Datestring > e { continue }
+			exposures[de.Datestring] = de
+			for i,n := range de.ByHour { overflightsByHour[i] += n }
+			for k,n := range de.ByAirline { overflightsByAirline[k] += n }
+		}
+	}
+
 	fmt.Fprintf(w, "\nDisturbance reports, counted by date:\n")
 	for _,k := range keysByKeyAsc(countsByDate) {
-		fmt.Fprintf(w, " %s: % 4d\n", k, countsByDate[k])
+		if de,exists := exposures[k]; exists {
+			fmt.Fprintf(w, " %s: % 4d (% 4d overflights)\n", k, countsByDate[k], de.NumOverflights)
+		} else {
+			fmt.Fprintf(w, " %s: % 4d\n", k, countsByDate[k])
+		}
 	}
 	fmt.Fprintf(w, "\nDisturbance reports, counted by hour of day (across all dates):\n")
 	for i,n := range countsByHour {
-		fmt.Fprintf(w, " %02d: % 4d\n", i, n)
+		if len(exposures) > 0 {
+			fmt.Fprintf(w, " %02d: % 4d (% 5d overflights)\n", i, n, overflightsByHour[i])
+		} else {
+			fmt.Fprintf(w, " %02d: % 4d\n", i, n)
+		}
+	}
+
+	if len(exposures) > 0 {
+		fmt.Fprintf(w, "\nOverflights (within %.0fKM of your house, below %.0fft), by date:\n",
+			complaintdb.KExposureMaxDistKM, complaintdb.KExposureMaxAltitudeFeet)
+		dates := []string{}
+		for k,_ := range exposures { dates = append(dates, k) }
+		sort.Strings(dates)
+		for _,k := range dates {
+			fmt.Fprintf(w, " %s: % 4d overflights, % 4d disturbance reports\n", k,
+				exposures[k].NumOverflights, countsByDate[k])
+		}
+		fmt.Fprintf(w, "\nOverflights, counted by Airline:\n")
+		for _,k := range keysByIntValDesc(overflightsByAirline) {
+			fmt.Fprintf(w, " %-40.40s: % 5d (% 4d disturbance reports)\n", airline.Label(k),
+				overflightsByAirline[k],
+				countsByAirline[k])
+		}
 	}
 	fmt.Fprintf(w, "\nFull dump of all disturbance reports:\n\n")
 	for _,s := range complaintStrings {
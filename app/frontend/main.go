@@ -42,8 +42,19 @@ func hintComplaints(in []complaintdb.Complaint, isSuperHinter bool) []HintedComp
 	for _,c := range in {
 		hc := HintedComplaint{C: c}
 
-		if c.AircraftOverhead.FlightNumber != "" {
+		if c.Unscheduled {
 			hc.BestIdent = c.AircraftOverhead.BestIdent()
+			hc.Notes = append(hc.Notes, "Unscheduled aircraft (not an airline flight)")
+		} else if c.AircraftOverhead.FlightNumber != "" {
+			hc.BestIdent = c.AircraftOverhead.BestIdent()
+			if len(c.Alternates) > 0 && !c.FlightCorrected {
+				hc.Notes = append(hc.Notes,fmt.Sprintf("Identification: %s", c.IdentificationString()))
+			}
+		}
+		if hc.BestIdent != "" && !c.FlightCorrected {
+			if op := c.AircraftOverhead.OperatorString(); op != "" {
+				hc.Notes = append(hc.Notes, fmt.Sprintf("Registered operator: %s", op))
+			}
 		}
 		
 		if c.Description != "" {
@@ -13,6 +13,7 @@ import (
 	"github.com/skypies/complaints/pkg/complaintdb"
 	"github.com/skypies/complaints/pkg/config"
 	"github.com/skypies/complaints/pkg/flightid"
+	"github.com/skypies/complaints/pkg/submitter"
 )
 
 // {{{ profileFormHandler
@@ -41,6 +42,8 @@ func profileFormHandler(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	var params = map[string]interface{}{
 		"Profile": cp,
 		"Selectors": flightid.ListSelectors(),
+		"Offices": submitter.DefaultRegistry().List(),
+		"DefaultParams": flightid.DefaultParams(),
 		"MapsAPIKey": config.Get("googlemaps.apikey"), // For autocomplete & latlong goodness
 	}
 	params["Message"] = r.FormValue("msg")
@@ -70,13 +73,22 @@ func profileUpdateHandler(ctx context.Context, w http.ResponseWriter, r *http.Re
 		http.Error(w, err2.Error(), http.StatusInternalServerError)
 		return
 	}
-	elev,err3 := strconv.ParseFloat(r.FormValue("Elevation"), 64)
+	elev,err3 := 0.0, error(nil) // Blank is OK; we'll look it up
+	if r.FormValue("Elevation") != "" {
+		elev,err3 = strconv.ParseFloat(r.FormValue("Elevation"), 64)
+	}
 	if err3 != nil {
 		cdb.Errorf("profileUpdate:, parse elev '%s': %v", r.FormValue("Elevation"), err3)
 		http.Error(w, err3.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	identParams,err4 := FormValueIdentParams(r)
+	if err4 != nil {
+		http.Error(w, err4.Error(), http.StatusBadRequest)
+		return
+	}
+
 	sesh,_ := hw.GetUserSession(ctx)
 
 	cp := complaintdb.ComplainerProfile{
@@ -93,7 +105,9 @@ func profileUpdateHandler(ctx context.Context, w http.ResponseWriter, r *http.Re
 			Country: r.FormValue("AddrCountry"),
 		},
 		CcSfo: true, //FormValueCheckbox(r, "CcSfo"),
+		Airport: r.FormValue("Airport"),
 		SelectorAlgorithm: r.FormValue("SelectorAlgorithm"),
+		IdentParams: identParams,
 		SendDailyEmail: FormValueTriValuedCheckbox(r, "SendDailyEmail"),
 		DataSharing: FormValueTriValuedCheckbox(r, "DataSharing"),
 		ThirdPartyComms: FormValueTriValuedCheckbox(r, "ThirdPartyComms"),
@@ -110,8 +124,23 @@ func profileUpdateHandler(ctx context.Context, w http.ResponseWriter, r *http.Re
 		cp.UpdateStructuredAddress()
 	}
 
+	origProfile,origErr := cdb.MustLookupProfile(sesh.Email)
+
+	// The form echoes back the elevation we stored; if it is unchanged, so is its source.
+	if origErr == nil && origProfile.Elevation == cp.Elevation &&
+		origProfile.Lat == cp.Lat && origProfile.Long == cp.Long {
+		cp.ElevationSource = origProfile.ElevationSource
+	} else if cp.Elevation != 0 {
+		cp.ElevationSource = "user"
+	}
+
+	// The maps API usually gives us an elevation; if not, look it up ourselves
+	if _,err := cdb.FillProfileElevation(&cp); err != nil {
+		cdb.Errorf("profileUpdate: %v", err)
+	}
+
 	// Preserve some values from the old profile
-	if origProfile,err := cdb.MustLookupProfile(sesh.Email); err == nil {
+	if origErr == nil {
 		cp.ButtonId = origProfile.ButtonId
 	}
 	
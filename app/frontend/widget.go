@@ -2,10 +2,14 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 	"github.com/skypies/util/date"
+
+	"github.com/skypies/complaints/pkg/flightid"
 )
 
 // {{{ FormValueDateRange
@@ -53,6 +57,25 @@ func FormValueFloat64(w http.ResponseWriter, r *http.Request, name string) float
 	}
 }
 
+// }}}
+// {{{ FormValueIdentParams
+
+// FormValueIdentParams reads the flight picker tuning; blank fields get the defaults, but a
+// zero is a real zero. Unparseable or out-of-range values are an error.
+func FormValueIdentParams(r *http.Request) (flightid.Params, error) {
+	p := flightid.Params{AllowUnscheduled: FormValueCheckbox(r, "AllowUnscheduled")}
+	for _,name := range flightid.ParamNames {
+		str := strings.TrimSpace(r.FormValue(name))
+		if str == "" { continue }
+		if val,err := strconv.ParseFloat(str, 64); err != nil {
+			return p, fmt.Errorf("%s: '%s' is not a number", name, str)
+		} else if err := p.Set(name, val); err != nil {
+			return p, err
+		}
+	}
+	return p, p.Validate()
+}
+
 // }}}
 // {{{ FormValueCheckbox
 
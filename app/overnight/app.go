@@ -13,8 +13,11 @@ import(
 	"github.com/skypies/util/date"
 	hw "github.com/skypies/util/handlerware"
 
+	"github.com/skypies/complaints/pkg/airline"
 	"github.com/skypies/complaints/pkg/config"
 	"github.com/skypies/complaints/pkg/complaintdb"
+	"github.com/skypies/complaints/pkg/memds"
+	"github.com/skypies/complaints/pkg/noiseclass"
 )
 
 var(
@@ -42,6 +45,8 @@ func init() {
 	http.HandleFunc("/overnight/csv",                   hw.WithAdmin(hw.WithoutCtx(csvHandler)))
 	http.HandleFunc("/overnight/monthly-report",        hw.WithAdmin(hw.WithoutCtx(monthlySummaryReportHandler)))
 	http.HandleFunc("/overnight/counts",                hw.WithAdmin(hw.WithoutCtx(countsHandler)))
+	http.HandleFunc("/overnight/reidentify",            hw.WithAdmin(hw.WithoutCtx(reidentifyHandler)))
+	http.HandleFunc("/overnight/exposure",              hw.WithAdmin(hw.WithoutCtx(exposureHandler)))
 
 	http.HandleFunc("/overnight/bigquery/day",          hw.WithAdmin(hw.WithoutCtx(publishComplaintsDayHandler)))
 
@@ -61,8 +66,28 @@ func main() {
 	if port == "" {
 		port = "8080"
 	}
+
+	// A bad overlay for a bundled table isn't fatal; the bundled data still gets used
+	if _,err := airline.LoadDefaultRegistry(); err != nil {
+		log.Printf("%v", err)
+	}
+	if _,err := noiseclass.LoadDefaultTable(); err != nil {
+		log.Printf("%v", err)
+	}
+
+	// For running offline; serve from an in-memory datastore, seeded from a memds snapshot file.
+	var handler http.Handler = http.DefaultServeMux
+	if snapshot := os.Getenv("MEMDS_SNAPSHOT"); snapshot != "" {
+		p,err := memds.LoadFile(snapshot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Using in-memory datastore (%d entities from %s)", p.Len(), snapshot)
+		handler = complaintdb.WithContextProperties(complaintdb.ContextProperties{Provider:p}, handler)
+	}
+
 	log.Printf("Listening on port %s", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), nil))
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), handler))
 }
 
 func req2ctx(r *http.Request) context.Context {
@@ -219,12 +219,21 @@ func submitLoadJob(r *http.Request, gcsfolder, gcsfile string) error {
 	myDataset := client.Dataset(bigqueryDataset)
 	destTable := myDataset.Table(bigqueryTableName)
 
+	// The schema comes from AnonymizedComplaint, so that when fields get added to it (e.g. the
+	// noise class or airline fields) the load job adds the columns to the table.
+	schema,err := bigquery.InferSchema(complaintdb.AnonymizedComplaint{})
+	if err != nil {
+		return fmt.Errorf("Inferring schema: %v", err)
+	}
+
 	gcsSrc := bigquery.NewGCSReference(fmt.Sprintf("gs://%s/%s", gcsfolder, gcsfile))
 	gcsSrc.SourceFormat = bigquery.JSON
 	gcsSrc.AllowJaggedRows = true
+	gcsSrc.Schema = schema.Relax() // Older rows lack the newer fields
 
 	loader := destTable.LoaderFrom(gcsSrc)
 	loader.CreateDisposition = bigquery.CreateNever
+	loader.SchemaUpdateOptions = []string{"ALLOW_FIELD_ADDITION", "ALLOW_FIELD_RELAXATION"}
 	job,err := loader.Run(ctx)	
 	if err != nil {
 		return fmt.Errorf("Submission of load job: %v", err)
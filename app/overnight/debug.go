@@ -54,6 +54,9 @@ func SubmissionsDebugHandler(w http.ResponseWriter, r *http.Request) {
 		srr,_ := c.Submission.ClassifyRejection()
 		counts[fmt.Sprintf("[A] Status: %s", c.Submission.Outcome)]++
 		counts[fmt.Sprintf("[B] rejection: %s", srr)]++
+		if remedy,exists := c.Submission.LastRemedy(); exists {
+			counts[fmt.Sprintf("[E] remedy: %s", remedy.Action)]++
+		}
 
 		if c.Submission.WasFailure() {
 			if len(problems) < max_problems {
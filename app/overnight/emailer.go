@@ -68,6 +68,11 @@ func sendEmailsForTimeRange(r *http.Request, s,e time.Time) (error, string) {
 			Profile: p,
 			Complaints: complaints,
 		}
+		if de,err := cdb.GetDailyExposure(p.EmailAddress, date.Time2Datestring(s)); err != nil {
+			cdb.Errorf("Could not get exposure for <%s>: %v", p.EmailAddress, err)
+		} else {
+			cap.Exposure = de
+		}
 
 		err := sendEmail(cap)
 
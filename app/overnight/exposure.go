@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/skypies/util/date"
+	"github.com/skypies/util/widget"
+
+	"github.com/skypies/complaints/pkg/complaintdb"
+	"github.com/skypies/complaints/pkg/config"
+	"github.com/skypies/complaints/pkg/flightid"
+)
+
+// {{{ exposureHandler
+
+// Count the overflights of every user's house, for each day in the range, using flightdb's
+// tracks. Needs to run before the emailer, so the emails can include the counts.
+//   ?date=yesterday
+//   ?date=day&day=2006/01/02
+//  [?step=60]  - seconds between position samples
+func exposureHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := req2ctx(r)
+	cdb,err := complaintdb.New(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s,e,err := widget.FormValueDateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	step := complaintdb.KExposureSampleStep
+	if secs,err := strconv.Atoi(r.FormValue("step")); err == nil && secs > 0 {
+		step = time.Duration(secs) * time.Second
+	}
+
+	// Not DefaultHistorySource; that might be the snapshot corpus, which only covers the sky
+	// near complaints.
+	tracks := flightid.FdbTrackSource{Host: config.Get("airspace.host")}
+
+	str := ""
+	days := date.IntermediateMidnights(s.Add(-1 * time.Second), e) // decrement start, to include it
+	for _,day := range days {
+		tStart := time.Now()
+		n,err := cdb.ComputeDailyExposures(tracks, day, step)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s: %v", date.Time2Datestring(day), err),
+				http.StatusInternalServerError)
+			return
+		}
+		line := fmt.Sprintf("%s: exposure computed for %d users (took %s)\n",
+			date.Time2Datestring(day), n, time.Since(tStart))
+		cdb.Infof(line)
+		str += line
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(fmt.Sprintf("OK!\n%s", str)))
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
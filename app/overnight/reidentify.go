@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/skypies/util/widget"
+
+	"github.com/skypies/complaints/pkg/complaintdb"
+	"github.com/skypies/complaints/pkg/flightid"
+)
+
+// {{{ reidentifyHandler
+
+// Re-run flight identification over a day's complaints, using historical flight tracks.
+//   ?date=yesterday
+//   ?date=day&day=2006/01/02
+//  [?all=1]    - also re-identify complaints that already have an aircraft
+//  [?dryrun=1] - report what would change, but don't write anything
+//  [?algo=trajectory] - use this selector, instead of each user's own
+func reidentifyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := req2ctx(r)
+	cdb,err := complaintdb.New(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s,e,err := widget.FormValueDateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	opts := complaintdb.ReidentifyOptions{
+		OnlyUnidentified: r.FormValue("all") == "",
+		DryRun: r.FormValue("dryrun") != "",
+		Selector: r.FormValue("algo"),
+	}
+
+	hist,err := flightid.DefaultHistorySource()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tStart := time.Now()
+	report,err := cdb.ReidentifyComplaints(cdb.NewComplaintQuery().ByTimespan(s,e), hist, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cdb.Infof("reidentify [%s,%s] took %s: %d/%d changed", s, e, time.Since(tStart),
+		report.Changed, report.N)
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(fmt.Sprintf("OK! [%s,%s] %+v\n\n%s", s, e, opts, report)))
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"time"
@@ -13,12 +12,13 @@ import (
 	"github.com/skypies/util/gcp/tasks"
 	"github.com/skypies/util/widget"
 
-	"github.com/skypies/complaints/pkg/bksv"
 	"github.com/skypies/complaints/pkg/complaintdb"
+	"github.com/skypies/complaints/pkg/config"
+	"github.com/skypies/complaints/pkg/submitter"
 )
 
 var(
-	CascadableUrlParams = []string{"force", "rejects"}
+	CascadableUrlParams = []string{"force", "rejects", "gap"}
 
 	// Should really put these vars somewhere more sensible
 	LocationID = "us-central1" // This is "us-central" in appengine-land, needs a 1 for cloud tasks
@@ -32,11 +32,16 @@ var(
 //   &date=range&range_from=2016/01/21&range_to=2016/01/26
 //  [&force=1]    force resubmits
 //  [&rejects=1]  only submit complaints currently tagged as rejected
+//  [&gap=2m]     minimum gap between submissions from the same user
 
 // Get all the keys for the time range, and queue them for submission.
 func bksvScanDateRangeHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := req2ctx(r)
-	cdb := complaintdb.NewDB(ctx)
+	cdb,err := complaintdb.New(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	taskClient,err := tasks.GetClient(ctx)
 	if err != nil {
@@ -106,7 +111,11 @@ func bksvScanDayHandler(w http.ResponseWriter, r *http.Request) {
 // Get all the keys for the time range, and queue them for submission. Will generate
 // the http response (error or OK)
 func bksvScanTimeRange(ctx context.Context, w http.ResponseWriter, r *http.Request, start,end time.Time) {
-	cdb := complaintdb.NewDB(ctx)
+	cdb,err := complaintdb.New(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	q := cdb.NewComplaintQuery().ByTimespan(start,end)
 	if r.FormValue("rejects") != "" {
@@ -123,18 +132,18 @@ func bksvScanTimeRange(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	cdb.Infof(" bksvScanTimeRange: read %d keys", len(keyers))
 	
 	// The backend is racey, if requests for the same user are sent too
-	// close together. It's not a huge problem, as it will succeed on
-	// retry. Ideally, we'd explicitly sort the complaints to spread out
-	// each user, but all we have are keys, so we don't know which user
-	// each one is. The results seem to be loosely ordered by user, So
-	// do a quick (repeatable for debugging !!) shuffle.
-	rand.Seed(0)
-	shuffler := func(i, j int) { keyers[i],keyers[j] = keyers[j],keyers[i] }
-	rand.Shuffle(len(keyers), shuffler)
-	rand.Shuffle(len(keyers), shuffler)
-	rand.Shuffle(len(keyers), shuffler)
-	cdb.Infof(" bksvScanTimeRange: shuffling done")
-	
+	// close together. So plan the submissions such that each user's
+	// complaints are spread out.
+	planner := submitter.DefaultPlanner()
+	if gap,err := time.ParseDuration(config.Get("submit.usergap")); err == nil {
+		planner.UserGap = gap
+	}
+	if gap,err := time.ParseDuration(r.FormValue("gap")); err == nil {
+		planner.UserGap = gap
+	}
+	plan := submitter.PlanSubmissions(cdb, keyers, planner)
+	cdb.Infof(" bksvScanTimeRange: plan: %s", submitter.PlanSummary(plan))
+
 	// Give ourselves time to finish submitting, before the deluge; we want this submission
 	// loop to have the backend to itself.
 	baseDelay := time.Minute * 10
@@ -146,11 +155,10 @@ func bksvScanTimeRange(ctx context.Context, w http.ResponseWriter, r *http.Reque
 		return
 	}
 	
-	i := 0
-	for _,keyer := range keyers {
+	for i,slot := range plan {
 		uri := bksvStem+"/submit-complaint"
 		params := url.Values{}
-		params.Set("id", keyer.Encode())
+		params.Set("id", slot.Key)
 		// Cascade these params down
 		for _,param := range CascadableUrlParams {
 			if r.FormValue(param) != "" {
@@ -158,7 +166,7 @@ func bksvScanTimeRange(ctx context.Context, w http.ResponseWriter, r *http.Reque
 			}
 		}
 
-		delay := baseDelay + time.Millisecond * 250 * time.Duration(i)
+		delay := baseDelay + slot.Offset
 
 		if _,err := tasks.SubmitAETask(ctx, taskClient, ProjectID, LocationID, QueueName, delay, uri, params); err != nil {
 			cdb.Errorf(" bksvScanTimeRange: enqueue: %v", err)
@@ -166,19 +174,14 @@ func bksvScanTimeRange(ctx context.Context, w http.ResponseWriter, r *http.Reque
 			return
 		}
 
-		/*		if i > 10 {
-			break
-		}*/
-
 		if i % 100 == 0 {
 			cdb.Infof(" bksvScanTimeRange: submitted %d", i)
 		}
-
-		i++
 	}
 
 	cdb.Infof("enqueued %d bksv", len(keyers))
-	w.Write([]byte(fmt.Sprintf("OK, enqueued %d\nstart: %s\nend  : %s\n", len(keyers), start, end)))
+	w.Write([]byte(fmt.Sprintf("OK, enqueued %d\nstart: %s\nend  : %s\nplan : %s\n", len(keyers),
+		start, end, submitter.PlanSummary(plan))))
 }
 
 // }}}
@@ -190,53 +193,24 @@ func bksvSubmitComplaintHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(req2ctx(r), 20 * time.Second)
 	defer cancel()
 
-	cdb := complaintdb.NewDB(ctx)
-	client := cdb.HTTPClient()
-
-	complaint, err := cdb.LookupKey(r.FormValue("id"), "")
+	cdb,err := complaintdb.New(ctx)
 	if err != nil {
-		cdb.Errorf("BKSV bad lookup for id %s: %v", r.FormValue("id"), err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// FIXME - remove this hack at some point.
-	// Hack; we manually fixed up a ton of profiles on 2020.01.28.
-	// Complaints that were stored before this time may have crappy
-	// address data, so re-pull the profile and copy it over.
-	goodAddressesDate := time.Date(2020, time.February, 01, 0, 0, 0, 0, time.UTC)
-	if complaint.Timestamp.Before(goodAddressesDate) {
-		if cp,err := cdb.MustLookupProfile(complaint.Profile.EmailAddress); err == nil {
-			complaint.Profile = *cp
+	// Send it to the complainer's noise office (usually a BKSV site)
+	sub,err := submitter.SubmitComplaint(cdb, submitter.DefaultRegistry(), submitter.DefaultPolicy(),
+		r.FormValue("id"), r.FormValue("force") != "")
+	if err != nil {
+		cdb.Errorf("BKSV posting error: %v", err)
+		if sub != nil {
+			cdb.Infof("BKSV Debug\n------\n%s\n------\n", sub.Log)
 		}
-	}
-
-	// Don't POST if this complaint has already been accepted.
-	if r.FormValue("force") == "" && complaint.Submission.Outcome == complaintdb.SubmissionAccepted {
-		return
-	}
-	
-	sub,postErr := bksv.PostComplaint(client, *complaint)
-	if postErr != nil {
-		cdb.Errorf("BKSV posting error: %v", postErr)
-		cdb.Infof("BKSV Debug\n------\n%s\n------\n", sub)
-	}
-
-	//cdb.Infof("BKSV [OK] Debug\n------\n%s\n------\n", sub.Log)
-
-	// Store the submission outcome, even if the post failed
-	complaint.Submission = *sub
-	if err := cdb.PersistComplaint(*complaint); err != nil {
-		cdb.Errorf("BKSV, peristing outcome failed: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if postErr != nil {
-		http.Error(w, postErr.Error(), http.StatusInternalServerError)
-		return
-	}
-	
 	w.Write([]byte("OK"))
 }
 
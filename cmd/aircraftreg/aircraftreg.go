@@ -0,0 +1,94 @@
+package main
+
+// Builds the offline aircraft registration index used by pkg/aircraftreg.
+//
+//   curl -O https://registry.faa.gov/database/ReleasableAircraft.zip
+//   go run cmd/aircraftreg/aircraftreg.go -zip ReleasableAircraft.zip -bases bases.csv -o aircraftreg.gob
+//   go run cmd/aircraftreg/aircraftreg.go -index aircraftreg.gob -lookup N12345,A0F1B2
+//
+// Then point config "aircraftreg.index" at the .gob file (and deploy it with the apps).
+
+import(
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/skypies/complaints/pkg/aircraftreg"
+)
+
+var(
+	fZip       string
+	fBases     string
+	fOut       string
+	fIndex     string
+	fLookup    string
+)
+
+// {{{ init()
+
+func init() {
+	flag.StringVar(&fZip, "zip", "", "FAA ReleasableAircraft.zip to build the index from")
+	flag.StringVar(&fBases, "bases", "", "optional CSV of tail-or-owner,base-airport")
+	flag.StringVar(&fOut, "o", "aircraftreg.gob", "where to write the index")
+	flag.StringVar(&fIndex, "index", "", "an existing index, to run lookups against")
+	flag.StringVar(&fLookup, "lookup", "", "comma-sep tail numbers or ModeS codes to look up")
+	flag.Parse()
+}
+
+// }}}
+// {{{ main()
+
+func main() {
+	var ix *aircraftreg.Index
+
+	if fZip != "" {
+		recs,err := aircraftreg.ReadFAAZip(fZip)
+		if err != nil { log.Fatal(err) }
+
+		if fBases != "" {
+			f,err := os.Open(fBases)
+			if err != nil { log.Fatal(err) }
+			bases,err := aircraftreg.ParseBases(f)
+			f.Close()
+			if err != nil { log.Fatal(err) }
+			aircraftreg.ApplyBases(recs, bases)
+		}
+
+		ix = aircraftreg.NewIndex(recs)
+
+		f,err := os.Create(fOut)
+		if err != nil { log.Fatal(err) }
+		if err := ix.Save(f); err != nil { log.Fatal(err) }
+		if err := f.Close(); err != nil { log.Fatal(err) }
+		fmt.Printf("wrote %d records to %s\n", ix.Len(), fOut)
+
+	} else if fIndex != "" {
+		var err error
+		if ix,err = aircraftreg.LoadIndexFile(fIndex); err != nil { log.Fatal(err) }
+
+	} else {
+		log.Fatal("need one of -zip or -index")
+	}
+
+	if fLookup != "" {
+		for _,id := range strings.Split(fLookup, ",") {
+			if r,exists := ix.Lookup(id, id); exists {
+				fmt.Printf("%-8s: %s\n", id, r)
+			} else {
+				fmt.Printf("%-8s: not found\n", id)
+			}
+		}
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
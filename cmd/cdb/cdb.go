@@ -8,16 +8,18 @@ import(
 	"os"
 	"reflect"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/skypies/util/date"
 	"github.com/skypies/util/gcp/ds"
 	"github.com/skypies/util/gcp/gcs"
 
-	"github.com/skypies/flightdb/fr24"
 	"github.com/skypies/geo"
 
 	"github.com/skypies/complaints/pkg/complaintdb"
+	"github.com/skypies/complaints/pkg/flightid"
+	"github.com/skypies/complaints/pkg/memds"
 )
 
 const(
@@ -31,14 +33,28 @@ var(
 	fLimit          int
 	fTStart, fTEnd  time.Time
 	fUser           string
+	fOutcome        string
 	fDesc           bool
 	fPurgeFlights   bool
 	fSummary        bool
 	fListUsers      bool
+	fFillElevation  bool
 	fShowAirspace   bool
+	fAirspaceSrc    string
+	fHistorySrc     string
+	fReplay         bool
+	fEvaluate       bool
+	fCorpus         string
+	fAlgos          string
+	fReidentify     bool
+	fReidentifyAll  bool
+	fReidentifyAlgo string
+	fDryRun         bool
 	fArchiveComplaints bool
 	fArchiveFrom, fArchiveTo string
 	fSearchArchive  bool
+	fMemDS          string
+	memProvider    *memds.Provider
 )
 
 // {{{ init()
@@ -47,32 +63,62 @@ func init() {
 	flag.IntVar(&fVerbosity, "v", 0, "verbosity level")
 	flag.IntVar(&fLimit, "n", 40, "how many matches to retrieve")
 	flag.StringVar(&fUser, "user", "", "email address of user")
+	flag.StringVar(&fOutcome, "outcome", "", "only complaints with this identification outcome (e.g. abstained)")
 	flag.BoolVar(&fDesc, "desc", false, "descending order of timestamp")
 	flag.BoolVar(&fSummary, "summary", false, "generate a summary report over the time period")
 	flag.BoolVar(&fShowAirspace, "airspace", false, "show the current airspace")
+	flag.StringVar(&fAirspaceSrc, "airspacesrc", "", "airspace source: fr24, fdb, aex, or file:PATH (default from config)")
+	flag.StringVar(&fHistorySrc, "historysrc", "", "historical tracks for -reidentify: fdb, fdb:HOST, corpus, or corpus:dir:PATH etc (default from config)")
+	flag.BoolVar(&fReplay, "replay", false, "replay recorded airspace snapshots through the selectors")
+	flag.BoolVar(&fEvaluate, "evaluate", false, "score the selectors against user-corrected complaints")
+	flag.StringVar(&fCorpus, "corpus", "", "airspace snapshot corpus: dir:PATH, or gcs:BUCKET (default from config)")
+	flag.StringVar(&fAlgos, "algos", strings.Join(flightid.SelectorNames, ","), "selectors to replay/evaluate")
+	flag.BoolVar(&fReidentify, "reidentify", false, "re-run identification for unidentified complaints, from historical tracks")
+	flag.BoolVar(&fReidentifyAll, "reidentifyall", false, "with -reidentify, also redo complaints that already have an aircraft")
+	flag.StringVar(&fReidentifyAlgo, "reidentifyalgo", "", "with -reidentify, use this selector (e.g. trajectory) instead of each user's own")
+	flag.BoolVar(&fDryRun, "dryrun", false, "don't write anything back")
 	flag.BoolVar(&fListUsers, "users", false, "report users (not complaints)")
+	flag.BoolVar(&fFillElevation, "fillelevation", false, "look up elevations for profiles without one (needs config elevation.dem)")
 	flag.BoolVar(&fArchiveComplaints, "archive", false, "archive complaints in timewindow to GCS freezefiles")
 	flag.StringVar(&fArchiveFrom, "archivefrom", "", "2015.01.01")
 	flag.StringVar(&fArchiveTo, "archiveto", "", "2015.01.02")
 	//flag.BoolVar(&fPurgeFlights, "purge", false, "remove flightnumber from random() complaints")
 	flag.BoolVar(&fSearchArchive, "archivesearch", false, "run queries against archive VERY SLOWLY")
+	flag.StringVar(&fMemDS, "memds", "", "run offline, against an in-memory datastore loaded from (and saved back to) this snapshot file")
 	
 	var s, e timeType
 	flag.Var(&s, "s", "start time in PT (2006-01-02T15:04:05)")
 	flag.Var(&e, "e", "end time in PT   (2006-01-02T15:04:05)")	
 	flag.Parse()
 
-	for _, e := range []string{"GOOGLE_APPLICATION_CREDENTIALS"} {
-		if os.Getenv(e) == "" {
-			log.Fatal("You're gonna need $"+e)
+	if fMemDS == "" {
+		for _, e := range []string{"GOOGLE_APPLICATION_CREDENTIALS"} {
+			if os.Getenv(e) == "" {
+				log.Fatal("You're gonna need $"+e)
+			}
 		}
 	}
 	
 	fTStart = time.Time(s)
 	fTEnd = time.Time(e)
 
-	cdb = complaintdb.NewDB(ctx)
-	cdb.Logger = log.New(os.Stderr,"", log.Ldate|log.Ltime)//|log.Lshortfile)	
+	opts := []complaintdb.Option{
+		complaintdb.WithLogger(log.New(os.Stderr,"", log.Ldate|log.Ltime)),//|log.Lshortfile)
+		complaintdb.WithAirspaceSource(airspaceSource()),
+	}
+	if fMemDS != "" {
+		if p,err := memds.LoadFile(fMemDS); err != nil {
+			log.Fatal(err)
+		} else {
+			memProvider = p
+			opts = append(opts, complaintdb.WithProvider(p))
+		}
+	}
+
+	var err error
+	if cdb,err = complaintdb.New(ctx, opts...); err != nil {
+		log.Fatal(err)
+	}
 }
 
 // }}}
@@ -100,6 +146,7 @@ func queryFromArgs() *complaintdb.CQuery {
 
 	if fUser != "" { cq = cdb.CQByEmail(fUser) }
 
+	if fOutcome != "" { cq = cq.ByIdentOutcome(fOutcome) }
 	if ! fTStart.IsZero() { cq = cq.Filter("Timestamp >= ", fTStart) }
 	if ! fTEnd.IsZero() { cq = cq.Filter("Timestamp < ", fTEnd) }
 
@@ -137,7 +184,14 @@ func runQuery(cq *complaintdb.CQuery) {
 			fmt.Printf("%s\n", c)
 		}
 
-		if ! regexp.MustCompile("(outcome: random)").MatchString(c.Debug) {
+		if tr,err := c.IdentTrace(); err != nil {
+			fatal(err)
+		} else if tr.IsZero() {
+			// Old complaints only have the debug text
+			if ! regexp.MustCompile("(outcome: random)").MatchString(c.Debug) {
+				continue
+			}
+		} else if tr.Selector != "random" {
 			continue
 		}
 		
@@ -152,7 +206,7 @@ func runQuery(cq *complaintdb.CQuery) {
 			toWrite = append(toWrite, *c)
 			if len(toWrite) >= 50 {
 				if err := cdb.PersistComplaints(toWrite); err != nil {
-					log.Fatal(err)
+					fatal(err)
 				}
 				toWrite = nil
 			}
@@ -162,17 +216,17 @@ func runQuery(cq *complaintdb.CQuery) {
 		fmt.Printf("[%2d] %s\n", n, c)
 
 		if fVerbosity>0 {
-			fmt.Printf("%s\n", c.Debug)
+			fmt.Printf("%s\n", c.IdentDebug())
 		}
 	}
 	if iter.Err() != nil {
-		log.Fatal(iter.Err())
+		fatal(iter.Err())
 	}
 
 	// Stragglers
 	if len(toWrite) > 0 {
 		if err := cdb.PersistComplaints(toWrite); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	}
 
@@ -182,16 +236,100 @@ func runQuery(cq *complaintdb.CQuery) {
 // }}}
 // {{{ runShowAirspace
 
+func airspaceSource() flightid.AirspaceSource {
+	if fAirspaceSrc == "" {
+		return flightid.DefaultAirspaceSource()
+	}
+	return flightid.NewAirspaceSource(fAirspaceSrc)
+}
+
 func runShowAirspace() {
-	fmt.Printf("(fetching current airspace)\n")
+	src := airspaceSource()
+	fmt.Printf("(fetching current airspace from %s)\n", src)
 
 	area := geo.Latlong{37.060312,-121.990814}.Box(60,60)
-	as, err := fr24.GRPCFetchAirspace(area)
+	as, err := src.FetchAirspace(area)
 
-	fmt.Printf("fr24 airspace, err: %v\n", err)
+	fmt.Printf("%s airspace, err: %v\n", src, err)
 	fmt.Printf("AS: %s\n", as)
 }
 
+// }}}
+// {{{ runReplay
+
+// -replay -corpus=dir:/tmp/snapshots -algos=conservative,cone  [-s=... -e=...]
+
+func runReplay() {
+	corpus := flightid.DefaultSnapshotCorpus()
+	if fCorpus != "" { corpus = flightid.NewSnapshotCorpus(fCorpus) }
+	if corpus == nil {
+		fatal("no snapshot corpus; use -corpus=dir:PATH or -corpus=gcs:BUCKET")
+	}
+
+	snaps,err := corpus.Snapshots(ctx)
+	if err != nil { fatal(err) }
+
+	inRange := []flightid.Snapshot{}
+	for _,snap := range snaps {
+		if !fTStart.IsZero() && snap.Time.Before(fTStart) { continue }
+		if !fTEnd.IsZero() && !snap.Time.Before(fTEnd) { continue }
+		inRange = append(inRange, snap)
+	}
+
+	names := strings.Split(fAlgos, ",")
+	fmt.Printf("(replaying %d snapshots from %s through %v)\n\n", len(inRange), corpus, names)
+
+	results := flightid.ReplayCorpus(inRange, names)
+	fmt.Printf("%s", flightid.ReplayReport(results, names))
+}
+
+// }}}
+// {{{ runEvaluate
+
+// -evaluate -corpus=gcs:BUCKET -algos=conservative,cone  [-s=... -e=...] [-user=...]
+
+func runEvaluate() {
+	corpus := flightid.DefaultSnapshotCorpus()
+	if fCorpus != "" { corpus = flightid.NewSnapshotCorpus(fCorpus) }
+
+	cq := queryFromArgs()
+	cq.Limit(-1)
+	cases,err := cdb.SelectorEvaluationCases(cq, corpus)
+	if err != nil { fatal(err) }
+
+	nSnaps := 0
+	for _,c := range cases {
+		if c.Snapshot != nil { nSnaps++ }
+	}
+	fmt.Printf("(%d corrected complaints, %d with airspace snapshots in %v)\n\n", len(cases),
+		nSnaps, corpus)
+
+	for _,score := range flightid.EvaluateSelectors(cases, strings.Split(fAlgos, ",")) {
+		fmt.Printf("%s\n", score)
+	}
+}
+
+// }}}
+// {{{ runReidentify
+
+// -reidentify [-reidentifyall] [-reidentifyalgo=trajectory] [-dryrun] [-historysrc=corpus:dir:/tmp/snapshots]  [-s=... -e=...] [-user=...] [-n=...]
+
+func runReidentify() {
+	opts := complaintdb.ReidentifyOptions{OnlyUnidentified: !fReidentifyAll, DryRun: fDryRun,
+		Selector: fReidentifyAlgo}
+	hist,err := flightid.DefaultHistorySource()
+	if fHistorySrc != "" {
+		hist,err = flightid.NewHistorySource(fHistorySrc)
+	}
+	if err != nil { fatal(err) }
+
+	tStart := time.Now()
+	report,err := cdb.ReidentifyComplaints(queryFromArgs(), hist, opts)
+	if err != nil { fatal(err) }
+
+	fmt.Printf("(reidentified from %s, %+v; took %s)\n\n%s", hist, opts, time.Since(tStart), report)
+}
+
 // }}}
 // {{{ runSummaryReport
 
@@ -204,7 +342,7 @@ func runSummaryReport() {
 	fmt.Printf("(running summary report, from %s to %s)\n", s,e)
 	tStart := time.Now()
 	if str,err := cdb.SummaryReport(s,e,false,map[string]int{}); err != nil {
-		log.Fatal(err)
+		fatal(err)
 	} else {
 		fmt.Printf("\n%s\n", str)
 		fmt.Printf("(report took %s to run)\n", time.Since(tStart))
@@ -217,7 +355,7 @@ func runSummaryReport() {
 func runUserReport() {
 	profiles, err := cdb.LookupAllProfiles(cdb.NewProfileQuery())
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 	for _,p := range profiles {
 		fmt.Printf("%s\n", p.EmailAddress)
@@ -225,6 +363,30 @@ func runUserReport() {
 	fmt.Printf("(%d profiles found)\n", len(profiles))
 }
 
+// }}}
+// {{{ runFillElevation
+
+func runFillElevation() {
+	profiles, err := cdb.LookupAllProfiles(cdb.NewProfileQuery())
+	if err != nil {
+		fatal(err)
+	}
+	n := 0
+	for _,p := range profiles {
+		if filled,err := cdb.FillProfileElevation(&p); err != nil {
+			fatal(err)
+		} else if !filled {
+			continue
+		}
+		n++
+		fmt.Printf("%-40.40s (%.4f,%.4f): %.0fm\n", p.EmailAddress, p.Lat, p.Long, p.Elevation)
+		if !fDryRun {
+			if err := cdb.PersistProfile(p); err != nil { fatal(err) }
+		}
+	}
+	fmt.Printf("(%d of %d profiles given an elevation)\n", n, len(profiles))
+}
+
 // }}}
 // {{{ runArchiveQuery
 
@@ -314,7 +476,7 @@ func archiveComplaints() {
 	e := date.Datestring2MidnightPdt(fArchiveTo)
 
 	if s.IsZero() {
-		log.Fatal("need archivefrom")
+		fatal("need archivefrom")
 	} else if e.IsZero() {
 		log.Printf("(assuming single day of archiving)")
 		e = s
@@ -336,7 +498,7 @@ func archiveComplaints() {
 		cq := cdb.NewComplaintQuery().ByTimespan(winS, winE)
 		complaints,err := cdb.LookupAll(cq)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 
 		if len(complaints) == 0 {
@@ -344,7 +506,7 @@ func archiveComplaints() {
 				log.Printf(" -- [%s], no complaints found in DB - already archived. skipping\n", m)
 				continue 
 			} else {
-				log.Fatal(" -- [%s], no complaints found in DB, no archive found, bad date ?!\n", m)
+				fatal(fmt.Sprintf(" -- [%s], no complaints found in DB, no archive found, bad date ?!\n", m))
 			}
 		}
 		
@@ -355,13 +517,13 @@ func archiveComplaints() {
 
 			filehandle,err := gcs.OpenRW(ctx, ArchiveGCSBucketName, gcsFilename, "application/octet-stream")
 			if err != nil {
-				log.Fatal(err)
+				fatal(err)
 			}
 			if err := cdb.MarshalComplaintSlice(complaints, filehandle.IOWriter()); err != nil {
-				log.Fatal(err)
+				fatal(err)
 			}
 			if err := filehandle.Close(); err != nil {
-				log.Fatal(err)
+				fatal(err)
 			}
 			log.Printf(" --[%s], %d complaints written to %s/%s\n", m, len(complaints),
 				ArchiveGCSBucketName, gcsFilename)
@@ -369,7 +531,7 @@ func archiveComplaints() {
 
 		// Reads 'em all back, compare to what we stated with
 		if err := verifyArchiveComplaints(ArchiveGCSBucketName, gcsFilename, complaints); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 
 		// Archiving looks good - delete them from datastore !
@@ -392,7 +554,7 @@ func archiveComplaints() {
 				}
 
 				if err := cdb.DeleteAllKeys(keyersToDelete); err != nil {
-					log.Fatal(err)
+					fatal(err)
 				}
 			}
 			log.Printf("... done\n")
@@ -482,6 +644,27 @@ func searchArchiveComplaints(bucketname, filename string, username string) ([]co
 // {{{ main()
 
 func main() {
+	run()
+	saveMemDS()
+}
+
+// saveMemDS writes the in-memory datastore (if we're using one) back to its snapshot file.
+func saveMemDS() {
+	if memProvider == nil {
+		return
+	}
+	if err := memProvider.SaveFile(fMemDS); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// fatal is log.Fatal, but saves the in-memory datastore first; log.Fatal skips deferred calls.
+func fatal(v ...interface{}) {
+	saveMemDS()
+	log.Fatal(v...)
+}
+
+func run() {
 	if fSummary {
 		runSummaryReport()
 		return
@@ -490,10 +673,26 @@ func main() {
 		runUserReport()
 		return
 
+	} else if fFillElevation {
+		runFillElevation()
+		return
+
 	} else if fShowAirspace {
 		runShowAirspace()
 		return
 
+	} else if fReplay {
+		runReplay()
+		return
+
+	} else if fEvaluate {
+		runEvaluate()
+		return
+
+	} else if fReidentify {
+		runReidentify()
+		return
+
 	} else if fArchiveComplaints {
 		archiveComplaints()
 		return
@@ -510,7 +709,7 @@ func main() {
 	// Bare args are individual complaint keys
 	for _,k := range flag.Args() {
 		c, err := cdb.LookupKey(k,"")
-		if err != nil { log.Fatal(err) }
+		if err != nil { fatal(err) }
 		fmt.Printf(" * [exp] %s\n", c)
 
 		// TODO: add args to handle deletion
@@ -5,7 +5,9 @@ toolchain go1.24.1
 
 require (
 	cloud.google.com/go/bigquery v1.57.1
+	cloud.google.com/go/datastore v1.15.0
 	github.com/mailjet/mailjet-apiv3-go v0.0.0-20190724151621-55e56f74078c
+	github.com/skypies/adsb v0.1.0
 	github.com/skypies/flightdb v0.1.6
 	github.com/skypies/geo v0.0.0-20180901233721-9d4f211f3066
 	github.com/skypies/pi v0.1.2
@@ -19,7 +21,6 @@ require (
 	cloud.google.com/go/cloudtasks v1.12.4 // indirect
 	cloud.google.com/go/compute v1.23.3 // indirect
 	cloud.google.com/go/compute/metadata v0.2.3 // indirect
-	cloud.google.com/go/datastore v1.15.0 // indirect
 	cloud.google.com/go/iam v1.1.5 // indirect
 	cloud.google.com/go/storage v1.30.1 // indirect
 	github.com/andybalholm/brotli v1.0.4 // indirect
@@ -50,7 +51,6 @@ require (
 	github.com/paulmach/go.geojson v1.5.0 // indirect
 	github.com/pierrec/lz4/v4 v4.1.15 // indirect
 	github.com/sergi/go-diff v1.1.0 // indirect
-	github.com/skypies/adsb v0.1.0 // indirect
 	github.com/zeebo/xxh3 v1.0.2 // indirect
 	go.opencensus.io v0.24.0 // indirect
 	go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc v0.46.1 // indirect
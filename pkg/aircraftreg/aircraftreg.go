@@ -0,0 +1,164 @@
+package aircraftreg
+
+// Package aircraftreg is an offline index of aircraft registrations, keyed by tail number and
+// ModeS code, so that we can say who owns (and usually operates) the GA traffic that has no
+// flight number. It is built from the FAA releasable aircraft database (see faa.go) by
+// cmd/aircraftreg, and loaded from the file named by config "aircraftreg.index".
+
+import(
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/skypies/complaints/pkg/config"
+)
+
+// {{{ Record
+
+type Record struct {
+	Tail         string // e.g. N12345
+	ModeS        string // Hex, e.g. A0F1B2
+	Owner        string // The registrant; for flight schools and charters, usually the operator
+	City, State  string // The registrant's address
+	Manufacturer string
+	Model        string
+	Year         string
+	BaseAirport  string // Not in the FAA data; comes from a locally maintained bases file
+}
+
+func (r Record)IsZero() bool { return r.Tail == "" && r.ModeS == "" }
+
+// AircraftType is e.g. "CESSNA 172S".
+func (r Record)AircraftType() string {
+	return strings.TrimSpace(r.Manufacturer + " " + r.Model)
+}
+
+// Location is the base airport if we know it, else where the owner is registered.
+func (r Record)Location() string {
+	if r.BaseAirport != "" {
+		return "based at " + r.BaseAirport
+	} else if r.City != "" {
+		return "registered in " + strings.TrimSpace(r.City + ", " + r.State)
+	}
+	return ""
+}
+
+func (r Record)String() string {
+	str := fmt.Sprintf("%s [%s] %s (%s)", r.Tail, r.ModeS, r.Owner, r.AircraftType())
+	if loc := r.Location(); loc != "" { str += ", " + loc }
+	return str
+}
+
+// NormalizeTail uppercases, and strips dashes and spaces.
+func NormalizeTail(tail string) string {
+	return strings.ToUpper(strings.NewReplacer("-","", " ","").Replace(tail))
+}
+
+func NormalizeModeS(modes string) string {
+	return strings.ToUpper(strings.TrimSpace(modes))
+}
+
+// }}}
+// {{{ Index
+
+// Index holds the records, indexed by tail and ModeS.
+type Index struct {
+	Records []Record
+	byTail  map[string]int
+	byModeS map[string]int
+}
+
+func NewIndex(recs []Record) *Index {
+	ix := &Index{Records:recs}
+	ix.reindex()
+	return ix
+}
+
+func (ix *Index)reindex() {
+	ix.byTail = map[string]int{}
+	ix.byModeS = map[string]int{}
+	for i,r := range ix.Records {
+		if r.Tail != "" { ix.byTail[NormalizeTail(r.Tail)] = i }
+		if r.ModeS != "" { ix.byModeS[NormalizeModeS(r.ModeS)] = i }
+	}
+}
+
+func (ix *Index)Len() int { return len(ix.Records) }
+
+// Lookup finds the record by tail number, or failing that by ModeS code. Either may be blank.
+func (ix *Index)Lookup(tail, modes string) (Record, bool) {
+	if i,exists := ix.byTail[NormalizeTail(tail)]; exists && tail != "" {
+		return ix.Records[i], true
+	} else if i,exists := ix.byModeS[NormalizeModeS(modes)]; exists && modes != "" {
+		return ix.Records[i], true
+	}
+	return Record{}, false
+}
+
+// }}}
+// {{{ ix.Save, LoadIndex
+
+func (ix *Index)Save(w io.Writer) error {
+	if err := gob.NewEncoder(w).Encode(ix.Records); err != nil {
+		return fmt.Errorf("Index.Save: %v", err)
+	}
+	return nil
+}
+
+func LoadIndex(r io.Reader) (*Index, error) {
+	recs := []Record{}
+	if err := gob.NewDecoder(r).Decode(&recs); err != nil {
+		return nil, fmt.Errorf("LoadIndex: %v", err)
+	}
+	return NewIndex(recs), nil
+}
+
+func LoadIndexFile(filename string) (*Index, error) {
+	f,err := os.Open(filename)
+	if err != nil { return nil, fmt.Errorf("LoadIndexFile: %v", err) }
+	defer f.Close()
+	return LoadIndex(f)
+}
+
+// }}}
+// {{{ DefaultIndex
+
+var(
+	defaultIndex *Index
+	defaultOnce sync.Once
+)
+
+// DefaultIndex is loaded from the file named by config "aircraftreg.index". If there isn't one
+// (or it can't be read; that gets logged), the index is empty and every lookup fails.
+func DefaultIndex() *Index {
+	defaultOnce.Do(func() {
+		defaultIndex = NewIndex(nil)
+		if filename := config.Get("aircraftreg.index"); filename != "" {
+			if ix,err := LoadIndexFile(filename); err != nil {
+				log.Printf("aircraftreg: %v", err)
+			} else {
+				defaultIndex = ix
+			}
+		}
+	})
+	return defaultIndex
+}
+
+// Lookup finds the aircraft in the default index.
+func Lookup(tail, modes string) (Record, bool) {
+	return DefaultIndex().Lookup(tail, modes)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
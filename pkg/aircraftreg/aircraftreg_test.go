@@ -0,0 +1,70 @@
+package aircraftreg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Cut down versions of the FAA files; the real ones have many more columns, trailing commas,
+// and a byte order mark.
+const testMaster = "\ufeffN-NUMBER,SERIAL NUMBER,MFR MDL CODE,YEAR MFR,NAME,CITY,STATE,MODE S CODE HEX,\n" +
+	"12345,17281234,2072738,2008,WEST VALLEY FLYING CLUB  ,SAN JOSE,CA,A0F1B2    ,\n" +
+	"678AB,5501,7100510,1978,\"SMITH, JOHN\",SAN CARLOS,CA,A8C3D4\n"
+
+const testAcftref = "CODE,MFR,MODEL,TYPE-ACFT,\n" +
+	"2072738,CESSNA,172S,4,\n" +
+	"7100510,PIPER,PA-28-181,4,\n"
+
+// {{{ TestReadFAA
+
+func TestReadFAA(t *testing.T) {
+	recs,err := ReadFAA(strings.NewReader(testMaster), strings.NewReader(testAcftref))
+	if err != nil { t.Fatal(err) }
+	if len(recs) != 2 { t.Fatalf("expected 2 records, got %d", len(recs)) }
+
+	bases,err := ParseBases(strings.NewReader("# comment\nwest valley flying club,PAO\nN678AB,SQL\n"))
+	if err != nil { t.Fatal(err) }
+	ApplyBases(recs, bases)
+
+	ix := NewIndex(recs)
+	if r,exists := ix.Lookup("n-12345", ""); !exists {
+		t.Errorf("N12345 not found by tail")
+	} else if r.Owner != "WEST VALLEY FLYING CLUB" || r.AircraftType() != "CESSNA 172S" {
+		t.Errorf("N12345 bad record: %s", r)
+	} else if r.Location() != "based at PAO" {
+		t.Errorf("N12345 bad base: %q", r.Location())
+	}
+
+	if r,exists := ix.Lookup("", "a8c3d4"); !exists {
+		t.Errorf("A8C3D4 not found by ModeS")
+	} else if r.Tail != "N678AB" || r.Owner != "SMITH, JOHN" || r.BaseAirport != "SQL" {
+		t.Errorf("A8C3D4 bad record: %s", r)
+	}
+
+	if _,exists := ix.Lookup("", ""); exists {
+		t.Errorf("blank lookup found something")
+	}
+	if _,exists := ix.Lookup("N999", "ABCDEF"); exists {
+		t.Errorf("bogus lookup found something")
+	}
+
+	// Round trip via the saved index
+	buf := bytes.Buffer{}
+	if err := ix.Save(&buf); err != nil { t.Fatal(err) }
+	ix2,err := LoadIndex(&buf)
+	if err != nil { t.Fatal(err) }
+	if r,exists := ix2.Lookup("N12345", ""); !exists || r.ModeS != "A0F1B2" {
+		t.Errorf("index didn't round trip: %s", r)
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,154 @@
+package aircraftreg
+
+// The FAA releasable aircraft database, from
+//   https://registry.faa.gov/database/ReleasableAircraft.zip
+// is a zip of comma-separated files with header rows. We need two of them: MASTER.txt (one row
+// per registered aircraft) and ACFTREF.txt (manufacturer & model, keyed by MFR MDL CODE).
+//
+// It has no notion of where an aircraft is based, so we overlay a bases file; a CSV of
+// "tail-or-owner,airport" lines, e.g. "WEST VALLEY FLYING CLUB,PAO", or "N12345,SQL".
+
+import(
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// {{{ readHeaderedCSV
+
+// readHeaderedCSV calls f for each row, with a func to fetch a (trimmed) field by its header name.
+func readHeaderedCSV(r io.Reader, f func(get func(string) string)) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // The FAA files have trailing commas on some rows, but not others
+	cr.LazyQuotes = true
+
+	header,err := cr.Read()
+	if err != nil { return err }
+	cols := map[string]int{}
+	for i,name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		cols[name] = i
+	}
+
+	for {
+		rec,err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		f(func(name string) string {
+			if i,exists := cols[name]; exists && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		})
+	}
+}
+
+// }}}
+// {{{ ReadFAA
+
+// ReadFAA builds records from the MASTER and ACFTREF files.
+func ReadFAA(master, acftref io.Reader) ([]Record, error) {
+	models := map[string][2]string{}
+	err := readHeaderedCSV(acftref, func(get func(string) string) {
+		models[get("CODE")] = [2]string{get("MFR"), get("MODEL")}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ReadFAA/ACFTREF: %v", err)
+	}
+
+	recs := []Record{}
+	err = readHeaderedCSV(master, func(get func(string) string) {
+		if get("N-NUMBER") == "" { return }
+		model := models[get("MFR MDL CODE")]
+		recs = append(recs, Record{
+			Tail: "N" + get("N-NUMBER"),
+			ModeS: get("MODE S CODE HEX"),
+			Owner: get("NAME"),
+			City: get("CITY"),
+			State: get("STATE"),
+			Manufacturer: model[0],
+			Model: model[1],
+			Year: get("YEAR MFR"),
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ReadFAA/MASTER: %v", err)
+	}
+
+	return recs, nil
+}
+
+// ReadFAAZip reads the records out of ReleasableAircraft.zip, as downloaded.
+func ReadFAAZip(filename string) ([]Record, error) {
+	zr,err := zip.OpenReader(filename)
+	if err != nil { return nil, fmt.Errorf("ReadFAAZip: %v", err) }
+	defer zr.Close()
+
+	files := map[string]*zip.File{}
+	for _,f := range zr.File { files[strings.ToUpper(f.Name)] = f }
+
+	open := func(name string) (io.ReadCloser, error) {
+		if f,exists := files[name]; !exists {
+			return nil, fmt.Errorf("ReadFAAZip: %s not found in %s", name, filename)
+		} else {
+			return f.Open()
+		}
+	}
+
+	master,err := open("MASTER.TXT")
+	if err != nil { return nil, err }
+	defer master.Close()
+	acftref,err := open("ACFTREF.TXT")
+	if err != nil { return nil, err }
+	defer acftref.Close()
+
+	return ReadFAA(master, acftref)
+}
+
+// }}}
+// {{{ ParseBases, ApplyBases
+
+// ParseBases reads a bases file; lines starting with '#' are comments.
+func ParseBases(r io.Reader) (map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 2
+
+	bases := map[string]string{}
+	for {
+		rec,err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("ParseBases: %v", err)
+		}
+		bases[strings.ToUpper(strings.TrimSpace(rec[0]))] = strings.ToUpper(strings.TrimSpace(rec[1]))
+	}
+	return bases, nil
+}
+
+// ApplyBases fills in BaseAirport, by tail number if listed, else by owner.
+func ApplyBases(recs []Record, bases map[string]string) {
+	for i,r := range recs {
+		if base,exists := bases[NormalizeTail(r.Tail)]; exists {
+			recs[i].BaseAirport = base
+		} else if base,exists := bases[strings.ToUpper(r.Owner)]; exists {
+			recs[i].BaseAirport = base
+		}
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,220 @@
+package airline
+
+// Package airline maps airline codes to operator names and home countries. It knows both the
+// 2-char IATA codes used in flight numbers (UA337) and the 3-char ICAO codes used in callsigns
+// (UAL337), so it can resolve flights we only have a callsign for. The registry is compiled in
+// from airlines.csv, and can be overlaid at runtime (see config "airline.table").
+
+import(
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/skypies/complaints/pkg/config"
+)
+
+//go:embed airlines.csv
+var bundledRegistry string
+
+// {{{ Airline
+
+type Airline struct {
+	IATA     string // 2-char code used in flight numbers, e.g. UA; may be blank
+	ICAO     string // 3-char code used in callsigns, e.g. UAL
+	Callsign string // The radiotelephony callsign, e.g. UNITED
+	Name     string
+	Country  string // ISO 3166 two-letter code
+}
+
+func (a Airline)IsZero() bool { return a.ICAO == "" && a.IATA == "" }
+
+// Code is the IATA code if there is one (that's what the public knows), else the ICAO code.
+func (a Airline)Code() string {
+	if a.IATA != "" { return a.IATA }
+	return a.ICAO
+}
+
+func (a Airline)String() string {
+	return fmt.Sprintf("%s (%s, %s)", a.Code(), a.Name, a.Country)
+}
+
+// }}}
+// {{{ Registry
+
+// Registry indexes airlines by both their IATA and ICAO codes.
+type Registry struct {
+	byIATA map[string]Airline
+	byICAO map[string]Airline
+}
+
+func NewRegistry() *Registry {
+	return &Registry{byIATA:map[string]Airline{}, byICAO:map[string]Airline{}}
+}
+
+func (r *Registry)Len() int { return len(r.byICAO) }
+
+// Add puts the airline in the registry, replacing any existing entries with the same codes.
+func (r *Registry)Add(a Airline) {
+	if a.IATA != "" { r.byIATA[a.IATA] = a }
+	if a.ICAO != "" { r.byICAO[a.ICAO] = a }
+}
+
+// Overlay adds all the airlines from the other registry, replacing any existing ones.
+func (r *Registry)Overlay(other *Registry) {
+	for _,a := range other.byICAO { r.Add(a) } // Every airline has an ICAO code
+}
+
+// ParseRegistry reads the CSV format used by airlines.csv; lines starting with '#' are comments.
+func ParseRegistry(rdr io.Reader) (*Registry, error) {
+	cr := csv.NewReader(rdr)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 5
+
+	r := NewRegistry()
+	for {
+		rec,err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("ParseRegistry: %v", err)
+		}
+
+		a := Airline{
+			IATA: normalizeCode(rec[0]),
+			ICAO: normalizeCode(rec[1]),
+			Callsign: strings.TrimSpace(rec[2]),
+			Name: strings.TrimSpace(rec[3]),
+			Country: strings.TrimSpace(rec[4]),
+		}
+		if len(a.IATA) != 0 && len(a.IATA) != 2 {
+			return nil, fmt.Errorf("ParseRegistry: %s: bad IATA code %q", a.Name, a.IATA)
+		} else if len(a.ICAO) != 3 {
+			return nil, fmt.Errorf("ParseRegistry: %s: bad ICAO code %q", a.Name, a.ICAO)
+		}
+		r.Add(a)
+	}
+
+	return r, nil
+}
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// }}}
+// {{{ r.Lookup, r.Resolve, r.ICAOCallsign
+
+// Lookup finds an airline by its IATA (2-char) or ICAO (3-char) code.
+func (r *Registry)Lookup(code string) (Airline, bool) {
+	code = normalizeCode(code)
+	var a Airline
+	var exists bool
+	switch len(code) {
+	case 2: a,exists = r.byIATA[code]
+	case 3: a,exists = r.byICAO[code]
+	}
+	return a, exists
+}
+
+var(
+	// UA337, 9E4012, AS1234A; the trailing letter is an operational suffix
+	iataFlightnumberRegexp = regexp.MustCompile("^([0-9A-Z]{2})([0-9]{1,4})[A-Z]?$")
+	// UAL337, SKW5422, BAW28K; never N-numbers (N123AB), as the prefix must be three letters
+	icaoCallsignRegexp = regexp.MustCompile("^([A-Z]{3})([0-9][0-9A-Z]{0,3})$")
+)
+
+// Resolve works out the operator of a flight from its flight number (either UA337 or UAL337
+// forms), falling back to the prefix of its callsign.
+func (r *Registry)Resolve(flightnumber, callsign string) (Airline, bool) {
+	flightnumber = normalizeCode(flightnumber)
+	if m := iataFlightnumberRegexp.FindStringSubmatch(flightnumber); m != nil {
+		if a,exists := r.byIATA[m[1]]; exists { return a, true }
+	}
+
+	for _,str := range []string{flightnumber, normalizeCode(callsign)} {
+		if m := icaoCallsignRegexp.FindStringSubmatch(str); m != nil {
+			if a,exists := r.byICAO[m[1]]; exists { return a, true }
+		}
+	}
+
+	return Airline{}, false
+}
+
+// ICAOPrefix is the airline part of an ICAO-form callsign (UAL337 gives UAL), whether or not the
+// airline is in a registry; it is blank for anything else (e.g. tail numbers).
+func ICAOPrefix(callsign string) string {
+	if m := icaoCallsignRegexp.FindStringSubmatch(normalizeCode(callsign)); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// ICAOCallsign converts an IATA flight number to the callsign it would fly under, e.g. UA337
+// becomes UAL337. Returns "" if the airline isn't known.
+func (r *Registry)ICAOCallsign(flightnumber string) string {
+	m := iataFlightnumberRegexp.FindStringSubmatch(normalizeCode(flightnumber))
+	if m == nil { return "" }
+	if a,exists := r.byIATA[m[1]]; exists && a.ICAO != "" {
+		return a.ICAO + m[2]
+	}
+	return ""
+}
+
+// }}}
+// {{{ Label
+
+// Label is a code with the operator's name, for reports, e.g. "UA  United Airlines". Codes that
+// aren't in the default registry are returned as is.
+func Label(code string) string {
+	if a,exists := Lookup(code); exists {
+		return fmt.Sprintf("%-3s %s", code, a.Name)
+	}
+	return code
+}
+
+// }}}
+// {{{ DefaultRegistry
+
+var defaultRegistry = config.DefaultTable[*Registry]{
+	Bundled: bundledRegistry,
+	Key:     "airline.table",
+	Parse:   ParseRegistry,
+	Overlay: (*Registry).Overlay,
+	Empty:   NewRegistry,
+}
+
+// LoadDefaultRegistry returns the airlines we know about; the bundled list, plus any local
+// additions from the file named by config "airline.table". The apps call it at startup, so
+// that a broken local file gets noticed; the registry still has the bundled airlines.
+func LoadDefaultRegistry() (*Registry, error) { return defaultRegistry.Load() }
+
+// DefaultRegistry is what the package-level lookups below use.
+func DefaultRegistry() *Registry { return defaultRegistry.Get() }
+
+// Lookup finds the code in the default registry.
+func Lookup(code string) (Airline, bool) {
+	return DefaultRegistry().Lookup(code)
+}
+
+// Resolve finds the flight's operator in the default registry.
+func Resolve(flightnumber, callsign string) (Airline, bool) {
+	return DefaultRegistry().Resolve(flightnumber, callsign)
+}
+
+// ICAOCallsign converts the flight number, using the default registry.
+func ICAOCallsign(flightnumber string) string {
+	return DefaultRegistry().ICAOCallsign(flightnumber)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
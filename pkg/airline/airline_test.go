@@ -0,0 +1,91 @@
+package airline
+
+import (
+	"strings"
+	"testing"
+)
+
+// {{{ TestResolve
+
+func TestResolve(t *testing.T) {
+	tests := []struct{
+		flightnumber, callsign string
+		expected string // ICAO code, or "" if it shouldn't resolve
+	}{
+		{"UA337", "", "UAL"},
+		{"UA337", "UAL337", "UAL"},
+		{"", "UAL337", "UAL"},       // callsign-only
+		{"UAL337", "", "UAL"},       // ICAO-form flight number
+		{"9E4012", "", "EDV"},       // IATA codes can start with a digit
+		{"AS1234A", "", "ASA"},      // operational suffix
+		{"", "ejA123", "EJA"},       // no IATA code
+		{"", "N123AB", ""},          // a tail number, not a callsign
+		{"", "N12345", ""},
+		{"ZZ123", "", ""},
+		{"", "", ""},
+	}
+
+	for _,test := range tests {
+		a,exists := Resolve(test.flightnumber, test.callsign)
+		if exists != (test.expected != "") || a.ICAO != test.expected {
+			t.Errorf("Resolve(%q,%q): expected %q, got %v (%v)", test.flightnumber, test.callsign,
+				test.expected, a, exists)
+		}
+	}
+
+	if a,_ := Lookup("ual"); a.Name != "United Airlines" || a.Code() != "UA" || a.Country != "US" {
+		t.Errorf("Lookup(ual): %v", a)
+	}
+	if a,_ := Lookup("EJA"); a.Code() != "EJA" {
+		t.Errorf("no IATA code, but got %q", a.Code())
+	}
+
+	if cs := ICAOCallsign("UA337"); cs != "UAL337" {
+		t.Errorf("ICAOCallsign(UA337): %q", cs)
+	}
+	if label := Label("WN"); label != "WN  Southwest Airlines" {
+		t.Errorf("Label(WN): %q", label)
+	}
+}
+
+// }}}
+// {{{ TestOverlay
+
+func TestOverlay(t *testing.T) {
+	overlay,err := ParseRegistry(strings.NewReader("# comment\nUA,UAL,UNITED,United,US\n,ZZZ,ZULU,Test Air,GB\n"))
+	if err != nil {
+		t.Fatalf("ParseRegistry: %v", err)
+	}
+
+	r,_ := ParseRegistry(strings.NewReader(bundledRegistry))
+	n := r.Len()
+	r.Overlay(overlay)
+
+	if r.Len() != n+1 {
+		t.Errorf("overlay: expected %d entries, got %d", n+1, r.Len())
+	}
+	if a,_ := r.Lookup("UA"); a.Name != "United" {
+		t.Errorf("overlay didn't replace UA: %s", a)
+	}
+	if a,_ := r.Resolve("", "ZZZ12"); a.Name != "Test Air" {
+		t.Errorf("overlay didn't add ZZZ: %s", a)
+	}
+
+	if _,err := ParseRegistry(strings.NewReader("UAX,UAL,UNITED,United,US\n")); err == nil {
+		t.Errorf("expected an error for a bad IATA code")
+	}
+
+	if ICAOPrefix("zzz12") != "ZZZ" || ICAOPrefix("N123AB") != "" {
+		t.Errorf("ICAOPrefix: %q, %q", ICAOPrefix("zzz12"), ICAOPrefix("N123AB"))
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
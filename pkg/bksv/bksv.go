@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -16,6 +17,7 @@ import (
 
 	"github.com/skypies/util/date"
 	
+	"github.com/skypies/complaints/pkg/airline"
 	"github.com/skypies/complaints/pkg/complaintdb"
 	"github.com/skypies/complaints/pkg/config"
 )
@@ -27,11 +29,61 @@ import (
 //https://viewpoint.emsbk.com/<sitename>?response=json
 //const bksvHost = "complaints-us.emsbk.com"
 const bksvHost = "viewpoint.emsbk.com"
-const bksvPath = "/sfo5" + "?response=json" // response *must* be a GET param, not POST
+const bksvPath = "/sfo5"
 
-// {{{ PopulateForm
+// {{{ Site
 
+// Site is one airport noise office's BKSV complaint site. BKSV run a site per office, all with
+// the same API; they differ in URL, and in which airport code goes in the form.
+type Site struct {
+	Airport    string // ICAO code, e.g. "KSJC"
+	URL        string // e.g. "https://viewpoint.emsbk.com/sfo5"
+	SchemaFile string // If set, load the form schema from here, rather than fetching it
+}
+
+// DefaultSite is SFO's, which is where we started.
+var DefaultSite = Site{Airport: "KSFO", URL: "https://" + bksvHost + bksvPath}
+
+// NewSite takes the URL without the scheme ("viewpoint.emsbk.com/sjc1") or with it (for testing
+// against something local, e.g. "http://localhost:8080/sfo5").
+func NewSite(airport, spec string) (Site, error) {
+	if airport == "" || spec == "" {
+		return Site{}, fmt.Errorf("bksv.NewSite: need both airport and URL, got %q,%q", airport, spec)
+	}
+	if !strings.Contains(spec, "://") {
+		spec = "https://" + spec
+	}
+	u,err := url.Parse(spec)
+	if err != nil || u.Host == "" {
+		return Site{}, fmt.Errorf("bksv.NewSite: bad URL %q", spec)
+	}
+	return Site{Airport: strings.ToUpper(airport), URL: spec}, nil
+}
+
+// Name identifies the site in Submission.Backend, e.g. "bksv:viewpoint.emsbk.com/sfo5"
+func (s Site)Name() string {
+	return "bksv:" + strings.TrimPrefix(strings.TrimPrefix(s.URL, "https://"), "http://")
+}
+
+func (s Site)String() string { return s.Airport + "=" + s.Name() }
+
+// Submit makes Site a submitter.Submitter.
+func (s Site)Submit(client *http.Client, c complaintdb.Complaint) (*complaintdb.Submission, error) {
+	return s.PostComplaint(client, c)
+}
+
+// PopulateForm and PostComplaint, for SFO.
 func PopulateForm(c complaintdb.Complaint, submitkey string) url.Values {
+	return DefaultSite.PopulateForm(c)
+}
+func PostComplaint(client *http.Client, c complaintdb.Complaint) (*complaintdb.Submission, error) {
+	return DefaultSite.PostComplaint(client, c)
+}
+
+// }}}
+// {{{ s.PopulateForm
+
+func (s Site)PopulateForm(c complaintdb.Complaint) url.Values {
 	first,last := c.Profile.SplitName()
 	if c.Activity == "" { c.Activity = "Loud noise" }
 
@@ -45,11 +97,6 @@ func PopulateForm(c complaintdb.Complaint, submitkey string) url.Values {
 		address1 = addr.Number + " " + addr.Street
 	}
 
-	browser_version := c.Browser.Version
-	if (len(browser_version) > 50) {
-		browser_version = browser_version[0:49]
-	}
-
 	getEventDescription := func(in int, brakes bool) string {
 		loudVals := map[int]string{1: "Loud", 2:"Very Loud", 3:"Excessively Loud"}
 		val := "Loud"
@@ -78,7 +125,7 @@ func PopulateForm(c complaintdb.Complaint, submitkey string) url.Values {
 		"state":            {addr.State},
 		"email":            {c.Profile.EmailAddress},
 
-		"airports":         {"KSFO"},  // KOAK, KSJC, KSAN
+		"airports":         {s.Airport},  // KSFO, KOAK, KSJC, KSAN
 		"month":            {date.InPdt(c.Timestamp).Format("1")},
 		"day":              {date.InPdt(c.Timestamp).Format("2")},
 		"year":             {date.InPdt(c.Timestamp).Format("2006")},
@@ -107,37 +154,58 @@ func PopulateForm(c complaintdb.Complaint, submitkey string) url.Values {
 		"cellphone": {""},
 
 		"browser_name":     {c.Browser.Name},
-		"browser_version":  {browser_version},
+		"browser_version":  {c.Browser.Version}, // Length checked by the schema
 		"browser_vendor":   {c.Browser.Vendor},
 		"browser_uuid":     {c.Browser.UUID},
 		"browser_platform": {c.Browser.Platform},
 	}
 
+	al,isAirline := c.AircraftOverhead.Airline()
+
 	if c.AircraftOverhead.FlightNumber != "" {
-		vals.Add("acid", c.AircraftOverhead.Callsign)
+		acid := c.AircraftOverhead.Callsign
+		if acid == "" {
+			acid = airline.ICAOCallsign(c.AircraftOverhead.FlightNumber) // BKSV wants UAL123, not UA123
+		}
+		vals.Add("acid", acid)
 		vals.Add("aacode", c.AircraftOverhead.Id2)
 		vals.Add("tailnumber", c.AircraftOverhead.Registration)
 
 
 		//vals.Add("adflag", "??") // Operation type (A, D or O for Arr, Dept or Overflight)
 		//vals.Add("beacon", "??") // Squawk SSR code (eg 2100)
+	} else if c.Unscheduled {
+		// No flight number; send whatever identifiers we have. If the callsign doesn't tell us it
+		// was an airline flight after all, say it was unscheduled in the comments.
+		vals.Add("acid", c.AircraftOverhead.Callsign)
+		vals.Add("aacode", c.AircraftOverhead.Id2)
+		vals.Add("tailnumber", c.AircraftOverhead.Registration)
+		if !isAirline {
+			vals.Set("comments", strings.TrimSpace(c.Description + " [Unscheduled aircraft: " +
+				c.AircraftOverhead.BestIdent() + "]"))
+		}
+	}
+
+	if isAirline {
+		vals.Add("airline", al.ICAO)
 	}
 
 	return vals
 }
 
 // }}}
-// {{{ PostComplaint
+// {{{ s.PostComplaint
 
 // https://complaints-staging.bksv.com/sfo2?json=1&resp=json
 // {"result":"1",
 //  "title":"Complaint Received",
 //  "body":"Thank you. We have received your complaint."}
 
-func PostComplaint(client *http.Client, c complaintdb.Complaint) (*complaintdb.Submission, error) {
+func (site Site)PostComplaint(client *http.Client, c complaintdb.Complaint) (*complaintdb.Submission, error) {
 
 	// Initialize a new submission object, inheriting from previous
 	s := complaintdb.Submission{
+		Backend:   site.Name(),
 		Attempts:  c.Submission.Attempts + 1,
 		Log:       c.Submission.Log+fmt.Sprintf("\n--------=={ PostComplaint @ %s }==-\n", time.Now()),
 		Key:       c.Submission.Key, // We're now keyless, should prob strip this out
@@ -152,21 +220,45 @@ func PostComplaint(client *http.Client, c complaintdb.Complaint) (*complaintdb.S
 	// keyless now.
 	s.Log += fmt.Sprintf("----{ time: %s }----\n  --{ keyless submission }--\n", s.T)
 
-	vals := PopulateForm(c, "")
+	vals := site.PopulateForm(c)
+
+	// Check the form against the site's schema. If we can't get the schema, Schema falls back to
+	// the last good one (or the bundled one).
+	schema,err := site.Schema(client)
+	if err != nil {
+		s.Log += fmt.Sprintf("Schema unavailable, validating with a fallback: %v\n", err)
+	}
+	fixes,problems := schema.Check(vals)
+	for _,fix := range fixes { s.Log += fmt.Sprintf("Schema fix: %s\n", fix) }
+	if len(problems) > 0 {
+		for _,p := range problems { s.Log += fmt.Sprintf("Schema problem: %s\n", p) }
+		s.Outcome = complaintdb.SubmissionInvalid
+		s.Response = problemsResponse(problems)
+		s.D = time.Since(s.T)
+		return &s,fmt.Errorf("ComplaintPOST: form failed validation: %v", problems)
+	}
+
 	s.Log += "Submitting these vals:-\n"
 	for k,v := range vals { s.Log += fmt.Sprintf(" * %-20.20s: %v\n", k, v) }
 	s.Log += "\n"
 
 	// resp,err := client.PostForm("https://"+bksvHost+bksvPath, vals)
-	req,_ := http.NewRequest("POST", "https://"+bksvHost+bksvPath, strings.NewReader(vals.Encode()))
+	// response *must* be a GET param, not POST
+	req,err := http.NewRequest("POST", site.URL+"?response=json", strings.NewReader(vals.Encode()))
+	if err != nil {
+		s.Log += fmt.Sprintf("ComplaintPOST: NewRequest: %v\n", err)
+		return &s,err
+	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded") // This is important
 	reqBytes,_ := httputil.DumpRequestOut(req,true)
-	s.Log += "Full req to ["+bksvHost+"]:-\n--\n"+string(reqBytes)+"\n--\n\n"
+	s.Log += "Full req to ["+site.URL+"]:-\n--\n"+string(reqBytes)+"\n--\n\n"
 	resp,err := client.Do(req)
 
 	s.D = time.Since(s.T)
 	if err != nil {
-		if strings.Contains(err.Error(), "DEADLINE_EXCEEDED") {
+		if netErr,ok := err.(net.Error); ok && netErr.Timeout() {
+			s.Outcome = complaintdb.SubmissionTimeout
+		} else if strings.Contains(err.Error(), "DEADLINE_EXCEEDED") {
 			s.Outcome = complaintdb.SubmissionTimeout
 		}
 		s.Log += fmt.Sprintf("ComplaintPOST: Posting error (dur=%s): %v\n", s.D, err)
@@ -244,14 +336,14 @@ Or more recently,
 	// Extract the foreign key for this complaint
 	found := false
 	if v = jsonMap["receipt_key"]; v != nil {
-		s.Key = fmt.Sprintf("%.0f", jsonMap["receipt_key"].(string))	
+		s.Key = fmt.Sprintf("%v", v)
 		s.Log += "Json Success !\n"
 		s.Outcome = complaintdb.SubmissionAccepted
 		found = true
 	} else if r := jsonMap["complaint_receipt_keys"]; r != nil {
 		if v, isSlice := r.([]interface{}); isSlice {
 			if len(v) > 0 {
-				s.Key = fmt.Sprintf("%.0f", v[0].(string))	
+				s.Key = fmt.Sprintf("%v", v[0])
 				s.Log += "Json Success !\n"
 				s.Outcome = complaintdb.SubmissionAccepted
 				found = true
@@ -0,0 +1,201 @@
+package bksv_test
+
+import(
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skypies/complaints/pkg/bksv"
+	"github.com/skypies/complaints/pkg/bksv/bksvtest"
+	"github.com/skypies/complaints/pkg/complaintdb"
+	"github.com/skypies/complaints/pkg/flightid"
+)
+
+// {{{ makeComplaint
+
+func makeComplaint() complaintdb.Complaint {
+	return complaintdb.Complaint{
+		Timestamp: time.Date(2023, time.August, 8, 21, 15, 0, 0, time.UTC),
+		Description: "Very loud",
+		Loudness: 2,
+		AircraftOverhead: flightid.Aircraft{FlightNumber:"UA337", Callsign:"UAL337", Id2:"A1B2C3"},
+		Profile: complaintdb.ComplainerProfile{
+			EmailAddress: "a@b.cc",
+			FullName: "A Tester",
+			Address: "1 Some St, Palo Alto, CA 94301",
+			StructuredAddress: complaintdb.PostalAddress{
+				Number: "1", Street: "Some St", City: "Palo Alto", State: "CA", Zip: "94301",
+			},
+		},
+	}
+}
+
+// }}}
+
+// {{{ TestPostComplaint
+
+func TestPostComplaint(t *testing.T) {
+	srv := bksvtest.NewServer()
+	defer srv.Close()
+
+	site,err := bksv.NewSite("KSJC", srv.SiteURL())
+	if err != nil { t.Fatal(err) }
+	client := &http.Client{Timeout: 500 * time.Millisecond}
+
+	tests := []struct{
+		mode     bksvtest.Mode
+		outcome  complaintdb.SubmissionOutcome
+		reason   complaintdb.SubmissionRejectReason
+	}{
+		{bksvtest.Accept,       complaintdb.SubmissionAccepted, complaintdb.SubmissionNoReject},
+		{bksvtest.AcceptLegacy, complaintdb.SubmissionAccepted, complaintdb.SubmissionNoReject},
+		{bksvtest.DupeReceipt,  complaintdb.SubmissionRejected, complaintdb.SubmissionRejectDupeReceipt},
+		{bksvtest.Constraint,   complaintdb.SubmissionRejected, complaintdb.SubmissionRejectConstraint},
+		{bksvtest.BadAPIKey,    complaintdb.SubmissionRejected, complaintdb.SubmissionRejectBadApiKey},
+		{bksvtest.MissingField, complaintdb.SubmissionRejected, complaintdb.SubmissionRejectBadField},
+		{bksvtest.HTML,         complaintdb.SubmissionFailed,   complaintdb.SubmissionNoReject},
+		{bksvtest.ServerError,  complaintdb.SubmissionFailed,   complaintdb.SubmissionNoReject},
+		{bksvtest.Timeout,      complaintdb.SubmissionTimeout,  complaintdb.SubmissionNoReject},
+	}
+
+	for _,test := range tests {
+		srv.Script(test.mode)
+		c := makeComplaint()
+		c.Submission.Attempts = 2
+
+		sub,err := site.PostComplaint(client, c)
+		if sub == nil {
+			t.Fatalf("%s: nil submission (err=%v)", test.mode, err)
+		}
+		if (err == nil) != (test.outcome == complaintdb.SubmissionAccepted) {
+			t.Errorf("%s: unexpected err: %v", test.mode, err)
+		}
+		if sub.Outcome != test.outcome {
+			t.Errorf("%s: outcome %s, expected %s\n%s", test.mode, sub.Outcome, test.outcome, sub.Log)
+		}
+		if reason,_ := sub.ClassifyRejection(); reason != test.reason {
+			t.Errorf("%s: rejection %s, expected %s", test.mode, reason, test.reason)
+		}
+		if sub.Outcome == complaintdb.SubmissionAccepted && len(sub.Key) != 40 {
+			t.Errorf("%s: bad receipt key %q", test.mode, sub.Key)
+		}
+		if sub.Attempts != 3 || sub.Backend != site.Name() {
+			t.Errorf("%s: attempts=%d, backend=%q", test.mode, sub.Attempts, sub.Backend)
+		}
+	}
+
+	if _,text := (complaintdb.Submission{}).ClassifyRejection(); text != "not rejected" {
+		t.Errorf("ClassifyRejection on empty: %q", text)
+	}
+
+	forms := srv.Received()
+	if len(forms) != len(tests) {
+		t.Fatalf("server received %d posts, expected %d", len(forms), len(tests))
+	}
+	if f := forms[0]; f.Get("airports") != "KSJC" || f.Get("acid") != "UAL337" || f.Get("hour") != "14" {
+		t.Errorf("bad form: %v", f)
+	}
+}
+
+// }}}
+// {{{ TestValidation
+
+func TestValidation(t *testing.T) {
+	srv := bksvtest.NewServer()
+	defer srv.Close()
+	srv.APIKey = "sekrit"
+
+	site := bksv.Site{Airport:"KSFO", URL:srv.SiteURL()}
+
+	// A valid form, but the wrong API key
+	sub,_ := site.PostComplaint(&http.Client{}, makeComplaint())
+	if reason,text := sub.ClassifyRejection(); reason != complaintdb.SubmissionRejectBadApiKey {
+		t.Errorf("expected bad api key, got %s: %s", reason, text)
+	}
+	srv.APIKey = ""
+
+	// Things that can't be fixed don't get posted
+	c := makeComplaint()
+	c.Profile.StructuredAddress.City = " "
+	sub,err := site.PostComplaint(&http.Client{}, c)
+	if err == nil || sub.Outcome != complaintdb.SubmissionInvalid {
+		t.Errorf("expected invalid, got %s (err=%v)", sub.Outcome, err)
+	}
+	if reason,text := sub.ClassifyRejection(); reason != complaintdb.SubmissionRejectBadField || text != "[city]" {
+		t.Errorf("expected bad field [city], got %s: %s", reason, text)
+	}
+
+	site.Airport = "KLAX"
+	sub,_ = site.PostComplaint(&http.Client{}, makeComplaint())
+	if reason,text := sub.ClassifyRejection(); reason != complaintdb.SubmissionRejectBadField || text != "[airports]" {
+		t.Errorf("expected bad field [airports], got %s: %s", reason, text)
+	}
+	site.Airport = "KSFO"
+
+	if n := len(srv.Received()); n != 1 {
+		t.Errorf("server received %d posts, expected 1", n)
+	}
+
+	// Things that can be fixed get fixed
+	c = makeComplaint()
+	c.Description = strings.Repeat("Loud! ", 2000)
+	c.Browser.Version = strings.Repeat("9", 48) + "é" // 50 bytes
+	c.Profile.StructuredAddress.State = " ca"
+	if sub,err := site.PostComplaint(&http.Client{}, c); err != nil {
+		t.Fatalf("expected fixable form to be accepted, got %v\n%s", err, sub.Log)
+	}
+	forms := srv.Received()
+	f := forms[len(forms)-1]
+	if len(f.Get("comments")) != 10000 || f.Get("browser_version") != strings.Repeat("9", 48) || f.Get("state") != "CA" {
+		t.Errorf("form not fixed: comments=%d, browser_version=%d, state=%q", len(f.Get("comments")),
+			len(f.Get("browser_version")), f.Get("state"))
+	}
+
+	// The schema was fetched just once
+	if n := srv.SchemaFetches(); n != 1 {
+		t.Errorf("schema fetched %d times", n)
+	}
+
+	// ... and can come from a file instead
+	site.SchemaFile = "field_defs.json"
+	if sc,err := site.Schema(&http.Client{}); err != nil {
+		t.Error(err)
+	} else if sc.FieldDefs["comments"].MaxLength != 10000 || sc.AirportCodes()["KSJC"] == "" {
+		t.Errorf("bad schema from file: %v", sc)
+	}
+	if n := srv.SchemaFetches(); n != 1 {
+		t.Errorf("schema fetched %d times", n)
+	}
+
+	// If the site's schema can't be fetched, the bundled one is used; and the site isn't asked
+	// again for a while
+	nFetches := 0
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nFetches++
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+	downSite := bksv.Site{Airport:"KSFO", URL:down.URL + "/sfo5"}
+	for i:=0; i<2; i++ {
+		if sc,err := downSite.Schema(&http.Client{}); err == nil {
+			t.Errorf("expected an error from a down site")
+		} else if sc == nil || sc.FieldDefs["acid"].MaxLength != 10 {
+			t.Errorf("expected the bundled schema, got %v", sc)
+		}
+	}
+	if nFetches != 1 {
+		t.Errorf("down site was asked for its schema %d times", nFetches)
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
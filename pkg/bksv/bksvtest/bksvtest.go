@@ -0,0 +1,322 @@
+package bksvtest
+
+// Package bksvtest is a fake BKSV complaint site, for testing submissions without talking to
+// the real thing. It checks posted forms against the schema bundled with pkg/bksv (and served
+// here at ?json=1, as the real sites do), and replies with the same JSON the real sites
+// send. It can be scripted to fail in each of the ways we've seen them fail.
+//
+//   srv := bksvtest.NewServer()
+//   defer srv.Close()
+//   srv.Script(bksvtest.DupeReceipt, bksvtest.Accept)
+//   site := bksv.Site{Airport:"KSFO", URL:srv.SiteURL()}
+
+import(
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/skypies/complaints/pkg/bksv"
+)
+
+// {{{ Mode
+
+// Mode is how the server responds to a post.
+type Mode int
+const(
+	Accept        Mode = iota // Validate the form; if it's OK, accept it with a complaint_receipt_keys
+	AcceptLegacy              // As Accept, but with the older receipt_key
+	DupeReceipt               // Reject: duplicate entry for key 'receipt_key_UNIQUE'
+	Constraint                // Reject: a foreign key constraint fails
+	BadAPIKey                 // Reject: validateSubmitKey returned false
+	MissingField              // Reject: as if address1 had been left blank
+	HTML                      // Send a 200 with an HTML page, instead of JSON
+	ServerError               // Send a 500
+	Timeout                   // Don't respond until the client gives up
+)
+
+func (m Mode)String() string {
+	return [...]string{"accept", "accept-legacy", "dupe-receipt", "constraint", "bad-apikey",
+		"missing-field", "html", "server-error", "timeout"}[m]
+}
+
+// }}}
+// {{{ Server
+
+type Server struct {
+	*httptest.Server
+	Schema   Schema
+	APIKey   string // If set, posts must carry this apiKey
+	Default  Mode   // What to do once the script has run out
+
+	mu       sync.Mutex
+	script   []Mode
+	received []url.Values
+	nAccepts int
+	nSchemas int
+	stop     chan struct{}
+}
+
+// NewServer starts a server that accepts valid posts.
+func NewServer() *Server {
+	s := &Server{Default: Accept, stop: make(chan struct{})}
+	if err := json.Unmarshal(bksv.BundledSchemaJSON, &s.Schema); err != nil {
+		panic(fmt.Errorf("bksvtest: bundled schema: %v", err))
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Close unblocks any Timeout requests, then shuts down the server.
+func (s *Server)Close() {
+	close(s.stop)
+	s.Server.Close()
+}
+
+// SiteURL is the URL of the fake sfo5 site, for bksv.Site.
+func (s *Server)SiteURL() string { return s.URL + "/sfo5" }
+
+// Script queues up responses for the next posts, in order.
+func (s *Server)Script(modes ...Mode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = append(s.script, modes...)
+}
+
+// SchemaFetches is how many times the schema has been fetched.
+func (s *Server)SchemaFetches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nSchemas
+}
+
+// Received returns the forms posted so far.
+func (s *Server)Received() []url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]url.Values{}, s.received...)
+}
+
+func (s *Server)next(form url.Values) Mode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = append(s.received, form)
+	if len(s.script) == 0 {
+		return s.Default
+	}
+	m := s.script[0]
+	s.script = s.script[1:]
+	return m
+}
+
+func (s *Server)receiptKey() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nAccepts++
+	return fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("receipt-%d", s.nAccepts))))
+}
+
+// }}}
+// {{{ s.serveHTTP
+
+func (s *Server)serveHTTP(w http.ResponseWriter, r *http.Request) {
+	site := strings.Trim(r.URL.Path, "/")
+
+	if r.Method == "GET" {
+		if r.URL.Query().Get("json") != "" {
+			s.mu.Lock()
+			s.nSchemas++
+			s.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(bksv.BundledSchemaJSON)
+		} else {
+			sendHTML(w)
+		}
+		return
+	}
+
+	r.ParseForm()
+	mode := s.next(r.PostForm)
+
+	// The real sites only send JSON if it's asked for in the URL; a POST param won't do.
+	if r.URL.Query().Get("response") != "json" {
+		sendHTML(w)
+		return
+	}
+
+	switch mode {
+	case HTML:
+		sendHTML(w)
+	case ServerError:
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	case Timeout:
+		select {
+		case <-r.Context().Done():
+		case <-s.stop:
+		}
+	case DupeReceipt:
+		sendError(w, "Error inserting into database.Duplicate entry '" + s.receiptKey() +
+			"' for key 'receipt_key_UNIQUE'")
+	case Constraint:
+		sendError(w, "Error inserting into database.Cannot add or update a child row: a foreign"+
+			" key constraint fails (`"+site+"`.`submissions`, CONSTRAINT `complaints_fkey5` FOREIGN"+
+			" KEY (`browser_id`) REFERENCES `browsers` (`browser_id`) ON DELETE NO ACTION ON UPDATE"+
+			" NO ACTION)")
+	case BadAPIKey:
+		sendError(w, "validateSubmitKey returned false")
+
+	default:
+		if s.APIKey != "" && r.PostForm.Get("apiKey") != s.APIKey {
+			sendError(w, "validateSubmitKey returned false")
+			return
+		}
+		form := r.PostForm
+		if mode == MissingField {
+			form = url.Values{}
+			for k,v := range r.PostForm { form[k] = v }
+			form.Del("address1")
+		}
+		if submitted,ok := s.Schema.Validate(form); !ok {
+			sendJSON(w, map[string]interface{}{
+				"body": "There are some problems. Please correct the mistakes and submit the form again.",
+				"debug": "",
+				"required": s.Schema.Required(),
+				"result": "0",
+				"site_name": site,
+				"submitted": submitted,
+			})
+		} else if mode == AcceptLegacy {
+			sendJSON(w, map[string]interface{}{
+				"body": "Thank you, your submission has been received.",
+				"receipt_key": s.receiptKey(),
+				"result": "1",
+				"title": "Submission Received",
+			})
+		} else {
+			sendJSON(w, map[string]interface{}{
+				"body": "Thank you, your submission has been received.",
+				"complaint_receipt_keys": []string{s.receiptKey()},
+				"receipt_key": nil,
+				"result": "1",
+				"title": "Submission Received",
+			})
+		}
+	}
+}
+
+func sendJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func sendError(w http.ResponseWriter, e string) {
+	sendJSON(w, map[string]interface{}{
+		"body": "<p>Your noise report was not submitted because there was a problem with this online"+
+			" noise report system.</p>\n",
+		"debug": "\nsubmitting" + e,
+		"error": e,
+		"result": "0",
+		"title": "Error",
+	})
+}
+
+func sendHTML(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte("<html><body><h1>Complaint Received</h1>\n" +
+		"<p>Thank you. We have received your complaint.</p></body></html>\n"))
+}
+
+// }}}
+// {{{ Schema
+
+// Schema is the part of the site's form definition that we check posts against.
+type Schema struct {
+	Airports  string              `json:"airports"` // A JSON object, as a string
+	FieldDefs map[string]FieldDef `json:"field_defs"`
+	Fields    []string            `json:"fields"`
+}
+
+type FieldDef struct {
+	MaxLength int    `json:"maxlength"`
+	Required  bool   `json:"required"`
+	Scope     string `json:"scope"`
+	Type      string `json:"type"`
+	Label     string `json:"label"`
+}
+
+// Some schema fields are posted as several form fields.
+var compositeFields = map[string][]string{
+	"date": []string{"month", "day", "year"},
+	"time": []string{"hour", "min"},
+}
+
+// Required lists the fields that must be filled in.
+func (sc Schema)Required() []string {
+	ret := []string{}
+	for _,name := range sc.Fields {
+		if sc.FieldDefs[name].Required { ret = append(ret, name) }
+	}
+	return ret
+}
+
+// FieldResult is how the real sites report on each submitted field.
+type FieldResult struct {
+	Error     string      `json:"error"`
+	Formatted interface{} `json:"formatted"`
+	OK        bool        `json:"ok"`
+	Value     string      `json:"value"`
+}
+
+// Validate checks the form against the schema's field definitions, and reports on every field.
+func (sc Schema)Validate(form url.Values) (map[string]FieldResult, bool) {
+	airports := map[string]string{}
+	json.Unmarshal([]byte(sc.Airports), &airports)
+
+	allOK := true
+	ret := map[string]FieldResult{}
+	for _,name := range sc.Fields {
+		def := sc.FieldDefs[name]
+		if def.Type == "ignore" || def.Type == "content" { continue }
+
+		val := form.Get(name)
+		if parts,exists := compositeFields[name]; exists {
+			vals := []string{}
+			for _,part := range parts {
+				if v := form.Get(part); v != "" { vals = append(vals, v) }
+			}
+			if len(vals) == len(parts) { val = strings.Join(vals, "/") }
+		}
+
+		res := FieldResult{OK: true, Value: val}
+		switch {
+		case def.Required && strings.TrimSpace(val) == "":
+			res.Error = "Please fill in"
+		case def.MaxLength > 0 && len(val) > def.MaxLength:
+			res.Error = fmt.Sprintf("Value is too long (max %d)", def.MaxLength)
+		case def.Type == "email" && val != "" && !strings.Contains(val, "@"):
+			res.Error = "Not a valid email address"
+		case name == "airports" && val != "" && airports[val] == "":
+			res.Error = "Not a valid airport"
+		}
+		if res.Error != "" {
+			res.OK, allOK = false, false
+		}
+		ret[name] = res
+	}
+
+	return ret, allOK
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
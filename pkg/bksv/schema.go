@@ -0,0 +1,282 @@
+package bksv
+
+// Each BKSV site publishes its form schema at <siteurl>?json=1 (see the Notes at the bottom of
+// bksv.go). We check every form against it before posting, fixing what we safely can (trimming
+// whitespace, truncating long text); anything we can't fix means the complaint isn't sent, and
+// is marked as locally invalid, rather than being rejected by BKSV. If a site's schema can't be
+// fetched, we fall back to the last one it gave us, or to the one bundled in field_defs.json.
+
+import(
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/skypies/complaints/pkg/config"
+)
+
+const(
+	KSchemaMaxAge = 24 * time.Hour
+	KSchemaRetryAfter = 5 * time.Minute // After a failed fetch, don't ask the site again for this long
+)
+
+// BundledSchemaJSON is sfo5's schema, as fetched from the site (plus the aircraft fields).
+//go:embed field_defs.json
+var BundledSchemaJSON []byte
+
+// {{{ Schema
+
+type Schema struct {
+	Airports  string              `json:"airports"` // A JSON object of code:name, as a string
+	FieldDefs map[string]FieldDef `json:"field_defs"`
+	Fields    []string            `json:"fields"`
+}
+
+type FieldDef struct {
+	MaxLength int    `json:"maxlength"` // 0 means no limit
+	Required  bool   `json:"required"`
+	Scope     string `json:"scope"`     // profile, complaint, about, or ignore
+	Type      string `json:"type"`      // text, textarea, email, tel, list, boolean, datetime, ...
+	Label     string `json:"label"`
+	MaxBytes  int    `json:"-"`         // 0 means no limit; only used by extraFieldDefs
+}
+
+// Some schema fields are posted as several form fields.
+var compositeFields = map[string][]string{
+	"date": []string{"month", "day", "year"},
+	"time": []string{"hour", "min"},
+}
+
+// Limits on fields we send that aren't in the schema, but that BKSV enforce anyway. Note that
+// browser_version is limited to 49 bytes, not characters.
+var extraFieldDefs = map[string]FieldDef{
+	"browser_version": FieldDef{MaxBytes: 49, Type: "text"},
+}
+
+func ParseSchema(b []byte) (*Schema, error) {
+	sc := Schema{}
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return nil, fmt.Errorf("ParseSchema: %v", err)
+	} else if len(sc.FieldDefs) == 0 {
+		return nil, fmt.Errorf("ParseSchema: no field_defs")
+	}
+	return &sc, nil
+}
+
+func LoadSchemaFile(filename string) (*Schema, error) {
+	b,err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("LoadSchemaFile: %v", err)
+	}
+	return ParseSchema(b)
+}
+
+// FetchSchema gets the schema from a site.
+func FetchSchema(client *http.Client, siteURL string) (*Schema, error) {
+	resp,err := client.Get(siteURL + "?json=1")
+	if err != nil {
+		return nil, fmt.Errorf("FetchSchema: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("FetchSchema: bad status: %v", resp.Status)
+	}
+	b,err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("FetchSchema: %v", err)
+	}
+	return ParseSchema(b)
+}
+
+// AirportCodes are the values the site accepts for the airports field.
+func (sc Schema)AirportCodes() map[string]string {
+	airports := map[string]string{}
+	json.Unmarshal([]byte(sc.Airports), &airports)
+	return airports
+}
+
+// }}}
+// {{{ s.Schema
+
+type schemaEntry struct {
+	sc      *Schema   // The last good schema, if there has been one
+	fetched time.Time // When sc was fetched
+	failed  time.Time // When the last fetch failed, if it did since then
+	err     error     // Why it failed
+}
+
+var(
+	schemaMu    sync.Mutex
+	schemaCache = map[string]schemaEntry{}
+)
+
+// BundledSchema parses BundledSchemaJSON.
+func BundledSchema() *Schema {
+	sc,err := ParseSchema(BundledSchemaJSON)
+	if err != nil { panic(fmt.Sprintf("bksv: bundled schema: %v", err)) }
+	return sc
+}
+
+// fallback is the last good schema, or the bundled one if there hasn't been one.
+func (e schemaEntry)fallback() *Schema {
+	if e.sc != nil { return e.sc }
+	return BundledSchema()
+}
+
+// Schema returns the site's form schema. If the site has a SchemaFile, or config "bksv.schema"
+// names one, it's loaded from there; otherwise it's fetched from the site, and cached for
+// KSchemaMaxAge. If that fails, the error is returned along with a fallback schema (see
+// schemaEntry.fallback), and the site isn't asked again for KSchemaRetryAfter.
+func (s Site)Schema(client *http.Client) (*Schema, error) {
+	filename := s.SchemaFile
+	if filename == "" { filename = config.Get("bksv.schema") }
+	cacheKey := s.URL + "|" + filename
+
+	schemaMu.Lock()
+	entry := schemaCache[cacheKey]
+	schemaMu.Unlock()
+
+	if entry.sc != nil && time.Since(entry.fetched) < KSchemaMaxAge {
+		return entry.sc, nil
+	} else if !entry.failed.IsZero() && time.Since(entry.failed) < KSchemaRetryAfter {
+		return entry.fallback(), entry.err
+	}
+
+	// Don't hold the lock while fetching; a slow site shouldn't hold up the others
+	var sc *Schema
+	var err error
+	if filename != "" {
+		sc,err = LoadSchemaFile(filename)
+	} else {
+		sc,err = FetchSchema(client, s.URL)
+	}
+
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	entry = schemaCache[cacheKey]
+	if err != nil {
+		entry.failed, entry.err = time.Now(), err
+		schemaCache[cacheKey] = entry
+		return entry.fallback(), err
+	}
+	schemaCache[cacheKey] = schemaEntry{sc: sc, fetched: time.Now()}
+	return sc, nil
+}
+
+// }}}
+// {{{ sc.Check
+
+// FieldProblem is a field we couldn't fix.
+type FieldProblem struct {
+	Field   string
+	Value   string
+	Problem string
+}
+
+func (fp FieldProblem)String() string { return fmt.Sprintf("%s: %s (%q)", fp.Field, fp.Problem, fp.Value) }
+
+// Check fixes up the form in place, as far as it safely can. It returns a list of the changes it
+// made, and a list of the problems it couldn't fix; if there are any problems, the form should
+// not be posted.
+func (sc Schema)Check(vals url.Values) (fixes []string, problems []FieldProblem) {
+	defs := map[string]FieldDef{}
+	for name,def := range extraFieldDefs { defs[name] = def }
+	for name,def := range sc.FieldDefs { defs[name] = def }
+
+	names := []string{}
+	for name,_ := range defs { names = append(names, name) }
+	sort.Strings(names)
+
+	airports := sc.AirportCodes()
+
+	for _,name := range names {
+		def := defs[name]
+		if def.Scope == "ignore" || def.Scope == "about" || def.Type == "ignore" { continue }
+
+		if parts,exists := compositeFields[name]; exists {
+			for _,part := range parts {
+				if def.Required && strings.TrimSpace(vals.Get(part)) == "" {
+					problems = append(problems, FieldProblem{name, "", "missing " + part})
+				}
+			}
+			continue
+		}
+
+		val := vals.Get(name)
+		fixed := strings.TrimSpace(val)
+		if def.Type == "list" && name == "state" {
+			fixed = strings.ToUpper(fixed)
+		}
+		if def.MaxLength > 0 && len([]rune(fixed)) > def.MaxLength {
+			switch def.Type {
+			case "text", "textarea":
+				fixed = string([]rune(fixed)[:def.MaxLength])
+			default:
+				problems = append(problems, FieldProblem{name, val,
+					fmt.Sprintf("longer than %d", def.MaxLength)})
+			}
+		}
+		if def.MaxBytes > 0 && len(fixed) > def.MaxBytes {
+			fixed = truncateBytes(fixed, def.MaxBytes)
+		}
+		if fixed != val {
+			if _,present := vals[name]; present {
+				fixes = append(fixes, fmt.Sprintf("%s: %q -> %q", name, val, fixed))
+				vals.Set(name, fixed)
+			}
+		}
+
+		switch {
+		case def.Required && fixed == "":
+			problems = append(problems, FieldProblem{name, val, "required"})
+		case def.Type == "email" && fixed != "" && !strings.Contains(fixed, "@"):
+			problems = append(problems, FieldProblem{name, val, "not an email address"})
+		case name == "airports" && len(airports) > 0 && fixed != "" && airports[fixed] == "":
+			problems = append(problems, FieldProblem{name, val, "not an airport this site knows"})
+		}
+	}
+
+	return fixes, problems
+}
+
+// truncateBytes cuts the string down to at most n bytes, without splitting a character.
+func truncateBytes(str string, n int) string {
+	if len(str) <= n { return str }
+	for n > 0 && !utf8.RuneStart(str[n]) { n-- }
+	return str[:n]
+}
+
+// }}}
+// {{{ problemsResponse
+
+// problemsResponse renders problems in the same form as a BKSV missing-field rejection, so that
+// Submission.ClassifyRejection can make sense of it.
+func problemsResponse(problems []FieldProblem) []byte {
+	submitted := map[string]interface{}{}
+	for _,p := range problems {
+		submitted[p.Field] = map[string]interface{}{"error":p.Problem, "ok":false, "value":p.Value}
+	}
+	b,_ := json.Marshal(map[string]interface{}{
+		"body": "Not submitted; the form failed local validation.",
+		"result": "0",
+		"submitted": submitted,
+	})
+	return b
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -9,6 +9,7 @@ import (
 	"github.com/skypies/util/date"
 
 	"github.com/skypies/complaints/pkg/config"
+	"github.com/skypies/complaints/pkg/noiseclass"
 )
 
 func profile2fingerprint(p ComplainerProfile) string {
@@ -50,7 +51,6 @@ func AnonymizeComplaint(c *Complaint) *AnonymizedComplaint {
 		
 		// All of these fields might be nil.
 		FlightNumber: c.AircraftOverhead.FlightNumber,
-		AirlineCode: c.AircraftOverhead.IATAAirlineCode(),
 	
 		Origin: c.AircraftOverhead.Origin,
 		Destination: c.AircraftOverhead.Destination,
@@ -61,6 +61,19 @@ func AnonymizeComplaint(c *Complaint) *AnonymizedComplaint {
 		Groundspeed: c.AircraftOverhead.Speed,
 	}
 
+	if al,exists := c.AircraftOverhead.Airline(); exists {
+		ac.AirlineCode = al.Code()
+		ac.AirlineName = al.Name
+		ac.AirlineCountry = al.Country
+	}
+
+	if info,exists := noiseclass.Lookup(ac.EquipType); exists {
+		ac.EngineType = info.Engine
+		ac.WeightClass = info.WeightClass
+		ac.NoiseChapter = info.Chapter
+		ac.NoiseClass = info.NoiseClass()
+	}
+
 	if ac.HasIdenitifiedAircraft() {
 		ac.FlightKey = fmt.Sprintf("%s-%s", ac.FlightNumber,
 			date.InPdt(ac.Timestamp).Format("20060102"))
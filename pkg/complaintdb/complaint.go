@@ -1,10 +1,14 @@
 package complaintdb
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/skypies/util/date"
 	"github.com/skypies/geo"
+
+	"github.com/skypies/complaints/pkg/flightid"
+	"github.com/skypies/complaints/pkg/noiseclass"
 )
 
 const (
@@ -70,7 +74,7 @@ func FixupComplaint(c *Complaint, keyStr string) {
 	}
 
 	// 3. Compute distances, if we have an aircraft
-	if c.AircraftOverhead.FlightNumber != "" {
+	if c.AircraftOverhead.BestIdent() != "" {
 		a := c.AircraftOverhead
 		aircraftPos := geo.Latlong{a.Lat,a.Long}
 		observerPos := geo.Latlong{c.Profile.Lat, c.Profile.Long}
@@ -107,6 +111,106 @@ func Overwrite(this, from *Complaint) {
 	}
 }
 
+// }}}
+// {{{ CorrectFlight
+
+// CorrectFlight replaces the aircraft with a user-supplied flightnumber, wiping all the other
+// flight data. The first time, the automatic pick is preserved in AutoAircraftOverhead.
+func (c *Complaint)CorrectFlight(flightnumber string) {
+	if !c.FlightCorrected {
+		c.AutoAircraftOverhead = c.AircraftOverhead
+		c.FlightCorrected = true
+	}
+	c.AircraftOverhead = flightid.Aircraft{FlightNumber: flightnumber}
+	c.Unscheduled = false
+	c.Noise = noiseclass.Estimate{} // We no longer know where it was
+}
+
+// }}}
+// {{{ c.EstimateNoise
+
+// EstimateNoise models the sound level at the complainer from the aircraft overhead; it is left
+// zero if there isn't one, or we don't know where it was.
+func (c *Complaint)EstimateNoise() {
+	c.Noise = noiseclass.Estimate{}
+	oh := c.AircraftOverhead
+	if oh.BestIdent() == "" || oh.Dist3 <= 0 { return }
+	c.Noise = noiseclass.EstimateDB(oh.EquipType, oh.Dist3, oh.VerticalSpeed)
+}
+
+// }}}
+
+// {{{ c.UnscheduledIdent
+
+// UnscheduledIdent is the best identifier for an unscheduled aircraft (e.g. "r:N12345"), or ""
+// if the complaint was about a scheduled flight (or nothing was identified).
+func (c Complaint)UnscheduledIdent() string {
+	if !c.Unscheduled { return "" }
+	return c.AircraftOverhead.BestIdent()
+}
+
+// }}}
+// {{{ c.NoiseDBString
+
+// NoiseDBString is the estimated level as a plain number (e.g. "67"), or "" if there isn't one.
+func (c Complaint)NoiseDBString() string {
+	if c.Noise.IsZero() { return "" }
+	return fmt.Sprintf("%.0f", c.Noise.DB)
+}
+
+// }}}
+// {{{ c.IdentificationString
+
+// IdentificationString summarizes what we thought was overhead, e.g. "probably UA123 (0.80),
+// possibly AS45 (0.15)". It is empty if there were no candidates.
+func (c Complaint)IdentificationString() string {
+	if c.FlightCorrected {
+		return c.AircraftOverhead.FlightNumber // The user told us; we don't get a say
+	}
+
+	cands := []flightid.Candidate{}
+	if c.AircraftOverhead.BestIdent() != "" {
+		cands = append(cands, flightid.NewCandidate(c.AircraftOverhead, c.IdentConfidence))
+	}
+	cands = append(cands, c.Alternates...)
+
+	return flightid.CandidatesString(cands)
+}
+
+// }}}
+// {{{ c.SetIdentTrace, c.IdentTrace, c.IdentDebug
+
+// SetIdentTrace stores the trace (as JSON) on the complaint.
+func (c *Complaint)SetIdentTrace(tr flightid.IdentificationTrace) error {
+	b,err := tr.ToJSON()
+	if err != nil {
+		return fmt.Errorf("SetIdentTrace: %v", err)
+	}
+	c.IdentOutcome = tr.OutcomeCode
+	c.IdentTraceJSON = b
+	return nil
+}
+
+// IdentTrace decodes the stored trace. Old complaints don't have one, and get a zero trace.
+func (c Complaint)IdentTrace() (flightid.IdentificationTrace, error) {
+	return flightid.TraceFromJSON(c.IdentTraceJSON)
+}
+
+// IdentDebug is the human-readable version of the trace; or, for old complaints, the debug
+// text they stored instead.
+func (c Complaint)IdentDebug() string {
+	tr,err := c.IdentTrace()
+	if err != nil {
+		return err.Error()
+	} else if tr.IsZero() {
+		return c.Debug
+	}
+
+	str := c.Debug // Old complaints that have since been reidentified
+	if !c.Consensus.IsZero() { str += "**** " + c.Consensus.String() + "\n" }
+	return str + tr.String()
+}
+
 // }}}
 
 // {{{ -------------------------={ E N D }=----------------------------------
@@ -4,22 +4,25 @@ import (
 	"fmt"
 	pkglog "log"
 	"net/http"
-	"os"
 	"sort"
 	"time"
 
 	"golang.org/x/net/context"
 
 	"github.com/skypies/util/gcp/ds"
+
+	"github.com/skypies/complaints/pkg/elevation"
+	"github.com/skypies/complaints/pkg/flightid"
 )
 
 var(
 	kComplaintKind = "ComplaintKind"
 	kComplainerKind = "ComplainerKind"
 	KMaxComplaintsPerDay = 200
+	KMaxAlternates = 3 // How many runner-up aircraft to store on each complaint
 )
 
-// {{{ ComplaintDB{}, NewDB(), cdb.Ctx(), cdb.HTTPClient()
+// {{{ ComplaintDB{}, NewDB(), NewDBWithProvider(), cdb.Ctx(), cdb.HTTPClient(), cdb.Now()
 
 // ComplaintDB is a transient handle to the database
 type ComplaintDB struct {
@@ -28,30 +31,42 @@ type ComplaintDB struct {
 	admin     bool
 	Provider  ds.DatastoreProvider
 	Logger   *pkglog.Logger
+
+	clock     func() time.Time
+	client   *http.Client
+	airspace  flightid.AirspaceSource
+	snapshots flightid.SnapshotCorpus
+	elevation elevation.Service
+	altimeter flightid.AltimeterSource
+	tracks    flightid.TrackSource
 }
 func (cdb ComplaintDB)Ctx() context.Context { return cdb.ctx }
-func (cdb ComplaintDB)HTTPClient() *http.Client { return &http.Client{} }
 
-func NewDB(ctx context.Context) ComplaintDB {
-	props,propsOk := GetContextProperties(ctx)
+func (cdb ComplaintDB)HTTPClient() *http.Client {
+	if cdb.client != nil { return cdb.client }
+	return &http.Client{}
+}
 
-	projectId := "serfr0-1000"
-	if propsOk && props.ProjectId != "" {
-		projectId = props.ProjectId
-	}
-	
-	if p,err := ds.NewCloudDSProvider(ctx, projectId); err != nil {
-		panic(fmt.Errorf("NewDB: could not get a clouddsprovider (projectId=%s): %v\n", projectId, err))
+func (cdb ComplaintDB)Now() time.Time {
+	if cdb.clock != nil { return cdb.clock() }
+	return time.Now()
+}
 
-	} else {
-		return ComplaintDB{
-			ctx: ctx,
-			StartTime: time.Now(),
-			admin: (propsOk && props.IsAdmin==true),
-			Provider: p,
-			Logger: pkglog.New(os.Stderr, "", pkglog.Ldate|pkglog.Ltime), //|log.Lshortfile)
-		}
+// NewDB is the old constructor, retained for compatibility; it panics if it can't connect to
+// the datastore. Prefer New().
+func NewDB(ctx context.Context) ComplaintDB {
+	cdb,err := New(ctx)
+	if err != nil {
+		panic(fmt.Errorf("NewDB: %v\n", err))
 	}
+	return cdb
+}
+
+// NewDBWithProvider returns a handle that uses the given datastore provider (e.g. an
+// in-memory one, from pkg/memds), instead of connecting to cloud datastore.
+func NewDBWithProvider(ctx context.Context, p ds.DatastoreProvider) ComplaintDB {
+	cdb,_ := New(ctx, WithProvider(p)) // Can't fail when given a provider
+	return cdb
 }
 
 // }}}
@@ -130,6 +145,15 @@ func (cdb ComplaintDB)ComplaintKeyOwnedBy(keyer ds.Keyer, owner string) (bool,er
 	return true, nil
 }
 
+// ComplaintKeyOwner is the email address of the user who owns the complaint, read off its key
+// (so the complaint itself needn't be loaded). It is blank if the key has no parent.
+func (cdb ComplaintDB)ComplaintKeyOwner(keyer ds.Keyer) string {
+	if parentKeyer := cdb.Provider.KeyParent(keyer); parentKeyer != nil {
+		return cdb.Provider.KeyName(parentKeyer)
+	}
+	return ""
+}
+
 func (cdb ComplaintDB)ComplaintKeyStrOwnedBy(keyStr, owner string) (bool,error) {
 	keyer,err := cdb.Provider.DecodeKey(keyStr)
 	if err != nil {
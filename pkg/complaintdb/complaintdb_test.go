@@ -2,40 +2,38 @@ package complaintdb
 
 import (
 	"bytes"
+	"encoding/csv"
 	"fmt"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 
 	"golang.org/x/net/context"
 
+	"github.com/skypies/adsb"
+	fdb "github.com/skypies/flightdb"
+	"github.com/skypies/geo"
+	"github.com/skypies/pi/airspace"
+	"github.com/skypies/util/date"
 	"github.com/skypies/util/gcp/ds"
+
+	"github.com/skypies/complaints/pkg/flightid"
+	"github.com/skypies/complaints/pkg/memds"
+	"github.com/skypies/complaints/pkg/noiseclass"
 )
 
 const appid = "mytestapp"
 // {{{ newConsistentContext
 
-// A version of aetest.NewContext() that has a consistent datastore - so we can read our writes.
+// A context whose datastore is a fresh, strongly consistent in-memory one - so we can read our
+// writes, without needing any cloud access.
 func newConsistentContext() (context.Context, func(), error) {
-	// FIXME: figure out how to test cloud datastore apps
-	return context.NewContext(), nil, nil
-	/*
-	inst, err := aetest.NewInstance(&aetest.Options{
-		StronglyConsistentDatastore: true,
-		AppID: appid,
+	ctx := SetContextProperties(context.Background(), ContextProperties{
+		ProjectId: appid,
+		Provider: memds.NewProvider(),
 	})
-	if err != nil {
-		return nil, nil, err
-	}
-	req, err := inst.NewRequest("GET", "/", nil)
-	if err != nil {
-		inst.Close()
-		return nil, nil, err
-	}
-	ctx := appengine.NewContext(req)
-	return ctx, func() {
-		inst.Close()
-	}, nil
-*/
+	return ctx, func(){}, nil
 }
 
 // }}}
@@ -70,12 +68,10 @@ func makeComplaints(n int, p ComplainerProfile) []Complaint {
 
 func TestCoreAPI(t *testing.T) {
 	ctx, done, err := newConsistentContext()
-	p := ds.NewCloudDSProvider(ctx, "myproj")
 	if err != nil { t.Fatal(err) }
 	defer done()
 
 	cdb := NewDB(ctx)
-	cdb.Provider = p
 
 	// Quick test of profile calls
 	//
@@ -198,15 +194,595 @@ func TestCoreAPI(t *testing.T) {
 
 // }}}
 
+// {{{ TestOptions
+
+func TestOptions(t *testing.T) {
+	ctx := context.Background()
+	tm := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	client := &http.Client{}
+
+	cdb,err := New(ctx,
+		WithProvider(memds.NewProvider()),
+		WithClock(func() time.Time { return tm }),
+		WithHTTPClient(client),
+		WithAdmin(true))
+	if err != nil { t.Fatal(err) }
+
+	if !cdb.Now().Equal(tm) || !cdb.StartTime.Equal(tm) {
+		t.Errorf("clock option ignored; Now()=%s", cdb.Now())
+	}
+	if cdb.HTTPClient() != client {
+		t.Errorf("HTTP client option ignored")
+	}
+
+	c := makeComplaints(1, makeProfile("a@b.cc"))[0]
+	if err := cdb.PersistComplaint(c); err != nil { t.Fatal(err) }
+	first,err := cdb.LookupFirst(cdb.NewComplaintQuery())
+	if err != nil || first == nil { t.Fatalf("LookupFirst: %v", err) }
+	if _,err := cdb.LookupKey(first.DatastoreKey, "no@such.owner.com"); err != nil {
+		t.Errorf("admin option ignored; LookupKey with other owner: %v", err)
+	}
+}
+
+// }}}
+
+// {{{ TestComplainByEmailAddress
+
+type emptyAirspaceSource struct{}
+func (s emptyAirspaceSource)String() string { return "empty" }
+func (s emptyAirspaceSource)FetchAirspace(box geo.LatlongBox) (*airspace.Airspace, error) {
+	as := airspace.NewAirspace()
+	return &as, nil
+}
+
+func TestComplainByEmailAddress(t *testing.T) {
+	ctx, done, err := newConsistentContext()
+	if err != nil { t.Fatal(err) }
+	defer done()
+
+	cdb,err := New(ctx, WithAirspaceSource(emptyAirspaceSource{}), WithTrackSource(nil))
+	if err != nil { t.Fatal(err) }
+
+	profile := makeProfile("a@b.cc")
+	if err := cdb.PersistProfile(profile); err != nil { t.Fatal(err) }
+
+	tm := time.Now()
+	for i,offset := range []time.Duration{0, 10*time.Second, 10*time.Minute} {
+		c := Complaint{Timestamp: tm.Add(offset), Description: fmt.Sprintf("complaint %d", i)}
+		if err := cdb.ComplainByEmailAddress(profile.EmailAddress, &c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second complaint should have been merged into the first
+	if complaints,err := cdb.LookupAll(cdb.CQByEmail(profile.EmailAddress)); err != nil {
+		t.Fatal(err)
+	} else if len(complaints) != 2 {
+		t.Errorf("expected 2 complaints after merging, found %d", len(complaints))
+	} else if complaints[0].Profile.FullName != profile.FullName {
+		t.Errorf("profile wasn't copied into complaint")
+	} else if tr,err := complaints[0].IdentTrace(); err != nil {
+		t.Error(err)
+	} else if tr.OutcomeCode != flightid.OutcomeNothingNear || tr.Selector == "" {
+		t.Errorf("unexpected identification trace: %s", tr.Summary())
+	} else if !strings.Contains(complaints[0].IdentDebug(), "nothing found in the sky") {
+		t.Errorf("unexpected identification debug: %s", complaints[0].IdentDebug())
+	}
+
+	// Batch jobs can query on the outcome
+	cq := cdb.CQByEmail(profile.EmailAddress).ByIdentOutcome(flightid.OutcomeNothingNear)
+	if keys,err := cdb.LookupAllKeys(cq); err != nil {
+		t.Fatal(err)
+	} else if len(keys) != 2 {
+		t.Errorf("expected 2 complaints with outcome %s, found %d", flightid.OutcomeNothingNear, len(keys))
+	}
+
+	// The trajectory selector runs on the live tracks; if they can't be fetched, it falls back
+	// to conservative
+	profile = makeProfile("traj@b.cc")
+	profile.SelectorAlgorithm = "trajectory"
+	if err := cdb.PersistProfile(profile); err != nil { t.Fatal(err) }
+	for expected,tracks := range map[string]flightid.TrackSource{
+		"trajectory": fakeTracks{},
+		"conservative": failingTracks{fmt.Errorf("flightdb is down")},
+	} {
+		cdb,err := New(ctx, WithAirspaceSource(emptyAirspaceSource{}), WithTrackSource(tracks))
+		if err != nil { t.Fatal(err) }
+		c := Complaint{Timestamp: tm}
+		if err := cdb.ComplainByEmailAddress(profile.EmailAddress, &c); err != nil {
+			t.Fatal(err)
+		} else if tr,err := c.IdentTrace(); err != nil {
+			t.Error(err)
+		} else if tr.Selector != expected {
+			t.Errorf("with %s tracks, expected the %s selector, got %s", tracks, expected, tr.Selector)
+		}
+	}
+}
+
+// }}}
+
+// {{{ TestElevationAndAltimeter
+
+type fixedElevation float64
+func (e fixedElevation)String() string { return "fixed" }
+func (e fixedElevation)ElevationAt(pos geo.Latlong) (float64, error) { return float64(e), nil }
+
+func TestElevationAndAltimeter(t *testing.T) {
+	ctx, done, err := newConsistentContext()
+	if err != nil { t.Fatal(err) }
+	defer done()
+
+	cdb,err := New(ctx, WithAirspaceSource(emptyAirspaceSource{}),
+		WithElevationService(fixedElevation(150)),
+		WithAltimeterSource(flightid.FixedAltimeter(1023.25)))
+	if err != nil { t.Fatal(err) }
+
+	profile := makeProfile("a@b.cc")
+	profile.Lat,profile.Long = 37.06, -121.99
+	if err := cdb.PersistProfile(profile); err != nil { t.Fatal(err) }
+
+	c := Complaint{Timestamp: time.Now()}
+	if err := cdb.ComplainByEmailAddress(profile.EmailAddress, &c); err != nil {
+		t.Fatal(err)
+	}
+
+	if complaints,err := cdb.LookupAll(cdb.CQByEmail(profile.EmailAddress)); err != nil {
+		t.Fatal(err)
+	} else if len(complaints) != 1 {
+		t.Fatalf("expected 1 complaint, found %d", len(complaints))
+	} else if complaints[0].Profile.Elevation != 150 {
+		t.Errorf("elevation not looked up; got %.0fm", complaints[0].Profile.Elevation)
+	} else if tr,_ := complaints[0].IdentTrace(); tr.QNH != 1023.25 {
+		t.Errorf("altitudes not corrected; trace has QNH %.2f", tr.QNH)
+	}
+
+	// The looked-up elevation is saved in the profile
+	if cp,err := cdb.MustLookupProfile(profile.EmailAddress); err != nil {
+		t.Fatal(err)
+	} else if cp.Elevation != 150 {
+		t.Errorf("elevation not saved in the profile; got %.0fm", cp.Elevation)
+	}
+
+	// With a consensus, each source's airspace gets corrected too
+	msg := adsb.CompositeMsg{Msg:adsb.Msg{Icao24:"A00001", Altitude:3000}}
+	as := airspace.NewAirspace()
+	as.Aircraft["A00001"] = airspace.AircraftData{Msg:&msg}
+	cons := flightid.Consensus{PerSource: map[string]*airspace.Airspace{"fr24": &as}, Fused: as}
+	if qnh := cdb.correctAltitudes(&cons.Fused, &cons); qnh != 1023.25 {
+		t.Errorf("expected QNH 1023.25, got %.2f", qnh)
+	}
+	for name,perSource := range map[string]*airspace.Airspace{"fused": &cons.Fused, "fr24": cons.PerSource["fr24"]} {
+		if alt := perSource.Aircraft["A00001"].Msg.Altitude; alt <= 3000 {
+			t.Errorf("%s: altitude not corrected (%d)", name, alt)
+		}
+	}
+
+	// Places at sea level are looked up once, not on every complaint
+	cdb0,err := New(ctx, WithAirspaceSource(emptyAirspaceSource{}),
+		WithElevationService(fixedElevation(0)))
+	if err != nil { t.Fatal(err) }
+	coastal := makeProfile("coast@b.cc")
+	coastal.Lat,coastal.Long = 37.50, -122.50
+	if filled,err := cdb0.FillProfileElevation(&coastal); err != nil || !filled {
+		t.Fatalf("0m elevation not looked up (%v, %v)", filled, err)
+	} else if coastal.ElevationSource != "fixed" {
+		t.Errorf("expected ElevationSource 'fixed', got '%s'", coastal.ElevationSource)
+	}
+	if filled,err := cdb0.FillProfileElevation(&coastal); err != nil || filled {
+		t.Errorf("0m elevation looked up a second time (%v, %v)", filled, err)
+	}
+
+	// Elevations the user gave us are left alone
+	profile.Elevation = 20
+	if filled,err := cdb.FillProfileElevation(&profile); err != nil || filled || profile.Elevation != 20 {
+		t.Errorf("existing elevation was replaced (%v, %v, %.0fm)", filled, err, profile.Elevation)
+	}
+}
+
+// }}}
+
+// {{{ TestCorrectFlight
+
+func TestCorrectFlight(t *testing.T) {
+	ctx, done, err := newConsistentContext()
+	if err != nil { t.Fatal(err) }
+	defer done()
+	cdb := NewDB(ctx)
+
+	complaints := makeComplaints(3, makeProfile("a@b.cc"))
+	complaints[0].AircraftOverhead = flightid.Aircraft{FlightNumber:"UA123", Id2:"A12345"}
+	complaints[0].CorrectFlight("UA999")
+	complaints[0].CorrectFlight("UA998") // A second correction shouldn't lose the auto pick
+	complaints[1].CorrectFlight("WN1")   // Auto-pick had abstained
+	if err := cdb.PersistComplaints(complaints); err != nil { t.Fatal(err) }
+
+	if complaints[0].AutoAircraftOverhead.FlightNumber != "UA123" {
+		t.Errorf("auto pick lost: %+v", complaints[0].AutoAircraftOverhead)
+	}
+
+	cases,err := cdb.SelectorEvaluationCases(cdb.NewComplaintQuery(), nil)
+	if err != nil { t.Fatal(err) }
+	if len(cases) != 2 {
+		t.Fatalf("expected 2 corrected complaints, found %d", len(cases))
+	}
+
+	scores := flightid.EvaluateSelectors(cases, []string{})
+	if recorded := scores[0]; recorded.N != 2 || recorded.Picked != 1 || recorded.Correct != 0 ||
+		recorded.Abstained != 1 {
+		t.Errorf("unexpected score for recorded picks: %+v", recorded)
+	}
+
+	// A correction to an unscheduled aircraft (by registration) gets scored against a replay of
+	// the snapshot it refers to; other snapshots in the corpus are left alone
+	corpus := flightid.NewSnapshotCorpus("dir:" + t.TempDir())
+	pos := geo.Latlong{Lat:37.06, Long:-121.99}
+	tm := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for i,snapTime := range []time.Time{tm, tm.Add(time.Minute)} {
+		msg := adsb.CompositeMsg{Msg: adsb.Msg{Icao24:"A0B1C2", GeneratedTimestampUTC:snapTime,
+			Position:pos, Altitude:2000}}
+		as := airspace.NewAirspace()
+		as.Aircraft[msg.Icao24] = airspace.AircraftData{Msg:&msg,
+			Airframe:fdb.Airframe{Registration:fmt.Sprintf("N1234%d", 5+i)}}
+		params := flightid.DefaultParams()
+		params.AllowUnscheduled = true
+		snap := flightid.Snapshot{Id:flightid.SnapshotId(snapTime), Time:snapTime, Pos:pos,
+			Params:params, Airspace:as}
+		if err := corpus.Record(ctx, snap); err != nil { t.Fatal(err) }
+	}
+
+	ga := makeComplaints(1, makeProfile("ga@b.cc"))[0]
+	ga.AircraftOverhead = flightid.Aircraft{FlightNumber:"UA1"}
+	ga.AirspaceSnapshotId = flightid.SnapshotId(tm)
+	ga.CorrectFlight("N12345")
+	if err := cdb.PersistComplaint(ga); err != nil { t.Fatal(err) }
+
+	cases,err = cdb.SelectorEvaluationCases(cdb.CQByEmail("ga@b.cc"), corpus)
+	if err != nil { t.Fatal(err) }
+	if len(cases) != 1 || cases[0].Snapshot == nil || cases[0].Snapshot.Id != ga.AirspaceSnapshotId {
+		t.Fatalf("expected the referenced snapshot: %+v", cases)
+	}
+	scores = flightid.EvaluateSelectors(cases, []string{"conservative"})
+	if replayed := scores[1]; replayed.N != 1 || replayed.Correct != 1 {
+		t.Errorf("unscheduled correction not scored as correct: %+v", replayed)
+	}
+}
+
+// }}}
+
+// {{{ TestIdentificationString
+
+func TestIdentificationString(t *testing.T) {
+	ctx, done, err := newConsistentContext()
+	if err != nil { t.Fatal(err) }
+	defer done()
+	cdb := NewDB(ctx)
+
+	c := makeComplaints(1, makeProfile("a@b.cc"))[0]
+	c.AircraftOverhead = flightid.Aircraft{FlightNumber:"UA123", Id2:"A12345"}
+	c.IdentConfidence = 0.8
+	c.Alternates = []flightid.Candidate{{FlightNumber:"AS45", Score:0.15}}
+	if err := cdb.PersistComplaint(c); err != nil { t.Fatal(err) }
+
+	stored,err := cdb.LookupFirst(cdb.NewComplaintQuery())
+	if err != nil || stored == nil { t.Fatalf("LookupFirst: %v", err) }
+
+	expected := "probably UA123 (0.80), possibly AS45 (0.15)"
+	if str := stored.IdentificationString(); str != expected {
+		t.Errorf("expected %q, got %q", expected, str)
+	}
+
+	stored.CorrectFlight("WN1")
+	if str := stored.IdentificationString(); str != "WN1" {
+		t.Errorf("corrected flight should trump candidates, got %q", str)
+	}
+
+	ga := makeComplaints(1, makeProfile("a@b.cc"))[0]
+	ga.AircraftOverhead = flightid.Aircraft{Registration:"N12345", Id2:"A00001"}
+	ga.Unscheduled = ga.AircraftOverhead.IsUnscheduled()
+	if str := ga.UnscheduledIdent(); str != "r:N12345" {
+		t.Errorf("unscheduled aircraft ident was %q", str)
+	}
+	if str := stored.UnscheduledIdent(); str != "" {
+		t.Errorf("scheduled flight had an unscheduled ident %q", str)
+	}
+}
+
+// }}}
+// {{{ TestEstimateNoise
+
+func TestEstimateNoise(t *testing.T) {
+	c := makeComplaints(1, makeProfile("a@b.cc"))[0]
+	c.EstimateNoise()
+	if !c.Noise.IsZero() || c.NoiseDBString() != "" {
+		t.Errorf("no aircraft, but got an estimate: %+v", c.Noise)
+	}
+
+	c.AircraftOverhead = flightid.Aircraft{FlightNumber:"UA123", EquipType:"B744", Dist3:2.0}
+	c.EstimateNoise()
+	if c.Noise.IsZero() || c.Noise.Assumed || c.NoiseDBString() == "" {
+		t.Errorf("expected an estimate: %+v", c.Noise)
+	}
+
+	c.CorrectFlight("WN1")
+	if !c.Noise.IsZero() {
+		t.Errorf("corrected flight kept its estimate: %+v", c.Noise)
+	}
+}
+
+// }}}
+// {{{ TestReidentifyComplaints
+
+// fakeHistory's airspaces are empty, unless it has a flight; then that's right overhead.
+type fakeHistory struct{
+	err    error
+	flight int64 // A UA flight number
+}
+func (h fakeHistory)String() string { return "fake" }
+func (h fakeHistory)AirspaceAt(box geo.LatlongBox, t time.Time) (*airspace.Airspace, error) {
+	if h.err != nil { return nil, h.err }
+	as := airspace.NewAirspace()
+	if h.flight != 0 {
+		msg := adsb.CompositeMsg{Msg: adsb.Msg{
+			Icao24: "A00001",
+			GeneratedTimestampUTC: t,
+			Position: box.Center(),
+			Altitude: 3000,
+		}}
+		as.Aircraft[msg.Icao24] = airspace.AircraftData{
+			Msg: &msg,
+			Schedule: fdb.Schedule{IATA:"UA", Number:h.flight},
+		}
+	}
+	return &as, nil
+}
+
+func TestReidentifyComplaints(t *testing.T) {
+	ctx, done, err := newConsistentContext()
+	if err != nil { t.Fatal(err) }
+	defer done()
+	cdb := NewDB(ctx)
+
+	complaints := makeComplaints(3, makeProfile("a@b.cc"))
+	complaints[1].AircraftOverhead = flightid.Aircraft{FlightNumber:"UA123"}
+	complaints[2].CorrectFlight("WN1")
+	if err := cdb.PersistComplaints(complaints); err != nil { t.Fatal(err) }
+
+	opts := ReidentifyOptions{OnlyUnidentified:true}
+	report,err := cdb.ReidentifyComplaints(cdb.NewComplaintQuery(), fakeHistory{}, opts)
+	if err != nil { t.Fatal(err) }
+	if report.N != 3 || report.Skipped != 2 || report.Changed != 0 {
+		t.Errorf("unexpected report: %s", report)
+	}
+
+	// Nothing found in the past shouldn't wipe out what was found at the time
+	report,err = cdb.ReidentifyComplaints(cdb.NewComplaintQuery(), fakeHistory{}, ReidentifyOptions{})
+	if err != nil { t.Fatal(err) }
+	if report.Skipped != 1 || report.Changed != 0 {
+		t.Errorf("unexpected report: %s", report)
+	}
+	results,err := cdb.LookupAll(cdb.NewComplaintQuery().ByFlight("UA123"))
+	if err != nil { t.Fatal(err) }
+	if len(results) != 1 || results[0].Reidentified.Source != "fake" {
+		t.Errorf("reidentification not recorded: %v", results)
+	}
+
+	// Something found in the past fills in the unidentified complaint
+	report,err = cdb.ReidentifyComplaints(cdb.NewComplaintQuery(), fakeHistory{flight:999}, opts)
+	if err != nil { t.Fatal(err) }
+	if report.N != 3 || report.Skipped != 2 || report.Changed != 1 || len(report.Changes) != 1 {
+		t.Errorf("unexpected report: %s", report)
+	}
+	results,err = cdb.LookupAll(cdb.NewComplaintQuery().ByFlight("UA999"))
+	if err != nil { t.Fatal(err) }
+	if len(results) != 1 {
+		t.Fatalf("expected 1 complaint updated to UA999, found %d", len(results))
+	}
+	c := results[0]
+	if r := c.Reidentified; !r.Changed || r.Before != "" || r.After != "UA999" || c.AircraftOverhead.Id2 != "A00001" {
+		t.Errorf("bad update: %s, %s", r, c.AircraftOverhead)
+	}
+	if tr,err := c.IdentTrace(); err != nil || len(tr.Sources) != 1 || tr.Sources[0] != "fake" {
+		t.Errorf("trace not updated: %v, %v", tr, err)
+	}
+
+	report,err = cdb.ReidentifyComplaints(cdb.NewComplaintQuery(), fakeHistory{err:fmt.Errorf("down")},
+		ReidentifyOptions{})
+	if err != nil { t.Fatal(err) }
+	if report.Failed != 2 {
+		t.Errorf("expected 2 failures: %s", report)
+	}
+}
+
+// }}}
+// {{{ TestReidentifyTrajectory
+
+func TestReidentifyTrajectory(t *testing.T) {
+	ctx, done, err := newConsistentContext()
+	if err != nil { t.Fatal(err) }
+	defer done()
+	cdb := NewDB(ctx)
+
+	pos := geo.Latlong{Lat:37.060312, Long:-121.990814}
+	tm := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	tp := func(ll geo.Latlong, ago time.Duration) fdb.Trackpoint {
+		return fdb.Trackpoint{Latlong:ll, Altitude:3000, TimestampUTC:tm.Add(-1 * ago)}
+	}
+
+	// UA1 flew right overhead 30s before the complaint, and is now 4KM east. UA2 has loitered
+	// 2.5KM to the north; it is the closest right now, but never came as close as UA1 did.
+	west,east,north := pos.MoveKM(270, 4), pos.MoveKM(90, 4), pos.MoveKM(0, 2.5)
+	hist := flightid.TrackHistorySource{Tracks: fakeTracks{
+		{IcaoId:"A00001", Schedule:fdb.Schedule{IATA:"UA", Number:1},
+			Track:flightid.Track{tp(west, time.Minute), tp(pos, 30*time.Second), tp(east, 0)}},
+		{IcaoId:"A00002", Schedule:fdb.Schedule{IATA:"UA", Number:2},
+			Track:flightid.Track{tp(north, time.Minute), tp(north, 30*time.Second), tp(north, 0)}},
+	}}
+
+	profile := makeProfile("a@b.cc")
+	profile.Lat,profile.Long = pos.Lat,pos.Long
+	profile.SelectorAlgorithm = "conservative"
+	c := Complaint{Timestamp:tm, Profile:profile}
+
+	if r,err := cdb.ReidentifyComplaint(&c, hist, ""); err != nil {
+		t.Fatal(err)
+	} else if r.Selector != "conservative" || r.After == "UA1" {
+		t.Errorf("the profile's selector shouldn't pick UA1: %s", r)
+	}
+
+	// Overriding the selector runs the closest approach over the real tracks
+	if r,err := cdb.ReidentifyComplaint(&c, hist, "trajectory"); err != nil {
+		t.Fatal(err)
+	} else if r.Selector != "trajectory" || r.After != "UA1" {
+		t.Errorf("trajectory should pick UA1: %s", r)
+	}
+}
+
+// }}}
+// {{{ TestSummaryProcedures
+
+func TestSummaryProcedures(t *testing.T) {
+	ctx, done, err := newConsistentContext()
+	if err != nil { t.Fatal(err) }
+	defer done()
+	cdb := NewDB(ctx)
+
+	// Three complaints about two unscheduled aircraft; two about one flight on SERFR2
+	// The report runs over whole days, so put the complaints into yesterday
+	s,e := date.WindowForYesterday()
+	complaints := makeComplaints(5, makeProfile("a@b.cc"))
+	for i := range complaints {
+		complaints[i].Timestamp = s.Add(time.Hour + time.Duration(i) * time.Minute)
+	}
+	for i,reg := range []string{"N1", "N1", "N2"} {
+		complaints[i].AircraftOverhead = flightid.Aircraft{Registration:reg}
+		complaints[i].Unscheduled = true
+	}
+	for _,i := range []int{3,4} {
+		complaints[i].AircraftOverhead = flightid.Aircraft{FlightNumber:"UA1",
+			ArrivalProcedureName:"SERFR2", ArrivalProcedureLastWaypoint:"EPICK"}
+	}
+	if err := cdb.PersistComplaints(complaints); err != nil { t.Fatal(err) }
+
+	str,err := cdb.SummaryReport(s, e, false, map[string]int{})
+	if err != nil { t.Fatal(err) }
+
+	for _,expected := range []string{
+		" unscheduled (GA etc):      3 (    2 such flights",
+		" SERFR2/EPICK        :      2 (    1 such flights",
+	} {
+		if !strings.Contains(str, expected) {
+			t.Errorf("report lacks %q:\n%s", expected, str)
+		}
+	}
+}
+
+// }}}
+// {{{ TestExposure
+
+// fakeTracks returns its flights, for any box and time.
+type fakeTracks []flightid.FlightTrack
+func (f fakeTracks)String() string { return "fake" }
+func (f fakeTracks)FlightTracks(box geo.LatlongBox, s,e time.Time) ([]flightid.FlightTrack, error) {
+	return f, nil
+}
+
+// failingTracks always fails.
+type failingTracks struct{ err error }
+func (f failingTracks)String() string { return "failing" }
+func (f failingTracks)FlightTracks(box geo.LatlongBox, s,e time.Time) ([]flightid.FlightTrack, error) {
+	return nil, f.err
+}
+
+func TestExposure(t *testing.T) {
+	ctx, done, err := newConsistentContext()
+	if err != nil { t.Fatal(err) }
+	defer done()
+	cdb := NewDB(ctx)
+
+	house := geo.Latlong{Lat:37.4, Long:-122.1}
+	ec := NewExposureCounter("2018.02.01", map[string]geo.Latlong{
+		"a@b.cc": house,
+		"far@b.cc": house.MoveKM(0, 20),
+	})
+
+	ac := func(fnum string, ll geo.Latlong, alt float64) flightid.Aircraft {
+		return flightid.Aircraft{Id2:"A"+fnum, FlightNumber:fnum, Lat:ll.Lat, Long:ll.Long, Altitude:alt}
+	}
+	t0 := time.Date(2018, 2, 1, 18, 0, 0, 0, time.UTC) // 10:00 PST
+
+	// UA1 flies right over the house between samples; WN2 stays 10KM away; AS3 is too high.
+	ec.AddAircraft(t0, []flightid.Aircraft{
+		ac("UA1", house.MoveKM(270, 5), 4000),
+		ac("WN2", house.MoveKM(180, 10), 4000),
+		ac("AS3", house.MoveKM(270, 1), 20000),
+	})
+	ec.AddAircraft(t0.Add(time.Minute), []flightid.Aircraft{
+		ac("UA1", house.MoveKM(90, 5), 4000),
+		ac("WN2", house.MoveKM(180, 9), 4000),
+		ac("AS3", house.MoveKM(90, 1), 20000),
+	})
+	// UA1 loops back over; shouldn't be counted twice
+	ec.AddAircraft(t0.Add(2*time.Minute), []flightid.Aircraft{ac("UA1", house, 4000)})
+
+	exp := ec.Exposures()
+	if de := exp["a@b.cc"]; de.NumOverflights != 1 || de.ByAirline["UA"] != 1 || de.ByHour[10] != 1 {
+		t.Errorf("bad exposure: %s %v %v", de, de.ByAirline, de.ByHour)
+	}
+	if de := exp["far@b.cc"]; de.IsZero() || de.NumOverflights != 0 {
+		t.Errorf("far away house should have zero overflights: %s", de)
+	}
+
+	if err := cdb.AddDailyExposure("a@b.cc", exp["a@b.cc"]); err != nil { t.Fatal(err) }
+	if err := cdb.AddDailyExposure("a@b.cc", DailyExposure{Datestring:"2018.02.02"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cdb.AddDailyExposure("a@b.cc", exp["a@b.cc"]); err != nil { t.Fatal(err) }
+
+	if des,err := cdb.GetDailyExposures("a@b.cc"); err != nil {
+		t.Fatal(err)
+	} else if len(des) != 2 || des[0].Datestring != "2018.02.02" {
+		t.Errorf("bad stored exposures: %v", des)
+	}
+	if de,err := cdb.GetDailyExposure("a@b.cc", "2018.02.01"); err != nil || de.NumOverflights != 1 {
+		t.Errorf("GetDailyExposure: %s, %v", de, err)
+	}
+
+	// Overflights get counted from the day's tracks, even with no complaints at all
+	profile := makeProfile("quiet@b.cc")
+	profile.Lat,profile.Long = house.Lat,house.Long
+	if err := cdb.PersistProfile(profile); err != nil { t.Fatal(err) }
+	over := fakeTracks{{IcaoId:"A00001", Schedule:fdb.Schedule{IATA:"UA", Number:1}, Track:flightid.Track{
+		{TimestampUTC:t0, Latlong:house.MoveKM(270, 5), Altitude:4000, GroundSpeed:300},
+		{TimestampUTC:t0.Add(2*time.Minute), Latlong:house.MoveKM(90, 5), Altitude:4000, GroundSpeed:300},
+	}}}
+	if n,err := cdb.ComputeDailyExposures(over, date.InPdt(t0), time.Minute); err != nil || n == 0 {
+		t.Errorf("ComputeDailyExposures: %d, %v", n, err)
+	}
+	if de,err := cdb.GetDailyExposure("quiet@b.cc", "2018.02.01"); err != nil || de.NumOverflights != 1 {
+		t.Errorf("expected an overflight with no complaints: %s, %v", de, err)
+	}
+
+	// If the tracks can't be fetched, nothing gets saved
+	profile = makeProfile("down@b.cc")
+	profile.Lat,profile.Long = house.Lat,house.Long
+	if err := cdb.PersistProfile(profile); err != nil { t.Fatal(err) }
+	down := failingTracks{fmt.Errorf("flightdb is down")}
+	if n,err := cdb.ComputeDailyExposures(down, t0.Add(24*time.Hour), time.Hour); err == nil || n != 0 {
+		t.Errorf("expected an error with the tracks down, got %d, %v", n, err)
+	}
+	if des,err := cdb.GetDailyExposures("down@b.cc"); err != nil || len(des) != 0 {
+		t.Errorf("expected no exposures saved, got %v, %v", des, err)
+	}
+}
+
+// }}}
 // {{{ TestCSVOutput
 
 func TestCSVOutput(t *testing.T) {
 	ctx, done, err := newConsistentContext()
-	p := ds.NewCloudDSProvider(ctx, "myproj")
 	if err != nil { t.Fatal(err) }
 	defer done()
 	cdb := NewDB(ctx)
-	cdb.Provider = p
 
 	for i:=0; i<8; i++ {
 		profile := makeProfile(fmt.Sprintf("a%d@b.cc", i))
@@ -220,12 +796,58 @@ func TestCSVOutput(t *testing.T) {
 		}
 	}
 
+	// One complaint with the identification extras filled in
+	profile := makeProfile("csv@b.cc")
+	if err := cdb.PersistProfile(profile); err != nil { t.Fatal(err) }
+	c := makeComplaints(1, profile)[0]
+	c.AircraftOverhead = flightid.Aircraft{FlightNumber:"UA123", Registration:"N12345"}
+	c.IdentConfidence = 0.8
+	c.Alternates = []flightid.Candidate{{FlightNumber:"AS45", Score:0.15}}
+	c.Unscheduled = true
+	c.Noise = noiseclass.Estimate{DB:67.2}
+	if err := cdb.PersistComplaint(c); err != nil { t.Fatal(err) }
+
 	buf := new(bytes.Buffer)
-	cdb.WriteCQueryToCSV(cdb.NewComplaintQuery(), buf, true)
+	if n,err := cdb.WriteCQueryToCSV(cdb.NewComplaintQuery(), buf, true); err != nil {
+		t.Fatal(err)
+	} else if n != 81 {
+		t.Errorf("expected 81 rows, got %d", n)
+	}
+
+	rows,err := csv.NewReader(buf).ReadAll()
+	if err != nil { t.Fatal(err) }
+	if len(rows) != 82 {
+		t.Fatalf("expected 81 rows plus headers, got %d", len(rows))
+	}
 
-	if len(buf.String()) != 8767 {
-	fmt.Printf("CSV output:-\n%s", buf.String())
-		t.Errorf("CSV Output didn't match - it had %d bytes\n", len(buf.String()))
+	col := map[string]int{}
+	for i,name := range rows[0] { col[name] = i }
+	for _,name := range cdb.CSVHeaders() {
+		if _,exists := col[name]; !exists {
+			t.Errorf("header '%s' missing from %v", name, rows[0])
+		}
+	}
+
+	var row []string
+	for _,r := range rows[1:] {
+		if r[col["Email"]] == "csv@b.cc" { row = r }
+	}
+	if row == nil { t.Fatalf("no row for csv@b.cc") }
+
+	expected := map[string]string{
+		"Flightnumber": "UA123",
+		"Identification": c.IdentificationString(),
+		"UnscheduledAircraft": "UA123",
+		"EstimatedDBA": "67",
+		"Airline": "United Airlines",
+	}
+	for name,val := range expected {
+		if row[col[name]] != val {
+			t.Errorf("column %s: expected '%s', got '%s'", name, val, row[col[name]])
+		}
+	}
+	if !strings.Contains(row[col["Identification"]], "AS45") {
+		t.Errorf("alternates missing from '%s'", row[col["Identification"]])
 	}
 }
 
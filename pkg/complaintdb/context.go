@@ -4,7 +4,11 @@ package complaintdb
 // things to us ... in the first instance, that the context should run with admin privs
 
 import (
+	"net/http"
+
 	"golang.org/x/net/context"
+
+	"github.com/skypies/util/gcp/ds"
 )
 
 // To prevent other libs colliding with us in the context.Value keyspace, use these private keys
@@ -16,6 +20,7 @@ const(
 type ContextProperties struct {
 	IsAdmin bool
 	ProjectId string
+	Provider ds.DatastoreProvider // If set, NewDB uses this instead of cloud datastore
 }
 
 func GetContextProperties(ctx context.Context) (ContextProperties, bool) {
@@ -26,3 +31,11 @@ func GetContextProperties(ctx context.Context) (ContextProperties, bool) {
 func SetContextProperties(ctx context.Context, props ContextProperties) context.Context {
 	return context.WithValue(ctx, contextPropertiesKey, props)
 }
+
+// WithContextProperties wraps a handler (e.g. http.DefaultServeMux), so that the context of
+// every request it serves carries the properties.
+func WithContextProperties(props ContextProperties, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(SetContextProperties(r.Context(), props)))
+	})
+}
@@ -56,6 +56,10 @@ func (cq *CQuery)ByFlight(flightnumber string) *CQuery {
 func (cq *CQuery)ByIcaoId(icaoid string) *CQuery {
 	return cq.Filter("AircraftOverhead.Id2 = ", icaoid)
 }
+func (cq *CQuery)ByFlightCorrected() *CQuery {
+	return cq.Filter("FlightCorrected = ", true)
+}
+func (cq *CQuery)ByIdentOutcome(code string) *CQuery { return cq.Filter("IdentOutcome = ", code) }
 func (cq *CQuery)OrderTimeAsc() *CQuery  { return cq.Order("Timestamp") }
 func (cq *CQuery)OrderTimeDesc() *CQuery { return cq.Order("-Timestamp") }
 //
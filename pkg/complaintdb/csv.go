@@ -14,6 +14,7 @@ func (cdb ComplaintDB)CSVHeaders() []string {
 		"CallerCode", "Name", "Address", "Zip", "Email",
 		"HomeLat", "HomeLong", "UnixEpoch", "Date", "Time(PDT)",
 		"Notes", "Flightnumber", "ActivityDisturbed", "Loudness", "HeardSpeedbrakes",
+		"Identification", "UnscheduledAircraft", "EstimatedDBA", "Airline",
 	}
 }
 
@@ -37,6 +38,10 @@ func (cdb ComplaintDB)ComplaintToCSVFunc() func(c *Complaint) []string {
 			c.Activity,
 			fmt.Sprintf("%d", c.Loudness),
 			fmt.Sprintf("%v", c.HeardSpeedbreaks),
+			c.IdentificationString(),
+			c.UnscheduledIdent(),
+			c.NoiseDBString(),
+			c.AircraftOverhead.AirlineName(),
 		}
 		return r
 	}
@@ -0,0 +1,53 @@
+package complaintdb
+
+import(
+	"fmt"
+
+	"github.com/skypies/complaints/pkg/flightid"
+)
+
+// {{{ cdb.SelectorEvaluationCases
+
+// SelectorEvaluationCases turns the user-corrected complaints matched by the query into
+// evaluation cases; the corpus (which may be nil) supplies the airspace snapshots, and only the
+// ones the complaints refer to get read.
+func (cdb ComplaintDB)SelectorEvaluationCases(cq *CQuery, corpus flightid.SnapshotCorpus) ([]flightid.Case, error) {
+	cases := []flightid.Case{}
+	snapIds := []string{} // Parallel to cases
+	iter := cdb.NewComplaintIterator(cq.ByFlightCorrected())
+	iter.PageSize = 100
+	for iter.Iterate(cdb.Ctx()) {
+		c := iter.Complaint()
+		auto := c.AutoAircraftOverhead
+		cases = append(cases, flightid.Case{
+			Truth: flightid.CaseIdent(&c.AircraftOverhead),
+			Recorded: flightid.CaseIdent(&auto),
+		})
+		snapIds = append(snapIds, c.AirspaceSnapshotId)
+	}
+	if iter.Err() != nil {
+		return nil, fmt.Errorf("SelectorEvaluationCases/iter: %v", iter.Err())
+	}
+
+	if corpus != nil {
+		snaps,err := flightid.LookupSnapshots(cdb.Ctx(), corpus, snapIds)
+		if err != nil {
+			return nil, fmt.Errorf("SelectorEvaluationCases: %v", err)
+		}
+		for i,id := range snapIds {
+			cases[i].Snapshot = snaps[id] // nil if not found
+		}
+	}
+
+	return cases, nil
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
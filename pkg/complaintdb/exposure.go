@@ -0,0 +1,363 @@
+package complaintdb
+
+// Exposure counts how many flights went over each user's house each day, whether or not they
+// complained about them. It's computed overnight from flightdb's tracks for the whole day, and
+// stored per-user alongside the DailyCounts.
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/skypies/geo"
+	"github.com/skypies/pi/airspace"
+	"github.com/skypies/util/date"
+	sprovider "github.com/skypies/util/gcp/singleton"
+
+	"github.com/skypies/complaints/pkg/flightid"
+)
+
+const(
+	KExposureMaxDistKM = 3.0              // An overflight passes within this distance ...
+	KExposureMaxAltitudeFeet = 10000.0    // ... and below this altitude
+	KExposureSampleStep = time.Minute     // How often to sample the flights' positions
+	KExposureFetchSpan = time.Hour        // How much of the day to fetch tracks for at once
+	KExposureMaxGap = 5 * time.Minute     // Don't join up positions further apart than this
+	KMaxExposureDays = 400                // How many days to keep per user
+	KExposureMaxFailedFraction = 0.1      // If more fetches than this fail, don't save anything
+)
+
+// {{{ DailyExposure
+
+// DailyExposure is the overflights for one user, for one day.
+type DailyExposure struct {
+	Datestring     string
+	NumOverflights int
+	ByHour         [24]int        // PDT hours
+	ByAirline      map[string]int // IATA code; or "unscheduled", or "unknown"
+	MaxDistKM      float64        // The thresholds used
+	MaxAltitudeFeet float64
+}
+
+func (de DailyExposure)IsZero() bool { return de.Datestring == "" }
+
+func (de DailyExposure)String() string {
+	return fmt.Sprintf("%s: % 4d overflights (<%.0fKM, <%.0fft)", de.Datestring,
+		de.NumOverflights, de.MaxDistKM, de.MaxAltitudeFeet)
+}
+
+// AirlineString lists the airlines, busiest first, e.g. "UA:40, WN:22, AS:5".
+func (de DailyExposure)AirlineString() string {
+	str := ""
+	for i,k := range keysByIntValDesc(de.ByAirline) {
+		if i > 0 { str += ", " }
+		str += fmt.Sprintf("%s:%d", k, de.ByAirline[k])
+	}
+	return str
+}
+
+// BusiestHour is the PDT hour with the most overflights.
+func (de DailyExposure)BusiestHour() int {
+	busiest := 0
+	for h,n := range de.ByHour {
+		if n > de.ByHour[busiest] { busiest = h }
+	}
+	return busiest
+}
+
+type DailyExposureDesc []DailyExposure
+func (a DailyExposureDesc) Len() int           { return len(a) }
+func (a DailyExposureDesc) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a DailyExposureDesc) Less(i, j int) bool { return a[i].Datestring > a[j].Datestring}
+
+// }}}
+// {{{ ExposureCounter
+
+// ExposureCounter accumulates overflights for a set of locations, from a sequence of airspaces.
+// Each aircraft's positions are joined up into line segments, so fast aircraft that pass
+// overhead between two samples still get counted.
+type ExposureCounter struct {
+	Datestring      string
+	MaxDistKM       float64
+	MaxAltitudeFeet float64
+
+	Locations map[string]geo.Latlong   // email -> house
+	Counts    map[string]*DailyExposure // email -> counts
+
+	grid map[[2]int][]string            // grid cell -> emails
+	prev map[string]flightid.Aircraft   // flight key -> last position
+	prevT map[string]time.Time
+	seen map[string]map[string]bool     // email -> flight keys already counted
+}
+
+const kExposureGridDeg = 0.05 // ~5KM cells
+
+func exposureCell(pos geo.Latlong) [2]int {
+	return [2]int{int(math.Floor(pos.Lat / kExposureGridDeg)), int(math.Floor(pos.Long / kExposureGridDeg))}
+}
+
+func NewExposureCounter(datestring string, locations map[string]geo.Latlong) *ExposureCounter {
+	ec := ExposureCounter{
+		Datestring: datestring,
+		MaxDistKM: KExposureMaxDistKM,
+		MaxAltitudeFeet: KExposureMaxAltitudeFeet,
+		Locations: locations,
+		Counts: map[string]*DailyExposure{},
+		grid: map[[2]int][]string{},
+		prev: map[string]flightid.Aircraft{},
+		prevT: map[string]time.Time{},
+		seen: map[string]map[string]bool{},
+	}
+	for email,pos := range locations {
+		cell := exposureCell(pos)
+		ec.grid[cell] = append(ec.grid[cell], email)
+	}
+	return &ec
+}
+
+// Box is the area that airspaces need to cover.
+func (ec *ExposureCounter)Box() geo.LatlongBox {
+	box := geo.LatlongBox{}
+	for _,pos := range ec.Locations {
+		if box.IsNil() {
+			box = geo.LatlongBox{SW:pos, NE:pos}
+			continue
+		}
+		box.SW.Lat,box.SW.Long = math.Min(box.SW.Lat,pos.Lat), math.Min(box.SW.Long,pos.Long)
+		box.NE.Lat,box.NE.Long = math.Max(box.NE.Lat,pos.Lat), math.Max(box.NE.Long,pos.Long)
+	}
+	if box.IsNil() { return box }
+
+	// Pad it out by ~25KM, so we see aircraft on their way in
+	margin := 0.25
+	box.SW.Lat,box.SW.Long = box.SW.Lat-margin, box.SW.Long-margin
+	box.NE.Lat,box.NE.Long = box.NE.Lat+margin, box.NE.Long+margin
+	return box
+}
+
+// segmentDistKM is the distance from pos to the line segment from..to
+func segmentDistKM(from,to,pos geo.Latlong) float64 {
+	if from.Equal(to) { return pos.DistKM(from) }
+	line := from.LineTo(to)
+	if along := line.DistAlongLine(pos); along <= 0 {
+		return pos.DistKM(from)
+	} else if along >= 1 {
+		return pos.DistKM(to)
+	}
+	return line.ClosestDistance(pos)
+}
+
+// nearbyEmails lists the users whose houses might be within the max distance of the segment.
+func (ec *ExposureCounter)nearbyEmails(from,to geo.Latlong) []string {
+	marginDeg := ec.MaxDistKM / 50.0 // a KM is less than 1/50th of a degree (below 60deg lat)
+	lo := exposureCell(geo.Latlong{Lat:math.Min(from.Lat,to.Lat)-marginDeg,
+		Long:math.Min(from.Long,to.Long)-marginDeg})
+	hi := exposureCell(geo.Latlong{Lat:math.Max(from.Lat,to.Lat)+marginDeg,
+		Long:math.Max(from.Long,to.Long)+marginDeg})
+
+	ret := []string{}
+	for i:=lo[0]; i<=hi[0]; i++ {
+		for j:=lo[1]; j<=hi[1]; j++ {
+			ret = append(ret, ec.grid[[2]int{i,j}]...)
+		}
+	}
+	return ret
+}
+
+func exposureFlightKey(a flightid.Aircraft) string {
+	if a.Id2 != "" { return a.Id2 + ":" + a.BestIdent() }
+	return a.BestIdent()
+}
+
+func exposureAirline(a flightid.Aircraft) string {
+	if code := a.AirlineCode(); code != "" {
+		return code
+	} else if a.IsUnscheduled() {
+		return "unscheduled"
+	}
+	return "unknown"
+}
+
+// Add processes an airspace, as of time t.
+func (ec *ExposureCounter)Add(t time.Time, as *airspace.Airspace) {
+	if as == nil { return }
+	ec.AddAircraft(t, flightid.AirspaceToLocalizedAircraft(as, geo.Latlong{}, 0))
+}
+
+// AddAircraft processes the positions of aircraft, as of time t.
+func (ec *ExposureCounter)AddAircraft(t time.Time, aircraft []flightid.Aircraft) {
+	for _,a := range aircraft {
+		key := exposureFlightKey(a)
+		if key == "" { continue }
+
+		from := a
+		if prev,exists := ec.prev[key]; exists && t.Sub(ec.prevT[key]) <= KExposureMaxGap {
+			from = prev
+		}
+		ec.prev[key] = a
+		ec.prevT[key] = t
+
+		if math.Min(from.Altitude, a.Altitude) > ec.MaxAltitudeFeet { continue }
+
+		for _,email := range ec.nearbyEmails(from.Latlong(), a.Latlong()) {
+			if ec.seen[email][key] { continue }
+			if segmentDistKM(from.Latlong(), a.Latlong(), ec.Locations[email]) > ec.MaxDistKM {
+				continue
+			}
+
+			if ec.seen[email] == nil { ec.seen[email] = map[string]bool{} }
+			ec.seen[email][key] = true
+
+			de := ec.Counts[email]
+			if de == nil {
+				de = &DailyExposure{
+					Datestring: ec.Datestring,
+					ByAirline: map[string]int{},
+					MaxDistKM: ec.MaxDistKM,
+					MaxAltitudeFeet: ec.MaxAltitudeFeet,
+				}
+				ec.Counts[email] = de
+			}
+			de.NumOverflights++
+			de.ByHour[date.InPdt(t).Hour()]++
+			de.ByAirline[exposureAirline(a)]++
+		}
+	}
+}
+
+// Exposures returns a DailyExposure for every location; those with no overflights get zero
+// counts, so we can tell "quiet day" from "not computed".
+func (ec *ExposureCounter)Exposures() map[string]DailyExposure {
+	ret := map[string]DailyExposure{}
+	for email,_ := range ec.Locations {
+		if de,exists := ec.Counts[email]; exists {
+			ret[email] = *de
+		} else {
+			ret[email] = DailyExposure{
+				Datestring: ec.Datestring,
+				ByAirline: map[string]int{},
+				MaxDistKM: ec.MaxDistKM,
+				MaxAltitudeFeet: ec.MaxAltitudeFeet,
+			}
+		}
+	}
+	return ret
+}
+
+// }}}
+
+// {{{ cdb.GetDailyExposures
+
+// GetDailyExposures returns the stored exposures for the user, most recent first.
+func (cdb *ComplaintDB)GetDailyExposures(email string) ([]DailyExposure, error) {
+	des := []DailyExposure{}
+	sp := sprovider.NewProvider(cdb.Provider)
+	if err := sp.ReadSingleton(cdb.Ctx(), email+":exposure", nil, &des); err != nil {
+		return des, fmt.Errorf("GetDailyExposures: %v", err)
+	}
+	return des, nil
+}
+
+// GetDailyExposure returns the exposure for a single day; zero if it wasn't computed.
+func (cdb *ComplaintDB)GetDailyExposure(email, datestring string) (DailyExposure, error) {
+	des,err := cdb.GetDailyExposures(email)
+	if err != nil { return DailyExposure{}, err }
+	for _,de := range des {
+		if de.Datestring == datestring { return de, nil }
+	}
+	return DailyExposure{}, nil
+}
+
+// }}}
+// {{{ cdb.AddDailyExposure
+
+// AddDailyExposure stores the exposure, replacing any existing entry for that day.
+func (cdb *ComplaintDB)AddDailyExposure(email string, de DailyExposure) error {
+	des,err := cdb.GetDailyExposures(email)
+	if err != nil { return err }
+
+	new := []DailyExposure{de}
+	for _,existing := range des {
+		if existing.Datestring != de.Datestring { new = append(new, existing) }
+	}
+	sort.Sort(DailyExposureDesc(new))
+	if len(new) > KMaxExposureDays { new = new[:KMaxExposureDays] }
+
+	sp := sprovider.NewProvider(cdb.Provider)
+	if err := sp.WriteSingleton(cdb.Ctx(), email+":exposure", nil, &new); err != nil {
+		return fmt.Errorf("AddDailyExposure: %v", err)
+	}
+	return nil
+}
+
+// }}}
+// {{{ cdb.ComputeDailyExposures
+
+// ComputeDailyExposures fetches the flight tracks across the day starting at dayStart, an hour at
+// a time, and samples them every step; it stores the overflight counts for every profile that
+// has a location. The tracks need to cover every flight (e.g. flightdb's), not just those near
+// complaints, or quiet days will look like there were no overflights. If too many of the fetches
+// fail, it stores nothing and returns an error.
+func (cdb *ComplaintDB)ComputeDailyExposures(tracks flightid.TrackSource, dayStart time.Time, step time.Duration) (int, error) {
+	profiles,err := cdb.LookupAllProfiles(cdb.NewProfileQuery())
+	if err != nil {
+		return 0, fmt.Errorf("ComputeDailyExposures/LookupAllProfiles: %v", err)
+	}
+
+	locations := map[string]geo.Latlong{}
+	for _,cp := range profiles {
+		if cp.Lat == 0 && cp.Long == 0 { continue }
+		locations[cp.EmailAddress] = geo.Latlong{Lat:cp.Lat, Long:cp.Long}
+	}
+
+	s,e := date.WindowForTime(dayStart)
+	ec := NewExposureCounter(date.Time2Datestring(s), locations)
+	box := ec.Box()
+	if box.IsNil() { return 0, nil }
+
+	nFetches,nFailed := 0,0
+	var lastErr error
+	for cs := s; cs.Before(e); cs = cs.Add(KExposureFetchSpan) {
+		ce := cs.Add(KExposureFetchSpan)
+		if ce.After(e) { ce = e }
+
+		nFetches++
+		flights,err := tracks.FlightTracks(box, cs, ce)
+		if err != nil {
+			nFailed++
+			lastErr = err
+			continue
+		}
+		for t := cs; t.Before(ce); t = t.Add(step) {
+			ec.Add(t, flightid.AirspaceFromTracks(flights, t, KExposureMaxGap, tracks.String()))
+		}
+	}
+	if float64(nFailed) > KExposureMaxFailedFraction * float64(nFetches) {
+		return 0, fmt.Errorf("ComputeDailyExposures: %d of %d track fetches failed, last: %v",
+			nFailed, nFetches, lastErr)
+	} else if nFailed > 0 {
+		cdb.Errorf("ComputeDailyExposures: %d of %d track fetches failed (last: %v); counts will be low",
+			nFailed, nFetches, lastErr)
+	}
+
+	n := 0
+	for email,de := range ec.Exposures() {
+		if err := cdb.AddDailyExposure(email, de); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
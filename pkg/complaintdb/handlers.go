@@ -30,7 +30,11 @@ func req2ctx(r *http.Request) context.Context {
 // /cdb/comp/debug?key=asdadasdasdasdasdasdsadasdsadasdasdasdasdasdas
 func ComplaintDebugHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := req2ctx(r)
-	cdb := NewDB(ctx)
+	cdb,err := New(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	c, err := cdb.LookupKey(r.FormValue("key"), "")
 	if err != nil {
@@ -40,10 +44,12 @@ func ComplaintDebugHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	subLog := c.Submission.Log
-	idDebug := c.Debug
+	idDebug := c.IdentDebug()
+	trace,_ := c.IdentTrace()
 
 	c.Submission.Log = "..."
 	c.Debug = "..."
+	c.IdentTraceJSON = nil
 	jsonText,_ := json.MarshalIndent(c, "", "  ")
 
 	str := ""
@@ -68,6 +74,10 @@ func ComplaintDebugHandler(w http.ResponseWriter, r *http.Request) {
 
 	str += "\n======/// Complaint object ///======\n\n"+string(jsonText)+"\n"
 
+	if !trace.IsZero() {
+		traceText,_ := json.MarshalIndent(trace, "", "  ")
+		str += "\n======/// Aircraft ID trace ///======\n\n"+string(traceText)+"\n"
+	}
 	str += "\n======/// Aircraft ID debug ///======\n\n"+idDebug
 	str += "\n======/// Submission Log ///======\n\n"+subLog
 
@@ -86,7 +96,11 @@ func ComplaintDebugHandler(w http.ResponseWriter, r *http.Request) {
 //   ?datestring=2018.01.20 (this day in particular)
 func SubmissionsDebugHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := req2ctx(r)
-	cdb := NewDB(ctx)
+	cdb,err := New(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	start,end := date.WindowForToday()
 
@@ -214,7 +228,11 @@ func SubmissionsDebugHandler(w http.ResponseWriter, r *http.Request) {
 //  [?csv=1]
 func SubmissionsDebugHandler2(w http.ResponseWriter, r *http.Request) {
 	ctx := req2ctx(r)
-	cdb := NewDB(ctx)
+	cdb,err := New(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	start,end,_ := widget.FormValueDateRange(r)
 
@@ -292,7 +310,11 @@ func SubmissionsDebugHandler2(w http.ResponseWriter, r *http.Request) {
 // {{{ touchAllProfilesHandler
 
 func touchAllProfilesHandler(w http.ResponseWriter, r *http.Request) {
-	cdb := NewDB(req2ctx(r))
+	cdb,err := New(req2ctx(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	tStart := time.Now()
 
 	profiles, err := cdb.LookupAllProfiles(cdb.NewProfileQuery())
@@ -0,0 +1,284 @@
+package complaintdb
+
+// Functional options for constructing a ComplaintDB.
+//
+//   cdb,err := complaintdb.New(ctx, complaintdb.WithProvider(p), complaintdb.WithAdmin(true))
+//
+// Anything not specified falls back to the ContextProperties carried by ctx (if any), and then
+// to the production defaults (cloud datastore in the serfr0-1000 project, and the airspace
+// source named in the "airspace.source" config).
+
+import(
+	"fmt"
+	pkglog "log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/skypies/geo"
+	"github.com/skypies/util/gcp/ds"
+
+	"github.com/skypies/pi/airspace"
+
+	"github.com/skypies/complaints/pkg/config"
+	"github.com/skypies/complaints/pkg/elevation"
+	"github.com/skypies/complaints/pkg/flightid"
+)
+
+const DefaultProjectId = "serfr0-1000"
+
+type options struct {
+	provider  ds.DatastoreProvider
+	projectId string
+	logger   *pkglog.Logger
+	clock     func() time.Time
+	client   *http.Client
+	admin    *bool
+	airspace  flightid.AirspaceSource
+	snapshots flightid.SnapshotCorpus
+	snapshotsSet bool
+	elevation elevation.Service
+	elevationSet bool
+	altimeter flightid.AltimeterSource
+	altimeterSet bool
+	tracks    flightid.TrackSource
+	tracksSet bool
+}
+
+type Option func(*options)
+
+// WithProvider uses the given datastore provider, instead of connecting to cloud datastore.
+func WithProvider(p ds.DatastoreProvider) Option { return func(o *options) { o.provider = p } }
+
+// WithProject sets the cloud project whose datastore we connect to; it is ignored if there is
+// also a provider.
+func WithProject(id string) Option { return func(o *options) { o.projectId = id } }
+
+func WithLogger(l *pkglog.Logger) Option { return func(o *options) { o.logger = l } }
+
+// WithClock replaces time.Now, e.g. for tests that care about daily windows.
+func WithClock(now func() time.Time) Option { return func(o *options) { o.clock = now } }
+
+// WithHTTPClient sets the client returned by cdb.HTTPClient(), used for all outbound calls.
+func WithHTTPClient(c *http.Client) Option { return func(o *options) { o.client = c } }
+
+// WithAdmin overrides the IsAdmin context property; admins can access anyone's complaints.
+func WithAdmin(admin bool) Option { return func(o *options) { o.admin = &admin } }
+
+// WithAirspaceSource sets where complaints get their view of the airspace from.
+func WithAirspaceSource(src flightid.AirspaceSource) Option {
+	return func(o *options) { o.airspace = src }
+}
+
+// WithSnapshotCorpus records every airspace used to identify a complaint into the corpus,
+// for later replay. A nil corpus turns recording off.
+func WithSnapshotCorpus(c flightid.SnapshotCorpus) Option {
+	return func(o *options) { o.snapshots = c; o.snapshotsSet = true }
+}
+
+// WithElevationService looks up the ground elevation for profiles that don't have one. A nil
+// service turns this off.
+func WithElevationService(s elevation.Service) Option {
+	return func(o *options) { o.elevation = s; o.elevationSet = true }
+}
+
+// WithAltimeterSource corrects aircraft altitudes for the local altimeter setting. A nil source
+// turns correction off.
+func WithAltimeterSource(a flightid.AltimeterSource) Option {
+	return func(o *options) { o.altimeter = a; o.altimeterSet = true }
+}
+
+// WithTrackSource sets where the trajectory selector gets the recent tracks of live aircraft
+// from. A nil source turns it off; profiles using the trajectory selector then fall back to the
+// conservative one.
+func WithTrackSource(t flightid.TrackSource) Option {
+	return func(o *options) { o.tracks = t; o.tracksSet = true }
+}
+
+// {{{ New
+
+// New returns a handle to the database, configured by the options. It returns an error
+// (rather than panicking) if it can't set up a datastore provider.
+func New(ctx context.Context, opts ...Option) (ComplaintDB, error) {
+	o := options{}
+	if props,ok := GetContextProperties(ctx); ok {
+		o.provider = props.Provider
+		o.projectId = props.ProjectId
+		if props.IsAdmin { o.admin = &props.IsAdmin }
+	}
+	for _,opt := range opts {
+		opt(&o)
+	}
+
+	if o.projectId == "" { o.projectId = DefaultProjectId }
+	if o.clock == nil    { o.clock = time.Now }
+	if o.airspace == nil { o.airspace = flightid.DefaultAirspaceSource() }
+	if !o.snapshotsSet   { o.snapshots = flightid.DefaultSnapshotCorpus() }
+	if !o.tracksSet {
+		o.tracks = flightid.FdbTrackSource{Host: config.Get("airspace.host"), Client: o.client}
+	}
+	if o.logger == nil {
+		o.logger = pkglog.New(os.Stderr, "", pkglog.Ldate|pkglog.Ltime) //|log.Lshortfile)
+	}
+	if !o.elevationSet || !o.altimeterSet {
+		elev,alt := processDefaults(o.logger)
+		if !o.elevationSet { o.elevation = elev }
+		if !o.altimeterSet { o.altimeter = alt }
+	}
+
+	if o.provider == nil {
+		p,err := ds.NewCloudDSProvider(ctx, o.projectId)
+		if err != nil {
+			return ComplaintDB{}, fmt.Errorf("New: could not get a clouddsprovider (projectId=%s): %v",
+				o.projectId, err)
+		}
+		o.provider = p
+	}
+
+	return ComplaintDB{
+		ctx: ctx,
+		StartTime: o.clock(),
+		admin: (o.admin != nil && *o.admin),
+		Provider: o.provider,
+		Logger: o.logger,
+		clock: o.clock,
+		client: o.client,
+		airspace: o.airspace,
+		snapshots: o.snapshots,
+		elevation: o.elevation,
+		altimeter: o.altimeter,
+		tracks: o.tracks,
+	}, nil
+}
+
+// }}}
+// {{{ processDefaults
+
+// The default elevation service and altimeter source are built just once per process, so that
+// every handle shares their caches (DEM tiles, the latest METAR).
+var(
+	defaultsOnce sync.Once
+	defaultElevation elevation.Service
+	defaultAltimeter flightid.AltimeterSource
+)
+
+func processDefaults(logger *pkglog.Logger) (elevation.Service, flightid.AltimeterSource) {
+	defaultsOnce.Do(func() {
+		defaultElevation = elevation.DefaultService()
+
+		var err error
+		if defaultAltimeter,err = flightid.DefaultAltimeterSource(); err != nil {
+			logger.Printf("ERROR: New: %v; altitudes will not be corrected", err)
+		}
+	})
+	return defaultElevation, defaultAltimeter
+}
+
+// }}}
+// {{{ cdb.AirspaceSource, cdb.FetchAirspace, fetchAirspace
+
+func (cdb ComplaintDB)AirspaceSource() flightid.AirspaceSource {
+	if cdb.airspace == nil { return flightid.DefaultAirspaceSource() }
+	return cdb.airspace
+}
+
+// FetchAirspace returns the aircraft in the box, from whichever source the handle was built with.
+func (cdb ComplaintDB)FetchAirspace(box geo.LatlongBox) (*airspace.Airspace, error) {
+	return cdb.AirspaceSource().FetchAirspace(box)
+}
+
+// fetchAirspace fetches from the given source; if it is a consensus source, the breakdown by
+// source is returned too.
+func fetchAirspace(src flightid.AirspaceSource, box geo.LatlongBox) (*airspace.Airspace, *flightid.Consensus, error) {
+	if cs,ok := src.(flightid.ConsensusAirspaceSource); ok {
+		cons,err := cs.FetchConsensus(box)
+		if err != nil { return nil, nil, err }
+		return &cons.Fused, cons, nil
+	}
+	as,err := src.FetchAirspace(box)
+	return as, nil, err
+}
+
+// }}}
+// {{{ cdb.recentTracks
+
+// recentTracks fetches the tracks of the aircraft in the box, over the window up to now.
+func (cdb ComplaintDB)recentTracks(box geo.LatlongBox, window time.Duration) (flightid.TrackHistory, error) {
+	if cdb.tracks == nil {
+		return nil, fmt.Errorf("recentTracks: no track source")
+	}
+	return flightid.TrackHistorySource{Tracks: cdb.tracks}.TracksBefore(box, time.Now(), window)
+}
+
+// }}}
+// {{{ cdb.correctAltitudes
+
+// correctAltitudes turns the airspace's pressure altitudes into geometric heights, if we have an
+// altimeter source; if there is a consensus, each source's airspace gets corrected too. It
+// returns the QNH used, or zero if no correction was made; failures are logged, and the
+// airspaces are left as is.
+func (cdb ComplaintDB)correctAltitudes(as *airspace.Airspace, cons *flightid.Consensus) float64 {
+	if cdb.altimeter == nil || as == nil { return 0 }
+	qnh,err := cdb.altimeter.QNH(cdb.HTTPClient())
+	if err != nil {
+		cdb.Errorf("correctAltitudes/%s: %v", cdb.altimeter, err)
+		return 0
+	}
+	*as = flightid.CorrectAltitudes(*as, qnh)
+	if cons != nil {
+		for name,perSource := range cons.PerSource {
+			if perSource == nil { continue }
+			corrected := flightid.CorrectAltitudes(*perSource, qnh)
+			cons.PerSource[name] = &corrected
+		}
+	}
+	return qnh
+}
+
+// }}}
+// {{{ cdb.FillProfileElevation
+
+// FillProfileElevation looks up the ground elevation for a profile that doesn't have one. It
+// returns true if it changed the profile (which it doesn't persist). Locations the elevation
+// service has no data for are not an error. A looked-up elevation is recorded in
+// ElevationSource, so places at sea level aren't looked up again on every complaint.
+func (cdb ComplaintDB)FillProfileElevation(cp *ComplainerProfile) (bool, error) {
+	if cdb.elevation == nil || cp.Elevation != 0 || cp.ElevationSource != "" {
+		return false, nil
+	} else if cp.Lat == 0 && cp.Long == 0 {
+		return false, nil
+	}
+	elev,err := cdb.elevation.ElevationAt(geo.Latlong{cp.Lat, cp.Long})
+	if err == elevation.ErrNoData {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("FillProfileElevation/%s: %v", cdb.elevation, err)
+	}
+	cp.Elevation = elev
+	cp.ElevationSource = cdb.elevation.String()
+	return true, nil
+}
+
+// }}}
+// {{{ cdb.recordSnapshot
+
+// Errors are logged, rather than returned; recording is never worth failing a complaint over.
+func (cdb ComplaintDB)recordSnapshot(snap flightid.Snapshot) {
+	if cdb.snapshots == nil { return }
+	if err := cdb.snapshots.Record(cdb.Ctx(), snap); err != nil {
+		cdb.Errorf("recordSnapshot to %s: %v", cdb.snapshots, err)
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,197 @@
+package complaintdb
+
+// Re-identification re-runs the flight identification for complaints after the fact, using an
+// airspace rebuilt from historical tracks. This fills in complaints where the live fetch failed,
+// and lets us apply better selectors (or better data) to old complaints.
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skypies/geo"
+
+	"github.com/skypies/complaints/pkg/flightid"
+)
+
+// {{{ Reidentification{}
+
+// Reidentification records the most recent re-run of identification on a complaint.
+type Reidentification struct {
+	T        time.Time `datastore:",noindex"` // When it was re-run
+	Source   string    `datastore:",noindex"` // Where the historical airspace came from
+	Selector string    `datastore:",noindex"`
+	Before   string    `datastore:",noindex"` // BestIdent of the aircraft, before ...
+	After    string    `datastore:",noindex"` // ... and after ("" if nothing was picked)
+	Changed  bool      `datastore:",noindex"` // AircraftOverhead was changed
+}
+
+func (r Reidentification)String() string {
+	str := fmt.Sprintf("%s via %s/%s: %q -> %q", r.T.Format("2006.01.02 15:04:05"), r.Source,
+		r.Selector, r.Before, r.After)
+	if !r.Changed { str += " (unchanged)" }
+	return str
+}
+
+// }}}
+// {{{ ReidentifyOptions{}, ReidentifyReport{}
+
+type ReidentifyOptions struct {
+	OnlyUnidentified bool   // Leave alone any complaints that already have an aircraft
+	DryRun           bool   // Don't write anything back to the datastore
+	Selector         string // Use this selector, rather than the one in each user's profile
+}
+
+type ReidentifyReport struct {
+	N, Skipped, Failed, Changed int
+	Changes []string // A line per changed complaint
+}
+
+func (r ReidentifyReport)String() string {
+	str := fmt.Sprintf("%d complaints: %d skipped, %d failed, %d changed\n",
+		r.N, r.Skipped, r.Failed, r.Changed)
+	for _,line := range r.Changes { str += " " + line + "\n" }
+	return str
+}
+
+// }}}
+
+// {{{ cdb.ReidentifyComplaint
+
+// ReidentifyComplaint re-runs the identification for a complaint, using the named selector (or
+// if that's blank, the one the user had at the time) and the user's params. A pick replaces AircraftOverhead; but if nothing was picked, any
+// existing aircraft is left in place (historical data is patchier than live data). Complaints the
+// user has corrected are never touched. The complaint is not persisted.
+func (cdb ComplaintDB)ReidentifyComplaint(c *Complaint, hist flightid.HistoricalAirspaceSource, selector string) (Reidentification, error) {
+	if selector == "" { selector = c.Profile.SelectorAlgorithm }
+	r := Reidentification{
+		T: cdb.Now(),
+		Source: hist.String(),
+		Selector: selector,
+		Before: c.AircraftOverhead.BestIdent(),
+	}
+	r.After = r.Before
+
+	if c.FlightCorrected { return r, nil }
+
+	params := c.IdentParams
+	if params.IsZero() { params = c.Profile.IdentParams }
+	algo := flightid.NewSelectorWithParams(selector, params)
+
+	pos := geo.Latlong{c.Profile.Lat, c.Profile.Long}
+	elev := c.Profile.ElevationFeet()
+
+	tFetch := time.Now()
+	as,err := hist.AirspaceAt(pos.Box(64,64), c.Timestamp)
+	latency := time.Since(tFetch)
+	if err != nil {
+		return r, fmt.Errorf("ReidentifyComplaint/AirspaceAt: %v", err)
+	}
+
+	// The trajectory selector can use real tracks, instead of dead reckoning
+	if traj,ok := algo.(flightid.AlgoTrajectory); ok {
+		window := traj.Params.WithDefaults().TrajectoryWindow()
+		tracks,err := flightid.TracksFromHistory(hist, pos.Box(64,64), c.Timestamp, window,
+			flightid.KTrajectoryHistoryStep)
+		if err != nil {
+			return r, fmt.Errorf("ReidentifyComplaint/TracksFromHistory: %v", err)
+		}
+		traj.Tracks = tracks
+		algo = traj
+	}
+
+	res := flightid.IdentifyAt(as, c.Timestamp, pos, elev, algo)
+	if res.Flight != nil {
+		c.AircraftOverhead = *res.Flight
+		c.Unscheduled = res.Flight.IsUnscheduled()
+		c.EstimateNoise()
+		c.IdentConfidence = res.Confidence
+		c.Alternates = res.Alternates(KMaxAlternates)
+		c.IdentParams = algo.Parameters()
+		r.After = res.Flight.BestIdent()
+	} else if r.Before == "" {
+		c.Alternates = res.Alternates(KMaxAlternates)
+	}
+	r.Changed = (r.After != r.Before)
+
+	c.Reidentified = r
+
+	// Keep the original trace, but not a chain of every reidentification since
+	tr := res.Trace
+	tr.Sources = []string{hist.String()}
+	tr.FetchLatencyMS = latency.Milliseconds()
+	if prev,err := c.IdentTrace(); err != nil {
+		return r, fmt.Errorf("ReidentifyComplaint: %v", err)
+	} else if !prev.IsZero() {
+		if prev.Previous != nil { prev = *prev.Previous }
+		tr.Previous = &prev
+	}
+	if err := c.SetIdentTrace(tr); err != nil {
+		return r, fmt.Errorf("ReidentifyComplaint: %v", err)
+	}
+
+	return r, nil
+}
+
+// }}}
+// {{{ cdb.ReidentifyComplaints
+
+// ReidentifyComplaints runs ReidentifyComplaint over everything matched by the query, writing
+// the complaints back (unless it's a dry run).
+func (cdb ComplaintDB)ReidentifyComplaints(cq *CQuery, hist flightid.HistoricalAirspaceSource, opts ReidentifyOptions) (ReidentifyReport, error) {
+	report := ReidentifyReport{}
+	toWrite := []Complaint{}
+
+	flush := func() error {
+		if len(toWrite) == 0 { return nil }
+		if opts.DryRun { toWrite = nil; return nil }
+		if err := cdb.PersistComplaints(toWrite); err != nil {
+			return fmt.Errorf("ReidentifyComplaints/Persist: %v", err)
+		}
+		toWrite = nil
+		return nil
+	}
+
+	iter := cdb.NewComplaintIterator(cq)
+	iter.PageSize = 100
+	for iter.Iterate(cdb.Ctx()) {
+		c := iter.Complaint()
+		report.N++
+
+		if c.FlightCorrected || (opts.OnlyUnidentified && c.AircraftOverhead.BestIdent() != "") {
+			report.Skipped++
+			continue
+		}
+
+		r,err := cdb.ReidentifyComplaint(c, hist, opts.Selector)
+		if err != nil {
+			cdb.Errorf("ReidentifyComplaints %s: %v", c, err)
+			report.Failed++
+			continue
+		}
+
+		toWrite = append(toWrite, *c)
+		if r.Changed {
+			report.Changed++
+			report.Changes = append(report.Changes, fmt.Sprintf("%s %s", c.DatastoreKey, r))
+		}
+
+		if len(toWrite) >= 50 {
+			if err := flush(); err != nil { return report, err }
+		}
+	}
+	if iter.Err() != nil {
+		return report, fmt.Errorf("ReidentifyComplaints: %v", iter.Err())
+	}
+
+	return report, flush()
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
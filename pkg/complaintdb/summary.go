@@ -3,10 +3,14 @@ package complaintdb
 import(
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/skypies/util/date"
 	"github.com/skypies/util/histogram"
+
+	"github.com/skypies/complaints/pkg/airline"
+	"github.com/skypies/complaints/pkg/noiseclass"
 )
 
 
@@ -64,14 +68,19 @@ func (cdb *ComplaintDB)SummaryReport(start,end time.Time, countByUser bool, zipF
 	var countsByHour [24]int
 	countsByDate := map[string]int{}
 	countsByAirline := map[string]int{}
+	countsByOperator := map[string]int{}        // Unscheduled aircraft, by registered operator
 	countsByEquip := map[string]int{}
+	countsByNoiseClass := map[string]int{}
+	dbSumByNoiseClass := map[string]float64{} // Sum of estimated levels, for averaging
+	dbCountsByNoiseClass := map[string]int{}
+	countsByDB := map[string]int{}            // Estimated level at the reporter, in 5dB bands
 	countsByCity := map[string]int{}
 	countsByZip := map[string]int{}
 	countsByAirport := map[string]int{}
 
 	countsByProcedure := map[string]int{}        // complaint counts, per arrival/departure procedure
-	flightCountsByProcedure := map[string]int{}  // how many flights flew that procedure overall
-	// proceduresByCity := map[string]map[string]int{} // For each city, breakdown by procedure
+	flightCountsByProcedure := map[string]int{}  // how many flights with complaints flew it
+	proceduresByCity := map[string]map[string]int{} // For each city, breakdown by procedure
 	
 	uniquesAll := map[string]int{}
 	uniquesPerDay := map[string]int{} // Each entry is a count for one unique user, for one day
@@ -87,17 +96,17 @@ func (cdb *ComplaintDB)SummaryReport(start,end time.Time, countByUser bool, zipF
 	n := 0
 	for _,dayWindow := range date.WindowsForRange(start,end) {
 
-		// Get condensed flight data (for :NORCAL:)
-		flightsWithComplaintsButNoProcedureToday := map[string]int{}
-
-		//cfMap,err := GetProcedureMap(r,dayWindow[0],dayWindow[1])
-		//if err != nil {
-		//	return str,err
-		//}
-		//for _,cf := range cfMap {
-		//	if cf.Procedure.String() != "" { flightCountsByProcedure[cf.Procedure.String()]++ }
-		//}
-		//cdb.Infof("fetched %d flight procedures", len(cfMap))
+		// Unique flights per procedure; flightnumbers are only unique within a day. Keyed on
+		// BestIdent, so that unscheduled aircraft are told apart too.
+		flightsByProcedureToday := map[string]map[string]int{}
+		var c *Complaint
+		countProcedure := func(proc string) {
+			countsByProcedure[proc]++
+			if flightsByProcedureToday[proc] == nil { flightsByProcedureToday[proc] = map[string]int{} }
+			if id := c.AircraftOverhead.BestIdent(); id != "" {
+				flightsByProcedureToday[proc][id]++
+			}
+		}
 
 		q := cdb.NewComplaintQuery().ByTimespan(dayWindow[0],dayWindow[1])
 		iter := cdb.NewComplaintIterator(q)
@@ -105,7 +114,7 @@ func (cdb *ComplaintDB)SummaryReport(start,end time.Time, countByUser bool, zipF
 		cdb.Infof("running summary across %s-%s", dayWindow[0],dayWindow[1])
 		
 		for iter.Iterate(cdb.Ctx()) {
-			c := iter.Complaint()
+			c = iter.Complaint()
 
 			// If we're filtering on ZIP codes, do it here (datastore Filters can't handle OR)
 			if len(zipFilter) > 0 {
@@ -124,16 +133,16 @@ func (cdb *ComplaintDB)SummaryReport(start,end time.Time, countByUser bool, zipF
 			if uniquesByDate[d] == nil { uniquesByDate[d] = map[string]int{} }
 			uniquesByDate[d][c.Profile.EmailAddress]++
 
-			if airline := c.AircraftOverhead.IATAAirlineCode(); airline != "" {
-				countsByAirline[airline]++
+			if code := c.AircraftOverhead.AirlineCode(); code != "" {
+				countsByAirline[code]++
+			}
+
+			if c.AircraftOverhead.IATAAirlineCode() != "" {
 				//dayCallsigns[c.AircraftOverhead.Callsign]++
 
-				//if cf,exists := cfMap[c.AircraftOverhead.FlightNumber]; exists && cf.Procedure.String()!=""{
-				//	countsByProcedure[cf.Procedure.String()]++
-				//} else {
-				//	countsByProcedure["procedure unknown"]++
-				//	flightsWithComplaintsButNoProcedureToday[c.AircraftOverhead.FlightNumber]++
-				//}
+				proc := c.AircraftOverhead.ProcedureString()
+				if proc == "" { proc = "procedure unknown" }
+				countProcedure(proc)
 
 				whitelist := map[string]int{"SFO":1, "SJC":1, "OAK":1}
 				if _,exists := whitelist[c.AircraftOverhead.Destination]; exists {
@@ -143,6 +152,14 @@ func (cdb *ComplaintDB)SummaryReport(start,end time.Time, countByUser bool, zipF
 				} else {
 					countsByAirport["airport unknown"]++ // overflights, and/or empty airport fields
 				}
+			} else if c.Unscheduled {
+				countsByAirport["unscheduled (GA etc)"]++
+				countProcedure("unscheduled (GA etc)")
+				if op := c.AircraftOverhead.OperatorName(); op != "" {
+					countsByOperator[op]++
+				} else {
+					countsByOperator["operator unknown"]++
+				}
 			} else {
 				countsByAirport["flight unidentified"]++
 				countsByProcedure["flight unidentified"]++
@@ -163,20 +180,29 @@ func (cdb *ComplaintDB)SummaryReport(start,end time.Time, countByUser bool, zipF
 				if uniquesPerDayByCity[city] == nil { uniquesPerDayByCity[city] = map[string]int{} }
 				uniquesPerDayByCity[city][c.Profile.EmailAddress + ":" + d]++
 
-//				if proceduresByCity[city] == nil { proceduresByCity[city] = map[string]int{} }
-//				if flightnumber := c.AircraftOverhead.FlightNumber; flightnumber != "" {
-//					if cf,exists := cfMap[flightnumber]; exists && cf.Procedure.String()!=""{
-//						proceduresByCity[city][cf.Procedure.Name]++
-//					} else {
-//						proceduresByCity[city]["proc?"]++
-//					}
-//				} else {
-//					proceduresByCity[city]["flight?"]++
-//				}
+				if proceduresByCity[city] == nil { proceduresByCity[city] = map[string]int{} }
+				if c.AircraftOverhead.FlightNumber != "" {
+					if name := c.AircraftOverhead.ProcedureName(); name != "" {
+						proceduresByCity[city][name]++
+					} else {
+						proceduresByCity[city]["proc?"]++
+					}
+				} else {
+					proceduresByCity[city]["flight?"]++
+				}
 			}
 			if equip := c.AircraftOverhead.EquipType; equip != "" {
 				countsByEquip[equip]++
 			}
+			if c.AircraftOverhead.BestIdent() != "" {
+				class := noiseclass.NoiseClassOf(c.AircraftOverhead.EquipType)
+				countsByNoiseClass[class]++
+				if !c.Noise.IsZero() {
+					dbSumByNoiseClass[class] += c.Noise.DB
+					dbCountsByNoiseClass[class]++
+					countsByDB[noiseclass.DBBucket(c.Noise.DB)]++
+				}
+			}
 
 		}
 		if iter.Err() != nil {
@@ -184,8 +210,9 @@ func (cdb *ComplaintDB)SummaryReport(start,end time.Time, countByUser bool, zipF
 				dayWindow[0],dayWindow[1], time.Now(), iter.Err())
 		}
 
-		unknowns := len(flightsWithComplaintsButNoProcedureToday)
-		flightCountsByProcedure["procedure unknown"] += unknowns
+		for proc,flights := range flightsByProcedureToday {
+			flightCountsByProcedure[proc] += len(flights)
+		}
 		
 		//for k,_ := range dayCallsigns { fmt.Fprintf(w, "** %s\n", k) }
 	}
@@ -210,18 +237,16 @@ func (cdb *ComplaintDB)SummaryReport(start,end time.Time, countByUser bool, zipF
 		len(countsByDate), n, len(uniquesAll))
 
 	str += fmt.Sprintf("\nComplaints per user, histogram (0-200):\n %s\n", histByUser)
-	if false {
-		str += fmt.Sprintf("\n[BETA: no more than 80%% accurate!] Disturbance reports, "+
-			"counted by procedure type, breaking out vectored flights "+
-			"(e.g. PROCEDURE/LAST-ON-PROCEDURE-WAYPOINT):\n")
-		for _,k := range keysByKeyAsc(countsByProcedure) {
-			avg := 0.0
-			if flightCountsByProcedure[k] > 0 {
-				avg = float64(countsByProcedure[k]) / float64(flightCountsByProcedure[k])
-			}
-			str += fmt.Sprintf(" %-20.20s: %6d (%5d such flights with complaints; %3.0f complaints/flight)\n",
-				k, countsByProcedure[k], flightCountsByProcedure[k], avg)	
+	str += fmt.Sprintf("\n[BETA: no more than 80%% accurate!] Disturbance reports, "+
+		"counted by procedure type, breaking out vectored flights "+
+		"(e.g. PROCEDURE/LAST-ON-PROCEDURE-WAYPOINT):\n")
+	for _,k := range keysByKeyAsc(countsByProcedure) {
+		avg := 0.0
+		if flightCountsByProcedure[k] > 0 {
+			avg = float64(countsByProcedure[k]) / float64(flightCountsByProcedure[k])
 		}
+		str += fmt.Sprintf(" %-20.20s: %6d (%5d such flights with complaints; %3.0f complaints/flight)\n",
+			k, countsByProcedure[k], flightCountsByProcedure[k], avg)	
 	}
 	
 	str += fmt.Sprintf("\nDisturbance reports, counted by airport:\n")
@@ -246,17 +271,16 @@ func (cdb *ComplaintDB)SummaryReport(start,end time.Time, countByUser bool, zipF
 		str += fmt.Sprintf(" %-40.40s: %s\n", k, userHistsByCity[k])
 	}
 
-	/*
 	str += fmt.Sprintf("\nDisturbance reports, counted by City & procedure type (where known):\n")
-	for _,k := range keysByIntValDesc(countsByCity) {		
-		pStr := fmt.Sprintf("SERFR: %.0f%%, non-SERFR: %.0f%%, flight unknown: %.0f%%",
-			100.0 * (float64(proceduresByCity[k]["SERFR2"]) / float64(countsByCity[k])),
-			100.0 * (float64(proceduresByCity[k]["proc?"]) / float64(countsByCity[k])),
-			100.0 * (float64(proceduresByCity[k]["flight?"]) / float64(countsByCity[k])))
+	for _,k := range keysByIntValDesc(countsByCity) {
+		pStrs := []string{}
+		for _,proc := range keysByIntValDesc(proceduresByCity[k]) {
+			pStrs = append(pStrs, fmt.Sprintf("%s: %.0f%%", proc,
+				100.0 * (float64(proceduresByCity[k][proc]) / float64(countsByCity[k]))))
+		}
 		str += fmt.Sprintf(" %-40.40s: %5d (%4d people reporting) (%s)\n",
-			k, countsByCity[k], len(uniquesByCity[k]), pStr)
+			k, countsByCity[k], len(uniquesByCity[k]), strings.Join(pStrs, ", "))
 	}
-*/
 	
 	str += fmt.Sprintf("\nDisturbance reports, counted by date:\n")
 	for _,k := range keysByKeyAsc(countsByDate) {
@@ -269,10 +293,33 @@ func (cdb *ComplaintDB)SummaryReport(start,end time.Time, countByUser bool, zipF
 		str += fmt.Sprintf(" %-40.40s: %5d\n", k, countsByEquip[k])
 	}
 
+	str += fmt.Sprintf("\nDisturbance reports, counted by aircraft noise class (where identified):\n")
+	for _,k := range keysByIntValDesc(countsByNoiseClass) {
+		avgStr := ""
+		if dbCountsByNoiseClass[k] > 0 {
+			avgStr = fmt.Sprintf(" (avg ~%.0fdBA at reporter)",
+				dbSumByNoiseClass[k] / float64(dbCountsByNoiseClass[k]))
+		}
+		str += fmt.Sprintf(" %-40.40s: %5d%s\n", k, countsByNoiseClass[k], avgStr)
+	}
+
+	str += fmt.Sprintf("\n[BETA: modelled, not measured] Disturbance reports, counted by "+
+		"estimated noise level at the reporter (where identified):\n")
+	for _,k := range keysByKeyAsc(countsByDB) {
+		str += fmt.Sprintf(" %-10.10s: %5d\n", k, countsByDB[k])
+	}
+
 	str += fmt.Sprintf("\nDisturbance reports, counted by Airline (where known):\n")
 	for _,k := range keysByIntValDesc(countsByAirline) {
-		if countsByAirline[k] < 5 || len(k) > 2 { continue }
-		str += fmt.Sprintf(" %s: %6d\n", k, countsByAirline[k])
+		if countsByAirline[k] < 5 { continue }
+		str += fmt.Sprintf(" %-40.40s: %6d\n", airline.Label(k), countsByAirline[k])
+	}
+
+	str += fmt.Sprintf("\nDisturbance reports about unscheduled aircraft, counted by registered "+
+		"operator (where known):\n")
+	for _,k := range keysByIntValDesc(countsByOperator) {
+		if countsByOperator[k] < 5 { continue }
+		str += fmt.Sprintf(" %-40.40s: %6d\n", k, countsByOperator[k])
 	}
 
 	str += fmt.Sprintf("\nDisturbance reports, counted by hour of day (across all dates):\n")
@@ -294,59 +341,6 @@ func (cdb *ComplaintDB)SummaryReport(start,end time.Time, countByUser bool, zipF
 
 // }}}
 
-/*
-// {{{ ReadEncodedData
-
-func ReadEncodedData(resp *http.Response, encoding string, data interface{}) error {
-	switch encoding {
-	case "gob": return gob.NewDecoder(resp.Body).Decode(data)
-	default:    return json.NewDecoder(resp.Body).Decode(data)
-	}
-}
-
-// }}}
-// {{{ GetProcedureMap
-
-// Call out to the flight database, and get back a condensed summary of the flights (flightnumber,
-// times, waypoints) which flew to/from a NORCAL airport (SFO,SJC,OAK) for the time range (a day?)
-func GetProcedureMap(r *http.Request, s,e time.Time) (map[string]fdb.CondensedFlight,error) {
-	ret := map[string]fdb.CondensedFlight{}
-
-	// This procedure map stuff is expensive, and brittle; so disable by default.
-	if r.FormValue("getProcedures") == "" {
-		return ret, nil
-	}
-	
-	client := req2client(r)
-	
-	encoding := "gob"	
-	url := fmt.Sprintf("http://fdb.serfr1.org/api/procedures?encoding=%s&tags=:NORCAL:&s=%d&e=%d",
-		encoding, s.Unix(), e.Unix())
-
-	condensedFlights := []fdb.CondensedFlight{}
-
-	if resp,err := client.Get(url); err != nil {
-		return ret,err
-	} else {
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			return ret,fmt.Errorf("Bad status fetching proc map for %s: %v", url, resp.Status)
-		} else if err := ReadEncodedData(resp, encoding, &condensedFlights); err != nil {
-			return ret,err
-		}
-	}
-
-	for _,cf := range condensedFlights {
-		ret[cf.BestFlightNumber] = cf
-	}
-	
-	return ret,nil
-}
-
-// }}}
-*/
-
 // {{{ -------------------------={ E N D }=----------------------------------
 
 // Local variables:
@@ -14,6 +14,7 @@ import (
 	fdb "github.com/skypies/flightdb"
 	"github.com/skypies/complaints/pkg/config"
 	"github.com/skypies/complaints/pkg/flightid"
+	"github.com/skypies/complaints/pkg/noiseclass"
 
 	"golang.org/x/net/context"
 
@@ -46,8 +47,11 @@ type ComplainerProfile struct {
 	StructuredAddress PostalAddress
 	Lat,Long          float64
 	Elevation         float64 // in meters
+	ElevationSource   string  `datastore:",noindex"` // who gave us Elevation; "" if nobody yet
 	CcSfo             bool `datastore:",noindex"`
-	SelectorAlgorithm string  // Users can have different algorithms (and maybe even params someday)
+	Airport           string  // ICAO code of the noise office to complain to; blank for the default
+	SelectorAlgorithm string  // Users can have different algorithms ...
+	IdentParams  flightid.Params // ... and different params; zero values mean the defaults
 
 	SendDailyEmail    int  // 0 == unset, 1 == OK/yes, -1 == no
 	DataSharing       int  // 0 == unset, 1 == OK/yes, -1 == no
@@ -209,6 +213,7 @@ const(
 	SubmissionTimeout
 	SubmissionRejected
 	SubmissionFailed
+	SubmissionInvalid // Not sent; the form failed our own validation
 )
 func (so SubmissionOutcome)String() string {
 	switch so {
@@ -217,6 +222,7 @@ func (so SubmissionOutcome)String() string {
 	case SubmissionTimeout: return "timeout"
 	case SubmissionRejected: return "reject"
 	case SubmissionFailed: return "fail"
+	case SubmissionInvalid: return "invalid"
 	default: return fmt.Sprintf("?%d?", so)
 	}
 }
@@ -252,15 +258,44 @@ type Submission struct {
 	Key          string    `datastore:",noindex"` // Foreign key, from backend
 	Attempts     int
 	Log          string    `datastore:",noindex"`
+	Backend      string    // Which noise office backend it went to, e.g. "bksv:viewpoint.emsbk.com/sfo5"
+	Remedies   []Remedy    `datastore:",noindex"` // What we did about failures (see pkg/submitter)
+}
+
+// Remedy records a decision about what to do with a failed submission.
+type Remedy struct {
+	T       time.Time
+	Reason  string // What went wrong, e.g. "reject/dupe-receipt"
+	Action  string // What we did about it, e.g. "mark-accepted"
+	Note    string
+}
+
+func (r Remedy)String() string {
+	return fmt.Sprintf("[%s] %s -> %s: %s", r.T.Format("2006/01/02 15:04:05"), r.Reason, r.Action, r.Note)
+}
+
+// HasRemedy says whether the action has already been tried.
+func (s Submission)HasRemedy(action string) bool {
+	for _,r := range s.Remedies {
+		if r.Action == action { return true }
+	}
+	return false
+}
+
+// LastRemedy is the most recent remedy, if there was one.
+func (s Submission)LastRemedy() (Remedy, bool) {
+	if len(s.Remedies) == 0 { return Remedy{}, false }
+	return s.Remedies[len(s.Remedies)-1], true
 }
 
 func (s Submission)WasFailure() bool {
-	return s.Outcome == SubmissionTimeout || s.Outcome == SubmissionRejected || s.Outcome == SubmissionFailed
+	return s.Outcome == SubmissionTimeout || s.Outcome == SubmissionRejected ||
+		s.Outcome == SubmissionFailed || s.Outcome == SubmissionInvalid
 }
 
 func (s Submission)String() string {
 	str := s.Outcome.String()
-	if s.Outcome == SubmissionRejected {
+	if s.Outcome == SubmissionRejected || s.Outcome == SubmissionInvalid {
 		srr,txt := s.ClassifyRejection()
 		str += "/" + srr.String()
 		if srr == SubmissionRejectBadField {
@@ -272,8 +307,10 @@ func (s Submission)String() string {
 
 // {{{ s.ClasifyRejection
 
+// ClassifyRejection works for remote rejections, and also for forms that failed our own
+// validation (which look like remote bad-field rejections).
 func (s Submission)ClassifyRejection() (SubmissionRejectReason, string) {
-	if s.Outcome != SubmissionRejected {
+	if s.Outcome != SubmissionRejected && s.Outcome != SubmissionInvalid {
 		return SubmissionNoReject, "not rejected"
 	}
 
@@ -389,7 +426,30 @@ type Complaint struct {
 	Description      string        `datastore:",noindex"`
 	Timestamp        time.Time
 	AircraftOverhead flightid.Aircraft
-	Debug            string        `datastore:",noindex"` // Debugging; mostly about flight lookup
+	Unscheduled      bool          // AircraftOverhead is GA etc, not an airline flight
+	Debug            string        `datastore:",noindex"` // Old complaints only; now see IdentTrace()
+
+	// How the aircraft was identified; a flightid.IdentificationTrace, as JSON. The outcome code
+	// is copied out so that it can be queried on.
+	IdentOutcome     string
+	IdentTraceJSON   []byte        `datastore:",noindex"`
+
+	// If the user corrected the flight, the automatic pick is kept here as it's our only source
+	// of ground truth about identification quality.
+	FlightCorrected      bool
+	AutoAircraftOverhead flightid.Aircraft
+	AirspaceSnapshotId   string    `datastore:",noindex"` // The airspace we identified from
+	IdentParams      flightid.Params // The params actually used (i.e. after defaults)
+	Consensus        flightid.ConsensusRecord // If the airspace was fused from several sources
+	Reidentified     Reidentification // If identification was re-run after the fact
+
+	// How sure we were of the automatic pick, and the next best few aircraft (or, if nothing was
+	// picked, the best few).
+	IdentConfidence  float64       `datastore:",noindex"`
+	Alternates       []flightid.Candidate
+
+	// The modelled sound level at the complainer's location, from AircraftOverhead
+	Noise            noiseclass.Estimate
 
 	HeardSpeedbreaks bool
 	Loudness         int           `datastore:",noindex"` // 0=undef, 1=loud, 2=very loud, 3=insane
@@ -474,6 +534,7 @@ type ComplaintsAndProfile struct {
 	Profile ComplainerProfile
 	Complaints []Complaint
 	Counts []CountItem
+	Exposure DailyExposure // Overflights for the day, if known
 }
 
 // }}}
@@ -500,11 +561,17 @@ type AnonymizedComplaint struct {
 	// Aircraft details; might be null
 	FlightKey        string // Flightnumber plus date (e.g. "UA123-20161231") - to allow for joins
 	FlightNumber     string // IATA scheduled flight number
-	AirlineCode      string // The 2-char IATA airline code
+	AirlineCode      string // The 2-char IATA airline code (or ICAO, if it has none; see pkg/airline)
+	AirlineName      string // The operator, e.g. "United Airlines"
+	AirlineCountry   string // The operator's home country, e.g. "US"
 	
 	Origin           string
 	Destination      string
 	EquipType        string // B744, etc
+	EngineType       string // jet, turboprop, piston, helicopter (see pkg/noiseclass)
+	WeightClass      string // super, heavy, large, small
+	NoiseChapter     int    // ICAO Annex 16 noise certification chapter
+	NoiseClass       string // e.g. "heavy jet, ch3"
 
 	geo.Latlong      // embedded; location of aircraft at Timestamp
 	PressureAltitude float64
@@ -2,25 +2,26 @@ package complaintdb
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/skypies/geo"
 	"github.com/skypies/util/date"
 
-	"github.com/skypies/pi/airspace"
-	"github.com/skypies/flightdb/fr24"
-
 	"github.com/skypies/complaints/pkg/flightid"
 )
 
+// Complaints from these caller codes are identified from a consensus of all the sources
+var consensusCallerCodes = map[string]int{
+	"WOR004": 1,
+	"WOR005": 1,
+}
+
 // {{{ cdb.complainByProfile
 
 func (cdb ComplaintDB) complainByProfile(cp ComplainerProfile, c *Complaint) error {
-	client := cdb.HTTPClient()
-	overhead := flightid.Aircraft{}
-
 	// Check we're not over a daily cap for this user
 	cdb.Debugf("cbe_010", "doing rate limit check for %s", cp.EmailAddress)
-	s,e := date.WindowForToday()
+	s,e := date.WindowForTime(date.InPdt(cdb.Now()))
 	//if prevKeys,err := cdb.GetComplaintKeysInSpanByEmailAddress(s,e,cp.EmailAddress); err != nil {
 	if prevKeys,err := cdb.LookupAllKeys(cdb.CQByEmail(cp.EmailAddress).ByTimespan(s,e)); err != nil {
 		return err
@@ -30,50 +31,104 @@ func (cdb ComplaintDB) complainByProfile(cp ComplainerProfile, c *Complaint) err
 		cdb.Debugf("cbe_011", "rate limit check passed (%d); calling FindOverhead", len(prevKeys))
 	}
 	
+	if filled,err := cdb.FillProfileElevation(&cp); err != nil {
+		cdb.Errorf("complainByProfile: %v", err)
+	} else if filled {
+		cdb.Infof("elevation for %s looked up as %.0fm", cp.EmailAddress, cp.Elevation)
+		if err := cdb.PersistProfile(cp); err != nil {
+			cdb.Errorf("complainByProfile: %v", err)
+		}
+	}
 	elev := cp.ElevationFeet()
 	pos := geo.Latlong{cp.Lat,cp.Long}
 
 	algoName := cp.SelectorAlgorithm
 	if (c.Description == "ANYANY") { algoName = "random" }
-	algo := flightid.NewSelector(algoName)
+	algo := flightid.NewSelectorWithParams(algoName, cp.IdentParams)
+
+	// The trajectory selector needs the tracks from just before the button press
+	if traj,ok := algo.(flightid.AlgoTrajectory); ok {
+		window := traj.Params.WithDefaults().TrajectoryWindow()
+		if tracks,err := cdb.recentTracks(pos.Box(64,64), window); err != nil {
+			cdb.Errorf("complainByProfile: %v; not using the trajectory selector", err)
+			algoName = flightid.LiveSelectorName(algoName)
+			algo = flightid.NewSelectorWithParams(algoName, cp.IdentParams)
+		} else {
+			traj.Tracks = tracks
+			algo = traj
+		}
+	}
+	c.IdentParams = algo.Parameters()
+
+	// Some users get a fused airspace from all the sources, so we can see how well they agree
+	src := cdb.AirspaceSource()
+	if _,exists := consensusCallerCodes[cp.CallerCode]; exists {
+		if _,isConsensus := src.(flightid.ConsensusAirspaceSource); !isConsensus {
+			src = flightid.NewConsensusAirspaceSource()
+		}
+	}
+
+	tFetch := time.Now() // Not cdb.Now(); replays need to line up with the message timestamps
+	as,cons,err := fetchAirspace(src, pos.Box(64,64))
+	latency := time.Since(tFetch)
 
-	if as,err := fr24.GRPCFetchAirspace(pos.Box(64,64)); err != nil {
+	sources := []string{src.String()}
+	if cons != nil { sources = cons.Order }
+
+	if err != nil {
 		cdb.Errorf("FindOverhead failed for %s: %v", cp.EmailAddress, err)
+		tr := flightid.IdentificationTrace{
+			Time: tFetch,
+			Sources: sources,
+			FetchLatencyMS: latency.Milliseconds(),
+			Selector: algoName,
+			Params: c.IdentParams,
+			OutcomeCode: flightid.OutcomeFetchFailed,
+			Error: err.Error(),
+		}
+		if err := c.SetIdentTrace(tr); err != nil {
+			cdb.Errorf("complainByProfile: %v", err)
+		}
+
 	} else {
-		oh,deb := flightid.IdentifyOverhead(as,pos,elev,algo)
-		c.Debug = deb
+		qnh := cdb.correctAltitudes(as, cons)
+		res := flightid.IdentifyOverhead(as,pos,elev,algo)
+		oh := res.Flight
 		if oh != nil {
-			overhead = *oh
-			c.AircraftOverhead = overhead
+			c.AircraftOverhead = *oh
+			c.Unscheduled = oh.IsUnscheduled()
+			c.EstimateNoise()
 		}
-	}
+		c.IdentConfidence = res.Confidence
+		c.Alternates = res.Alternates(KMaxAlternates)
 
-	cdb.Debugf("cbe_020", "FindOverhead returned")
-	
-	// Contrast with the skypi pathway
-	if cp.CallerCode == "WOR004" || cp.CallerCode == "WOR005" {
-		asFdb,_ := airspace.Fetch(client, "", "fdb", pos.Box(60,60))
-		oh3,deb3 := flightid.IdentifyOverhead(asFdb,pos,elev,algo)
-		if oh3 == nil { oh3 = &flightid.Aircraft{} }
-		newdebug := c.Debug + "\n*** v2 / fdb testing\n" + deb3 + "\n"
-		headline := ""
-
-		asAex,_ := airspace.Fetch(client, "", "aex", pos.Box(60,60))
-		oh4,deb4 := flightid.IdentifyOverhead(asAex,pos,elev,algo)
-		newdebug += "\n*** v3 / AdsbExchange testing\n" + deb4 + "\n"
-		_=oh4
-		
-		if overhead.FlightNumber != oh3.FlightNumber {
-			headline = fmt.Sprintf("** * * DIFFERS * * **\n")
-		} else {
-			// Agree ! Copy over the Fdb IdSpec, and pretend we're living in the future
-			headline = fmt.Sprintf("**---- Agrees ! ----**\n")
-			c.AircraftOverhead.Id = oh3.Id
+		if cons != nil {
+			c.Consensus = cons.Record(pos, elev, algo, oh)
+		}
+
+		tr := res.Trace
+		tr.Sources = sources
+		tr.FetchLatencyMS = latency.Milliseconds()
+		tr.QNH = qnh
+		if err := c.SetIdentTrace(tr); err != nil {
+			cdb.Errorf("complainByProfile: %v", err)
 		}
-		headline += fmt.Sprintf(" * skypi: %s\n * orig : %s\n", oh3, overhead)
-		
-		c.Debug = headline + newdebug
+
+		c.AirspaceSnapshotId = flightid.SnapshotId(tFetch)
+		cdb.recordSnapshot(flightid.Snapshot{
+			Id: c.AirspaceSnapshotId,
+			Time: tFetch,
+			Pos: pos,
+			Elev: elev,
+			Source: src.String(),
+			Selector: algoName,
+			Params: c.IdentParams,
+			Pick: flightid.PickIdent(oh),
+			Airspace: *as,
+		})
 	}
+
+	cdb.Debugf("cbe_020", "FindOverhead returned")
 	
 	c.Profile = cp // Copy the profile fields into every complaint
 	
@@ -92,7 +147,7 @@ func (cdb ComplaintDB) complainByProfile(cp ComplainerProfile, c *Complaint) err
 	}
 
 	cdb.Debugf("cbe_033", "returned, distinct/first; about to put()")
-	err := cdb.PersistComplaint(*c)
+	err = cdb.PersistComplaint(*c)
 	cdb.Debugf("cbe_034", "new entity added (all done)")
 
 	return err
@@ -121,7 +176,7 @@ func (cdb ComplaintDB) ComplainByCallerCode(cc string, c *Complaint) error {
 	if profiles, err := cdb.LookupAllProfiles(cdb.NewProfileQuery().ByCallerCode(cc)); err != nil {
 		return err
 	} else if len(profiles) != 1 {
-		return fmt.Errorf("ComplainByCallerCode: %d profiles for id='%s'", len(profiles))
+		return fmt.Errorf("ComplainByCallerCode: %d profiles for id='%s'", len(profiles), cc)
 	} else {
 		return cdb.complainByProfile(profiles[0], c)
 	}
@@ -134,7 +189,7 @@ func (cdb ComplaintDB) ComplainByButtonId(id string, c *Complaint) error {
 	if profiles, err := cdb.LookupAllProfiles(cdb.NewProfileQuery().ByButton(id)); err != nil {
 		return err
 	} else if len(profiles) != 1 {
-		return fmt.Errorf("ComplainByButtonId: %d profiles for id='%s'", len(profiles))
+		return fmt.Errorf("ComplainByButtonId: %d profiles for id='%s'", len(profiles), id)
 	} else {
 		return cdb.complainByProfile(profiles[0], c)
 	}
@@ -0,0 +1,73 @@
+package config
+
+import(
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// LoadTable parses a data table that is compiled into the binary, and then overlays it with the
+// table in the file named by config key (if any). If the overlay can't be read or parsed, the
+// bundled table is returned along with the error, so callers can carry on without the overlay.
+func LoadTable[T any](bundled, key string, parse func(io.Reader) (T, error), overlay func(T, T)) (T, error) {
+	t,err := parse(strings.NewReader(bundled))
+	if err != nil {
+		return t, fmt.Errorf("LoadTable(%s): bundled: %v", key, err)
+	}
+	return t, overlayTable(t, key, parse, overlay)
+}
+
+func overlayTable[T any](t T, key string, parse func(io.Reader) (T, error), overlay func(T, T)) error {
+	filename := Get(key)
+	if filename == "" {
+		return nil
+	}
+
+	f,err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("LoadTable(%s): %v", key, err)
+	}
+	defer f.Close()
+
+	other,err := parse(f)
+	if err != nil {
+		return fmt.Errorf("LoadTable(%s): %s: %v", key, filename, err)
+	}
+	overlay(t, other)
+
+	return nil
+}
+
+// A DefaultTable is a package's own copy of a LoadTable table, loaded the first time anything
+// asks for it. If even the bundled table won't parse, it holds Empty() instead.
+type DefaultTable[T any] struct {
+	Bundled string
+	Key     string
+	Parse   func(io.Reader) (T, error)
+	Overlay func(T, T)
+	Empty   func() T
+
+	once sync.Once
+	t    T
+	err  error
+}
+
+// Load returns the table, along with the error (if any) from the first time it was loaded.
+func (dt *DefaultTable[T])Load() (T, error) {
+	dt.once.Do(func() {
+		if t,err := dt.Parse(strings.NewReader(dt.Bundled)); err != nil {
+			dt.t,dt.err = dt.Empty(), fmt.Errorf("LoadTable(%s): bundled: %v", dt.Key, err)
+		} else {
+			dt.t,dt.err = t, overlayTable(t, dt.Key, dt.Parse, dt.Overlay)
+		}
+	})
+	return dt.t, dt.err
+}
+
+// Get is Load, for callers that carry on regardless.
+func (dt *DefaultTable[T])Get() T {
+	t,_ := dt.Load()
+	return t
+}
@@ -0,0 +1,134 @@
+package elevation
+
+// An offline digital elevation model, from SRTM .hgt tiles (e.g. from
+//   https://dwtkns.com/srtm30m/ or the USGS EarthExplorer).
+//
+// Each tile covers one degree square, and is named after its south-west corner; N37W122.hgt
+// covers 37N-38N, 122W-121W. It is a square grid of big-endian int16 heights in meters, rows
+// running north to south; 3601x3601 samples for 1 arc-second data, 1201x1201 for 3 arc-second.
+// The tiles for the Bay Area and Santa Cruz mountains are N36W122, N37W122, N37W123 & N38W123.
+
+import(
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/skypies/geo"
+)
+
+const kVoid = -32768 // SRTM's marker for missing data
+
+// {{{ tile
+
+type tile struct {
+	lat,long int // south-west corner
+	n        int // samples per side
+	data     []int16
+}
+
+func tileName(lat, long int) string {
+	ns,ew := "N","E"
+	if lat < 0 { ns,lat = "S",-lat }
+	if long < 0 { ew,long = "W",-long }
+	return fmt.Sprintf("%s%02d%s%03d.hgt", ns, lat, ew, long)
+}
+
+func loadTile(filename string, lat, long int) (*tile, error) {
+	b,err := os.ReadFile(filename)
+	if err != nil { return nil, err }
+
+	n := int(math.Sqrt(float64(len(b)/2)))
+	if n < 2 || n*n*2 != len(b) {
+		return nil, fmt.Errorf("%s: %d bytes isn't a square grid", filename, len(b))
+	}
+
+	t := &tile{lat:lat, long:long, n:n, data:make([]int16, n*n)}
+	for i := range t.data {
+		t.data[i] = int16(binary.BigEndian.Uint16(b[2*i:]))
+	}
+	return t, nil
+}
+
+func (t *tile)at(row, col int) int16 { return t.data[row*t.n + col] }
+
+// elevationAt interpolates bilinearly between the four surrounding samples.
+func (t *tile)elevationAt(pos geo.Latlong) (float64, error) {
+	y := (float64(t.lat+1) - pos.Lat) * float64(t.n-1)  // rows, down from the north edge
+	x := (pos.Long - float64(t.long)) * float64(t.n-1) // cols, across from the west edge
+
+	r,c := int(math.Floor(y)), int(math.Floor(x))
+	if r > t.n-2 { r = t.n-2 }
+	if c > t.n-2 { c = t.n-2 }
+	fy,fx := y - float64(r), x - float64(c)
+
+	corners := []int16{t.at(r,c), t.at(r,c+1), t.at(r+1,c), t.at(r+1,c+1)}
+	for _,h := range corners {
+		if h == kVoid { return 0, ErrNoData }
+	}
+
+	top := float64(corners[0])*(1-fx) + float64(corners[1])*fx
+	bottom := float64(corners[2])*(1-fx) + float64(corners[3])*fx
+	return top*(1-fy) + bottom*fy, nil
+}
+
+// }}}
+// {{{ DEMService
+
+// DEMService reads .hgt tiles from a directory, loading each one the first time it is needed.
+type DEMService struct {
+	Dir   string
+
+	mu    sync.Mutex
+	tiles map[string]*tile // A nil entry means we looked, and there was no tile
+}
+
+func NewDEMService(dir string) *DEMService {
+	return &DEMService{Dir:dir, tiles:map[string]*tile{}}
+}
+
+func (s *DEMService)String() string { return "dem:" + s.Dir }
+
+func (s *DEMService)tileFor(pos geo.Latlong) (*tile, error) {
+	lat,long := int(math.Floor(pos.Lat)), int(math.Floor(pos.Long))
+	name := tileName(lat, long)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t,exists := s.tiles[name]; exists {
+		return t, nil
+	}
+
+	filename := filepath.Join(s.Dir, name)
+	t,err := loadTile(filename, lat, long)
+	if os.IsNotExist(err) {
+		t,err = nil, nil // Remember that there's no tile; it's not an error
+	} else if err != nil {
+		return nil, fmt.Errorf("DEMService: %v", err)
+	}
+	s.tiles[name] = t
+	return t, nil
+}
+
+func (s *DEMService)ElevationAt(pos geo.Latlong) (float64, error) {
+	t,err := s.tileFor(pos)
+	if err != nil {
+		return 0, err
+	} else if t == nil {
+		return 0, ErrNoData
+	}
+	return t.elevationAt(pos)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
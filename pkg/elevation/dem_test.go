@@ -0,0 +1,69 @@
+package elevation
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skypies/geo"
+)
+
+// {{{ TestDEMService
+
+func TestDEMService(t *testing.T) {
+	dir := t.TempDir()
+
+	// A 3x3 tile for N37W122; heights rise from 0m in the west to 200m in the east, except for
+	// a void in the south-east corner.
+	heights := []int16{
+		0, 100, 200,
+		0, 100, 200,
+		0, 100, kVoid,
+	}
+	b := make([]byte, 2*len(heights))
+	for i,h := range heights { binary.BigEndian.PutUint16(b[2*i:], uint16(h)) }
+	if err := os.WriteFile(filepath.Join(dir, "N37W122.hgt"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewDEMService(dir)
+	tests := []struct{
+		pos geo.Latlong
+		expected float64
+	}{
+		{geo.Latlong{37.75, -122.0}, 0},    // west edge
+		{geo.Latlong{37.75, -121.5}, 100},  // middle
+		{geo.Latlong{37.75, -121.75}, 50},  // interpolated
+		{geo.Latlong{37.9999999, -121.0000001}, 200}, // north-east corner (38N,121W is the next tile)
+	}
+	for _,test := range tests {
+		if elev,err := s.ElevationAt(test.pos); err != nil {
+			t.Errorf("%v: %v", test.pos, err)
+		} else if math.Abs(elev - test.expected) > 0.01 {
+			t.Errorf("%v: expected %.1fm, got %.1fm", test.pos, test.expected, elev)
+		}
+	}
+
+	if _,err := s.ElevationAt(geo.Latlong{37.1, -121.1}); err != ErrNoData {
+		t.Errorf("void: expected ErrNoData, got %v", err)
+	}
+	if _,err := s.ElevationAt(geo.Latlong{40.5, -100.5}); err != ErrNoData {
+		t.Errorf("no tile: expected ErrNoData, got %v", err)
+	}
+
+	if name := tileName(-34, 151); name != "S34E151.hgt" {
+		t.Errorf("tileName: %s", name)
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
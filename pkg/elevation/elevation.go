@@ -0,0 +1,39 @@
+package elevation
+
+// Package elevation looks up the height of the ground at a location, so that we know how high
+// above the user an aircraft really is. Hand-entered profile elevations are often missing (zero),
+// which in the hills makes every aircraft look further away than it is.
+
+import(
+	"errors"
+
+	"github.com/skypies/geo"
+
+	"github.com/skypies/complaints/pkg/config"
+)
+
+// ErrNoData means the service has no elevation for the location (e.g. outside its tiles).
+var ErrNoData = errors.New("no elevation data")
+
+// Service is a role for things that know the elevation of the ground.
+type Service interface {
+	String() string
+	ElevationAt(pos geo.Latlong) (float64, error) // Meters above mean sea level
+}
+
+// DefaultService is the DEM in the directory named by config "elevation.dem"; or nil, if that
+// isn't set.
+func DefaultService() Service {
+	if dir := config.Get("elevation.dem"); dir != "" {
+		return NewDEMService(dir)
+	}
+	return nil
+}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
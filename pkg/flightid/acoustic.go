@@ -0,0 +1,123 @@
+package flightid
+
+import(
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/skypies/geo"
+)
+
+// AlgoAcoustic is the "New algorithm" sketched at the bottom of flightid.go. Rather than take
+// the aircraft that is closest right now, it takes the one that was closest at the moment it
+// emitted the sound that the user heard when they pressed the button. The speed of sound, and
+// how late we assume the user was in pressing the button, are tunable via Params.
+type AlgoAcoustic struct {
+	Params      Params
+	ButtonPress time.Time // When the button was pressed; zero means now.
+}
+
+const(
+	KSpeedOfSoundMPS = 343.0 // Dry air, 20C, sea level
+	KAcousticHandicap = 2 * time.Second
+)
+
+func NewAlgoAcoustic() AlgoAcoustic {
+	return AlgoAcoustic{Params:DefaultParams()}
+}
+
+func (a AlgoAcoustic)String() string {
+	p := a.Params.WithDefaults()
+	return fmt.Sprintf("Closest when the sound was emitted (%.0fm/s, %s handicap) [EXPERIMENTAL]",
+		p.SpeedOfSoundMPS, p.Handicap())
+}
+
+func (a AlgoAcoustic)Parameters() Params { return a.Params }
+
+func (a AlgoAcoustic)speedOfSound() float64 { return a.Params.WithDefaults().SpeedOfSoundMPS }
+func (a AlgoAcoustic)maxDistKM() float64 { return a.Params.WithDefaults().MaxDistKM }
+func (a AlgoAcoustic)buttonPress() time.Time {
+	if a.ButtonPress.IsZero() { return time.Now() }
+	return a.ButtonPress
+}
+
+// {{{ a.EmissionPosition
+
+// EmissionPosition returns a copy of the aircraft, moved back to where it was when it emitted
+// the sound that reached the observer at time tHeard, along with the sound's travel time.
+// The travel time depends on the distance, which depends on the travel time; a few rounds of
+// refinement converge quickly, as aircraft move much slower than sound.
+func (a AlgoAcoustic)EmissionPosition(ac Aircraft, pos geo.Latlong, elev float64, tHeard time.Time) (Aircraft, time.Duration) {
+	tp := ac.Trackpoint()
+	delay := time.Duration(0)
+
+	for i:=0; i<3; i++ {
+		// Steps 1 & 3: extrapolate (forwards, or back) to the candidate emission time
+		emitTP := tp.RepositionByTime(tHeard.Add(-delay).Sub(tp.TimestampUTC))
+		// Step 2: the sound delay over the 3D distance from there
+		distKM := pos.Dist3(emitTP.Latlong, emitTP.Altitude-elev)
+		delay = time.Duration(distKM * 1000.0 / a.speedOfSound() * float64(time.Second))
+	}
+
+	new := ac
+	new.FromTrackpoint(tp.RepositionByTime(tHeard.Add(-delay).Sub(tp.TimestampUTC)))
+	new.Dist = pos.DistKM(new.Latlong())
+	new.Dist3 = pos.Dist3(new.Latlong(), new.Altitude-elev)
+	new.BearingFromObserver = pos.BearingTowards(new.Latlong())
+
+	return new, delay
+}
+
+// }}}
+// {{{ a.Score
+
+// Score implements Scorer; aircraft are scored on where they were when they emitted the sound
+// that was heard, not where they are now.
+func (a AlgoAcoustic)Score(pos geo.Latlong, elev float64, in []Aircraft) []float64 {
+	tHeard := a.buttonPress().Add(-1 * a.Params.WithDefaults().Handicap())
+	scores := []float64{}
+	for _,ac := range in {
+		emitted,_ := a.EmissionPosition(ac, pos, elev, tHeard)
+		scores = append(scores, InverseSquareScore(emitted.Dist3))
+	}
+	return scores
+}
+
+// }}}
+// {{{ a.Identify
+
+func (a AlgoAcoustic)Identify(pos geo.Latlong, elev float64, in []Aircraft) (*Aircraft,string) {
+	if len(in) == 0 {
+		return nil, "nothing in list"
+	}
+
+	// Step 0: the user heard the noise a little before they pressed the button
+	tHeard := a.buttonPress().Add(-1 * a.Params.WithDefaults().Handicap())
+
+	emitted := []Aircraft{}
+	for _,ac := range in {
+		new,_ := a.EmissionPosition(ac, pos, elev, tHeard)
+		emitted = append(emitted, new)
+	}
+
+	// Steps 4 & 5
+	sort.Sort(AircraftByDist3(emitted))
+
+	if emitted[0].Dist3 >= a.maxDistKM() {
+		return nil, fmt.Sprintf("not picked; closest at emission was too far away (%.1fKM, >%.0fKM)",
+			emitted[0].Dist3, a.maxDistKM())
+	}
+
+	return &emitted[0], fmt.Sprintf("picked closest at emission (%.1fKM away, %.1fs sound delay)",
+		emitted[0].Dist3, emitted[0].Dist3 * 1000.0 / a.speedOfSound())
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
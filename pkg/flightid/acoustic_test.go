@@ -0,0 +1,70 @@
+package flightid
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skypies/geo"
+)
+
+// {{{ TestAcousticSelector
+
+// Stationary aircraft (no groundspeed) don't move under extrapolation, so the acoustic
+// selector should fall back to picking the closest one. Moving ones get picked on where they
+// were when the sound was emitted.
+func TestAcousticSelector(t *testing.T) {
+	pos := geo.Latlong{Lat:37.060312, Long:-121.990814}
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	mk := func(id string, lat float64, alt float64) Aircraft {
+		a := Aircraft{Id:id, FlightNumber:id, Lat:lat, Long:pos.Long, Altitude:alt,
+			Epoch:float64(now.Add(-6*time.Second).Unix())}
+		a.Dist3 = pos.Dist3(a.Latlong(), a.Altitude)
+		return a
+	}
+
+	algo := NewAlgoAcoustic()
+	algo.ButtonPress = now
+
+	if oh,_ := algo.Identify(pos, 0, nil); oh != nil {
+		t.Errorf("picked %s from an empty list", oh)
+	}
+
+	in := []Aircraft{mk("UA1", pos.Lat+0.05, 9000), mk("AS2", pos.Lat+0.01, 4000)}
+	oh,str := algo.Identify(pos, 0, in)
+	if oh == nil || oh.FlightNumber != "AS2" {
+		t.Errorf("expected AS2, got %v (%s)", oh, str)
+	}
+
+	// A jet at 250kt that passed overhead and is now 6KM to the north, vs. a hovering helicopter
+	// 5KM to the south. The helicopter is closer now, but the noise heard came from the jet,
+	// back when it was much closer.
+	jet := mk("UA3", pos.Lat+0.054, 3000)
+	jet.Speed, jet.Track, jet.Epoch = 250, 0, float64(now.Unix())
+	heli := mk("N12345", pos.Lat-0.045, 3000)
+	heli.Epoch = float64(now.Unix())
+	moving := []Aircraft{jet, heli}
+	if heli.Dist3 >= jet.Dist3 {
+		t.Fatalf("bad fixture; N12345 (%.1fKM) should be closer now than UA3 (%.1fKM)", heli.Dist3, jet.Dist3)
+	}
+	if oh,str := algo.Identify(pos, 0, moving); oh == nil || oh.Id != "UA3" {
+		t.Errorf("expected acoustic to pick UA3, got %v (%s)", oh, str)
+	} else if oh.Lat >= jet.Lat || oh.Dist3 >= heli.Dist3 {
+		t.Errorf("UA3 not moved back to where it was when heard: %.4f, %.1fKM", oh.Lat, oh.Dist3)
+	}
+
+	far := []Aircraft{mk("UA1", pos.Lat+0.5, 9000)}
+	if oh,str := algo.Identify(pos, 0, far); oh != nil {
+		t.Errorf("picked far away aircraft %s (%s)", oh, str)
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -6,6 +6,9 @@ import(
 	"time"
 	"github.com/skypies/geo"
 	fdb "github.com/skypies/flightdb"
+
+	"github.com/skypies/complaints/pkg/aircraftreg"
+	"github.com/skypies/complaints/pkg/airline"
 )
 
 /* plan for procs
@@ -59,6 +62,11 @@ type Aircraft struct {
 	DepartureProcedureName string `datastore:",noindex"`
 	DepartureProcedureLastWaypoint string `datastore:",noindex"`
 	Tags string `datastore:",noindex"` // comma-sep list
+
+	// From the registration database, if the aircraft is in it (see AddRegistration)
+	Operator string `datastore:",noindex"`
+	AircraftType string `datastore:",noindex"` // e.g. CESSNA 172S
+	OperatorLocation string `datastore:",noindex"` // e.g. "based at PAO"
 }
 
 type AircraftByDist3 []Aircraft
@@ -83,10 +91,47 @@ func (a Aircraft)BestIdent() string {
 		return a.FlightNumber
 	} else if a.Registration != "" {
 		return "r:"+a.Registration
+	} else if a.Callsign != "" {
+		return a.Callsign
+	} else if a.Id2 != "" {
+		return "icao:"+a.Id2
+	}
+	return ""
+}
+
+// IsUnscheduled is true for an identifiable aircraft that isn't a scheduled flight; GA,
+// helicopters, bizjets etc. These only get picked if Params.AllowUnscheduled is set.
+func (a Aircraft)IsUnscheduled() bool {
+	return a.FlightNumber == "" && a.BestIdent() != ""
+}
+
+// Airline resolves the operator from the flight number or callsign (see pkg/airline).
+func (a Aircraft)Airline() (airline.Airline, bool) {
+	return airline.Resolve(a.FlightNumber, a.Callsign)
+}
+
+// AirlineCode is the operator's IATA code (or ICAO code, if it has no IATA code). Operators
+// that aren't in the registry fall back to the prefix of the IATA flight number, or of the ICAO
+// callsign; it is blank if there's neither.
+func (a Aircraft)AirlineCode() string {
+	if al,exists := a.Airline(); exists {
+		return al.Code()
+	} else if code := a.IATAAirlineCode(); code != "" {
+		return code
+	}
+	for _,str := range []string{a.FlightNumber, a.Callsign} {
+		if code := airline.ICAOPrefix(str); code != "" { return code }
 	}
 	return ""
 }
 
+// AirlineName is the operator's name, e.g. "United Airlines"; blank if it couldn't be resolved.
+func (a Aircraft)AirlineName() string {
+	al,_ := a.Airline()
+	return al.Name
+}
+
+// IATAAirlineCode is the prefix of an IATA flight number, whether or not it's in the registry.
 func (a Aircraft)IATAAirlineCode() string {
 	// Stolen from flightdb2/identity.go
 	iata := regexp.MustCompile("^([A-Z][0-9A-Z])([0-9]{1,4})$").FindStringSubmatch(a.FlightNumber)
@@ -97,6 +142,46 @@ func (a Aircraft)IATAAirlineCode() string {
 	return ""
 }
 
+// {{{ a.AddRegistration, a.OperatorName, a.OperatorString
+
+// AddRegistration looks the aircraft up in the registration database, and fills in the operator
+// fields (and the registration, if we only had the ModeS). Returns false if it wasn't found.
+func (a *Aircraft)AddRegistration() bool {
+	r,exists := aircraftreg.Lookup(a.Registration, a.Id2)
+	if !exists { return false }
+
+	if a.Registration == "" { a.Registration = r.Tail }
+	a.Operator = r.Owner
+	a.AircraftType = r.AircraftType()
+	a.OperatorLocation = r.Location()
+	return true
+}
+
+// withRegistration is a copy with the operator fields filled in, for aircraft that were stored
+// before we had the registration database.
+func (a Aircraft)withRegistration() Aircraft {
+	if a.Operator == "" { a.AddRegistration() }
+	return a
+}
+
+// OperatorName is the registered owner/operator, or "" if we don't know.
+func (a Aircraft)OperatorName() string {
+	return a.withRegistration().Operator
+}
+
+// OperatorString is e.g. "WEST VALLEY FLYING CLUB (CESSNA 172S), based at PAO"; or "" if the
+// aircraft isn't in the registration database.
+func (a Aircraft)OperatorString() string {
+	a = a.withRegistration()
+	if a.Operator == "" { return "" }
+	str := a.Operator
+	if a.AircraftType != "" { str += " (" + a.AircraftType + ")" }
+	if a.OperatorLocation != "" { str += ", " + a.OperatorLocation }
+	return str
+}
+
+// }}}
+
 func (a Aircraft)Trackpoint() fdb.Trackpoint {
 	return fdb.Trackpoint{
 		Latlong: geo.Latlong{a.Lat, a.Long},
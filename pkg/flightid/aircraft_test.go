@@ -0,0 +1,40 @@
+package flightid
+
+import(
+	"testing"
+)
+
+// {{{ TestAirlineCode
+
+func TestAirlineCode(t *testing.T) {
+	tests := []struct{
+		flightnumber, callsign string
+		expected string
+	}{
+		{"UA337", "UAL337", "UA"},
+		{"", "UAL337", "UA"},   // Resolved via the registry
+		{"ZZ123", "", "ZZ"},    // Not in the registry; the IATA prefix
+		{"", "ZZZ123", "ZZZ"},  // Not in the registry; the ICAO prefix
+		{"ZZZ123", "", "ZZZ"},
+		{"", "N123AB", ""},     // A tail number
+		{"", "", ""},
+	}
+
+	for _,test := range tests {
+		a := Aircraft{FlightNumber: test.flightnumber, Callsign: test.callsign}
+		if code := a.AirlineCode(); code != test.expected {
+			t.Errorf("AirlineCode(%q,%q): expected %q, got %q", test.flightnumber, test.callsign,
+				test.expected, code)
+		}
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,194 @@
+package flightid
+
+import(
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/flightdb/fr24"
+	"github.com/skypies/geo"
+	"github.com/skypies/pi/airspace"
+
+	"github.com/skypies/complaints/pkg/config"
+)
+
+// AirspaceSource is a role for things that can tell us what's in the sky right now.
+type AirspaceSource interface {
+	String() string
+
+	// Fetch the aircraft currently inside the box. Sources may return aircraft from outside
+	// the box too; IdentifyOverhead does its own distance filtering.
+	FetchAirspace(box geo.LatlongBox) (*airspace.Airspace, error)
+}
+
+// AirspaceSourceNames are the live sources. A recorded airspace can be replayed via the
+// name "file:/path/to/airspace.json". Several sources can be fused together via names like
+// "consensus:fr24,fdb" ("consensus" on its own fuses all the live sources).
+var AirspaceSourceNames = []string{"fr24", "fdb", "aex"}
+
+// {{{ NewAirspaceSource, DefaultAirspaceSource
+
+// NewAirspaceSource returns the named source; unknown names get fr24.
+func NewAirspaceSource(name string) AirspaceSource {
+	if strings.HasPrefix(name, "file:") {
+		return FileAirspaceSource{Path: strings.TrimPrefix(name, "file:"), Retime: true}
+	} else if name == "consensus" {
+		return NewConsensusAirspaceSource()
+	} else if strings.HasPrefix(name, "consensus:") {
+		return NewConsensusAirspaceSource(strings.Split(strings.TrimPrefix(name, "consensus:"), ",")...)
+	}
+
+	switch name {
+	case "fdb": return SkypiAirspaceSource{Src:"fdb", Host:config.Get("airspace.host")}
+	case "aex": return SkypiAirspaceSource{Src:"aex", Host:config.Get("airspace.host")}
+	case "fr24": return Fr24AirspaceSource{}
+	default: return Fr24AirspaceSource{}
+	}
+}
+
+// NewAirspaceSourceInDir is NewAirspaceSource for names that come from outside (e.g. a URL
+// param): file sources are taken as relative to dir, and may not escape it. If dir is blank,
+// file sources are refused. Unknown names, and consensus sources nested inside a consensus
+// list, are refused too; every name in the list has to be a live source or a file.
+func NewAirspaceSourceInDir(name, dir string) (AirspaceSource, error) {
+	prefix,names := "", []string{name}
+	if name == "consensus" {
+		return NewAirspaceSource(name), nil
+	} else if strings.HasPrefix(name, "consensus:") {
+		prefix,names = "consensus:", strings.Split(strings.TrimPrefix(name, "consensus:"), ",")
+	}
+
+	for i,n := range names {
+		if isLiveAirspaceSource(n) {
+			continue
+		} else if strings.HasPrefix(n, "consensus") {
+			return nil, fmt.Errorf("NewAirspaceSourceInDir: '%s' can't be nested in '%s'", n, name)
+		} else if !strings.HasPrefix(n, "file:") {
+			return nil, fmt.Errorf("NewAirspaceSourceInDir: unknown source '%s'", n)
+		} else if dir == "" {
+			return nil, fmt.Errorf("NewAirspaceSourceInDir: file sources not allowed")
+		}
+		path := filepath.Join(dir, strings.TrimPrefix(n, "file:"))
+		if rel,err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return nil, fmt.Errorf("NewAirspaceSourceInDir: '%s' is outside %s", n, dir)
+		}
+		names[i] = "file:" + path
+	}
+
+	return NewAirspaceSource(prefix + strings.Join(names, ",")), nil
+}
+
+func isLiveAirspaceSource(name string) bool {
+	for _,n := range AirspaceSourceNames {
+		if n == name { return true }
+	}
+	return false
+}
+
+// DefaultAirspaceSource is the deployment's primary source, as per the "airspace.source"
+// config value.
+func DefaultAirspaceSource() AirspaceSource {
+	return NewAirspaceSource(config.Get("airspace.source"))
+}
+
+// }}}
+
+// {{{ Fr24AirspaceSource
+
+type Fr24AirspaceSource struct{}
+func (s Fr24AirspaceSource)String() string { return "fr24" }
+func (s Fr24AirspaceSource)FetchAirspace(box geo.LatlongBox) (*airspace.Airspace, error) {
+	return fr24.GRPCFetchAirspace(box)
+}
+
+// }}}
+// {{{ SkypiAirspaceSource
+
+// SkypiAirspaceSource fetches from a skypi/flightdb frontend (fdb.serfr1.org by default),
+// which can serve up its own ADSB airspace ("fdb") or a proxied one ("aex", AdsbExchange).
+type SkypiAirspaceSource struct {
+	Src    string // "fdb", "aex"
+	Host   string // defaults to fdb.serfr1.org
+	Client *http.Client
+}
+func (s SkypiAirspaceSource)String() string { return s.Src }
+func (s SkypiAirspaceSource)FetchAirspace(box geo.LatlongBox) (*airspace.Airspace, error) {
+	client := s.Client
+	if client == nil { client = &http.Client{} }
+	return airspace.Fetch(client, s.Host, s.Src, box)
+}
+
+// }}}
+// {{{ FileAirspaceSource
+
+// FileAirspaceSource replays an airspace that was saved as JSON (e.g. the output of
+// http://fdb.serfr1.org/?json=1). If Retime is set, all the timestamps are shifted so that
+// the freshest aircraft was seen just now; otherwise the aircraft will be too old to survive
+// FilterAircraft.
+type FileAirspaceSource struct {
+	Path   string
+	Retime bool
+}
+func (s FileAirspaceSource)String() string { return "file:"+s.Path }
+func (s FileAirspaceSource)FetchAirspace(box geo.LatlongBox) (*airspace.Airspace, error) {
+	f,err := os.Open(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("FileAirspaceSource: %v", err)
+	}
+	defer f.Close()
+
+	as := airspace.Airspace{}
+	if err := json.NewDecoder(f).Decode(&as); err != nil {
+		return nil, fmt.Errorf("FileAirspaceSource '%s': %v", s.Path, err)
+	}
+
+	if s.Retime {
+		RetimeAirspace(&as, time.Now())
+	}
+
+	return &as, nil
+}
+
+// RetimeAirspace shifts all the message timestamps in the airspace, so that the freshest one
+// is at t; the relative ages of the aircraft are preserved.
+func RetimeAirspace(as *airspace.Airspace, t time.Time) {
+	newest := time.Time{}
+	for _,ad := range as.Aircraft {
+		if ad.Msg != nil && ad.Msg.GeneratedTimestampUTC.After(newest) {
+			newest = ad.Msg.GeneratedTimestampUTC
+		}
+	}
+	if newest.IsZero() { return }
+
+	*as = ShiftAirspace(*as, t.Sub(newest))
+}
+
+// ShiftAirspace returns a copy of the airspace, with all the message timestamps moved by d.
+func ShiftAirspace(in airspace.Airspace, d time.Duration) airspace.Airspace {
+	out := in
+	out.Aircraft = map[adsb.IcaoId]airspace.AircraftData{}
+	for k,ad := range in.Aircraft {
+		if ad.Msg != nil {
+			msg := *ad.Msg // Don't mutate messages we might be sharing with someone else
+			msg.GeneratedTimestampUTC = msg.GeneratedTimestampUTC.Add(d)
+			ad.Msg = &msg
+		}
+		out.Aircraft[k] = ad
+	}
+	return out
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
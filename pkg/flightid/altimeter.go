@@ -0,0 +1,149 @@
+package flightid
+
+// ADS-B altitudes are pressure altitudes; heights above the 1013.25hPa pressure level, not above
+// sea level. On a high pressure day aircraft are higher than they say, and on a low pressure day
+// lower; about 30ft per hPa, so a 15hPa swing moves everything by ~450ft. An AltimeterSource
+// provides the local altimeter setting (QNH), which CorrectAltitudes uses to turn pressure
+// altitudes into (approximately) geometric heights. It ignores temperature, which matters less.
+
+import(
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/pi/airspace"
+
+	"github.com/skypies/complaints/pkg/config"
+)
+
+const(
+	KStandardPressureHPa = 1013.25
+	KFeetPerHPa = 1000.0 / 33.8639 // The pilot's rule of thumb: 1000ft per inch of mercury
+	KMetarMaxAge = 30 * time.Minute
+)
+
+// AltimeterSource is a role for things that know the local altimeter setting.
+type AltimeterSource interface {
+	String() string
+	QNH(client *http.Client) (float64, error) // In hPa; client is for any fetching (nil for a default)
+}
+
+// {{{ NewAltimeterSource, DefaultAltimeterSource
+
+// NewAltimeterSource parses specs like "metar:KSFO", or "1013.2" (a fixed setting, in hPa).
+func NewAltimeterSource(spec string) (AltimeterSource, error) {
+	if strings.HasPrefix(spec, "metar:") {
+		return NewMetarAltimeter(strings.TrimPrefix(spec, "metar:")), nil
+	} else if hpa,err := strconv.ParseFloat(spec, 64); err == nil {
+		return FixedAltimeter(hpa), nil
+	}
+	return nil, fmt.Errorf("NewAltimeterSource: bad spec %q", spec)
+}
+
+// DefaultAltimeterSource is from config "airspace.qnh"; nil (no correction) if that isn't set,
+// or if it is bad (which is also an error).
+func DefaultAltimeterSource() (AltimeterSource, error) {
+	spec := config.Get("airspace.qnh")
+	if spec == "" { return nil, nil }
+	return NewAltimeterSource(spec)
+}
+
+// }}}
+// {{{ FixedAltimeter
+
+type FixedAltimeter float64
+
+func (a FixedAltimeter)String() string { return fmt.Sprintf("%.1fhPa", float64(a)) }
+func (a FixedAltimeter)QNH(client *http.Client) (float64, error) { return float64(a), nil }
+
+// }}}
+// {{{ MetarAltimeter
+
+// MetarAltimeter takes the altimeter setting from the latest METAR for an airport, as served by
+// aviationweather.gov. It caches the value for KMetarMaxAge.
+type MetarAltimeter struct {
+	Station string
+	URL     string // Defaults to aviationweather.gov's JSON API
+
+	mu      sync.Mutex
+	qnh     float64
+	fetched time.Time
+}
+
+func NewMetarAltimeter(station string) *MetarAltimeter {
+	return &MetarAltimeter{Station: strings.ToUpper(station)}
+}
+
+func (a *MetarAltimeter)String() string { return "metar:" + a.Station }
+
+func (a *MetarAltimeter)QNH(client *http.Client) (float64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.fetched.IsZero() && time.Since(a.fetched) < KMetarMaxAge {
+		return a.qnh, nil
+	}
+
+	if client == nil { client = &http.Client{Timeout: 5 * time.Second} }
+	url := a.URL
+	if url == "" { url = "https://aviationweather.gov/api/data/metar" }
+
+	resp,err := client.Get(fmt.Sprintf("%s?ids=%s&format=json", url, a.Station))
+	if err != nil {
+		return 0, fmt.Errorf("MetarAltimeter: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("MetarAltimeter: bad status: %v", resp.Status)
+	}
+
+	metars := []struct{
+		Altim float64 `json:"altim"` // hPa
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&metars); err != nil {
+		return 0, fmt.Errorf("MetarAltimeter: %v", err)
+	} else if len(metars) == 0 || metars[0].Altim == 0 {
+		return 0, fmt.Errorf("MetarAltimeter: no altimeter setting for %s", a.Station)
+	}
+
+	a.qnh, a.fetched = metars[0].Altim, time.Now()
+	return a.qnh, nil
+}
+
+// }}}
+// {{{ CorrectAltitudes
+
+// PressureToGeometricFeet corrects a pressure altitude for the local altimeter setting.
+func PressureToGeometricFeet(pressureAltitude, qnh float64) float64 {
+	return pressureAltitude + (qnh - KStandardPressureHPa) * KFeetPerHPa
+}
+
+// CorrectAltitudes returns a copy of the airspace with all the altitudes corrected from pressure
+// altitudes to geometric heights (see ShiftAirspace).
+func CorrectAltitudes(in airspace.Airspace, qnh float64) airspace.Airspace {
+	out := in
+	out.Aircraft = map[adsb.IcaoId]airspace.AircraftData{}
+	for k,ad := range in.Aircraft {
+		if ad.Msg != nil {
+			msg := *ad.Msg // Don't mutate messages we might be sharing with someone else
+			msg.Altitude = int64(PressureToGeometricFeet(float64(msg.Altitude), qnh))
+			ad.Msg = &msg
+		}
+		out.Aircraft[k] = ad
+	}
+	return out
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
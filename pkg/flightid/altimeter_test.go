@@ -0,0 +1,95 @@
+package flightid
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/pi/airspace"
+
+	"github.com/skypies/complaints/pkg/config"
+)
+
+// {{{ TestCorrectAltitudes
+
+func TestCorrectAltitudes(t *testing.T) {
+	if alt := PressureToGeometricFeet(3000, KStandardPressureHPa); alt != 3000 {
+		t.Errorf("standard pressure should be a no-op, got %.0f", alt)
+	}
+	// About 1000ft per inch of mercury; 30.92inHg is 1047.1hPa
+	if alt := PressureToGeometricFeet(3000, 1047.1); math.Abs(alt - 4000) > 5 {
+		t.Errorf("expected ~4000ft, got %.0f", alt)
+	}
+
+	msg := adsb.CompositeMsg{Msg:adsb.Msg{Icao24:"A00001", Altitude:3000}}
+	in := airspace.NewAirspace()
+	in.Aircraft[adsb.IcaoId("A00001")] = airspace.AircraftData{Msg:&msg}
+
+	out := CorrectAltitudes(in, 1003.25)
+	if alt := out.Aircraft["A00001"].Msg.Altitude; alt != 2704 && alt != 2705 {
+		t.Errorf("expected ~2705ft, got %d", alt)
+	}
+	if msg.Altitude != 3000 {
+		t.Errorf("the original message was modified")
+	}
+}
+
+// }}}
+// {{{ TestAltimeterSources
+
+func TestAltimeterSources(t *testing.T) {
+	n := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		if r.FormValue("ids") != "KSFO" {
+			http.Error(w, "bad station", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `[{"icaoId":"KSFO","altim":1019.5}]`)
+	}))
+	defer ts.Close()
+
+	src,err := NewAltimeterSource("metar:ksfo")
+	if err != nil { t.Fatal(err) }
+	metar := src.(*MetarAltimeter)
+	metar.URL = ts.URL
+
+	for i:=0; i<2; i++ {
+		if qnh,err := metar.QNH(ts.Client()); err != nil {
+			t.Fatal(err)
+		} else if qnh != 1019.5 {
+			t.Errorf("expected 1019.5, got %.1f", qnh)
+		}
+	}
+	if n != 1 {
+		t.Errorf("expected the METAR to be cached; fetched %d times", n)
+	}
+
+	if src,err := NewAltimeterSource("1009.8"); err != nil {
+		t.Error(err)
+	} else if qnh,_ := src.QNH(nil); qnh != 1009.8 {
+		t.Errorf("fixed: %.1f", qnh)
+	}
+	if _,err := NewAltimeterSource("bogus"); err == nil {
+		t.Errorf("expected an error for a bogus spec")
+	}
+
+	config.Set("airspace.qnh", "bogus")
+	defer config.Set("airspace.qnh", "")
+	if src,err := DefaultAltimeterSource(); err == nil || src != nil {
+		t.Errorf("expected an error for a bogus config, got %v", src)
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,209 @@
+package flightid
+
+// Consensus airspaces merge the aircraft from several sources; each source has gaps (fr24 has
+// schedules but no ModeS for MLAT traffic, our own receivers have fresh positions but limited
+// coverage, etc), so the fused view is better than any one of them.
+
+import(
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+	"github.com/skypies/pi/airspace"
+)
+
+// {{{ ConsensusAirspaceSource
+
+// ConsensusAirspaceSource fetches from all its sources, and fuses the results.
+type ConsensusAirspaceSource struct {
+	Sources []AirspaceSource
+}
+
+// NewConsensusAirspaceSource takes source names (as per NewAirspaceSource); no names means all
+// of the live sources.
+func NewConsensusAirspaceSource(names ...string) ConsensusAirspaceSource {
+	if len(names) == 0 { names = AirspaceSourceNames }
+	c := ConsensusAirspaceSource{}
+	for _,name := range names {
+		c.Sources = append(c.Sources, NewAirspaceSource(name))
+	}
+	return c
+}
+
+func (c ConsensusAirspaceSource)String() string {
+	names := []string{}
+	for _,src := range c.Sources { names = append(names, src.String()) }
+	return "consensus:" + strings.Join(names, ",")
+}
+
+func (c ConsensusAirspaceSource)FetchAirspace(box geo.LatlongBox) (*airspace.Airspace, error) {
+	cons,err := c.FetchConsensus(box)
+	if err != nil { return nil, err }
+	return &cons.Fused, nil
+}
+
+// FetchConsensus fetches from all the sources in parallel. It only fails if every source failed.
+func (c ConsensusAirspaceSource)FetchConsensus(box geo.LatlongBox) (*Consensus, error) {
+	cons := Consensus{
+		Order: []string{},
+		PerSource: map[string]*airspace.Airspace{},
+		Errors: map[string]error{},
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _,src := range c.Sources {
+		cons.Order = append(cons.Order, src.String())
+		wg.Add(1)
+		go func(src AirspaceSource) {
+			defer wg.Done()
+			as,err := src.FetchAirspace(box)
+			if err == nil && as == nil { err = fmt.Errorf("no airspace returned") }
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				cons.Errors[src.String()] = err
+			} else {
+				cons.PerSource[src.String()] = as
+			}
+		}(src)
+	}
+	wg.Wait()
+
+	if len(cons.PerSource) == 0 {
+		return nil, fmt.Errorf("%s: all sources failed: %v", c, cons.Errors)
+	}
+
+	cons.Fused = cons.fuse()
+	return &cons, nil
+}
+
+// }}}
+// {{{ Consensus
+
+// Consensus holds what each source returned, and the fused result.
+type Consensus struct {
+	Order     []string // The source names, in priority order
+	PerSource map[string]*airspace.Airspace
+	Errors    map[string]error
+	Fused     airspace.Airspace
+}
+
+// NormalizeIcaoId strips the prefixes some sources add to ModeS ids, so that the same aircraft
+// has the same id regardless of where it came from.
+func NormalizeIcaoId(id adsb.IcaoId) adsb.IcaoId {
+	str := strings.TrimPrefix(string(id), "EE") // fr24 airspaces use this prefix
+	str = strings.TrimPrefix(str, "FF")         // fa airspaces use this prefix
+	return adsb.IcaoId(str)
+}
+
+// FuseAirspaces merges the airspaces by ICAO id. For each aircraft, the freshest position is
+// used; schedule and airframe data are taken from the first source (in order) that has them.
+func FuseAirspaces(order []string, airspaces map[string]*airspace.Airspace) airspace.Airspace {
+	fused := airspace.NewAirspace()
+
+	for _,name := range order {
+		as := airspaces[name]
+		if as == nil { continue }
+
+		for id,ad := range as.Aircraft {
+			if ad.Msg == nil { continue }
+			key := NormalizeIcaoId(id)
+			if ad.Source == "" { ad.Source = name }
+
+			prev,exists := fused.Aircraft[key]
+			if !exists {
+				fused.Aircraft[key] = ad
+				continue
+			}
+
+			if ad.Msg.GeneratedTimestampUTC.After(prev.Msg.GeneratedTimestampUTC) {
+				prev.Msg = ad.Msg
+				prev.Source = ad.Source
+				prev.NumMessagesSeen = ad.NumMessagesSeen
+			}
+			if prev.Schedule.IataFlight() == "" && ad.Schedule.IataFlight() != "" {
+				prev.Schedule = ad.Schedule
+			}
+			if prev.Airframe.Registration == "" && prev.Airframe.EquipmentType == "" {
+				prev.Airframe = ad.Airframe
+			}
+			fused.Aircraft[key] = prev
+		}
+	}
+
+	return fused
+}
+
+func (cons Consensus)fuse() airspace.Airspace {
+	return FuseAirspaces(cons.Order, cons.PerSource)
+}
+
+// }}}
+// {{{ cons.Record
+
+// ConsensusRecord notes, for a single complaint, which sources contributed to the fused airspace,
+// and whether identifying from each of them on its own would have picked the same aircraft.
+type ConsensusRecord struct {
+	Sources   []string     `datastore:",noindex"` // The sources that contributed
+	Failed    []string     `datastore:",noindex"` // The sources that failed to fetch
+	Picks     []SourcePick `datastore:",noindex"`
+	FusedPick string       `datastore:",noindex"` // What was picked from the fused airspace
+	Agreed    bool         // All the sources (and the fused airspace) made the same pick
+}
+
+type SourcePick struct {
+	Source string `datastore:",noindex"`
+	Pick   string `datastore:",noindex"` // As per PickIdent
+}
+
+func (r ConsensusRecord)IsZero() bool { return len(r.Sources) == 0 && len(r.Failed) == 0 }
+
+func (r ConsensusRecord)String() string {
+	if r.IsZero() { return "" }
+	picks := []string{}
+	for _,sp := range r.Picks {
+		pick := sp.Pick
+		if pick == "" { pick = "-" }
+		picks = append(picks, fmt.Sprintf("%s=%s", sp.Source, pick))
+	}
+	verdict := "DIFFERS"
+	if r.Agreed { verdict = "agrees" }
+	str := fmt.Sprintf("consensus %s: fused=%s [%s]", verdict, r.FusedPick, strings.Join(picks, ", "))
+	if len(r.Failed) > 0 {
+		str += fmt.Sprintf(" (failed: %s)", strings.Join(r.Failed, ","))
+	}
+	return str
+}
+
+// Record runs the selector against each of the sources individually, and compares those picks
+// with the pick from the fused airspace.
+func (cons Consensus)Record(pos geo.Latlong, elev float64, algo Selector, fusedPick *Aircraft) ConsensusRecord {
+	r := ConsensusRecord{FusedPick: PickIdent(fusedPick), Agreed: true}
+
+	for _,name := range cons.Order {
+		if err,failed := cons.Errors[name]; failed {
+			r.Failed = append(r.Failed, fmt.Sprintf("%s(%v)", name, err))
+			continue
+		}
+		r.Sources = append(r.Sources, name)
+		res := IdentifyOverhead(cons.PerSource[name], pos, elev, algo)
+		sp := SourcePick{Source:name, Pick:PickIdent(res.Flight)}
+		r.Picks = append(r.Picks, sp)
+		if sp.Pick != r.FusedPick { r.Agreed = false }
+	}
+
+	return r
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
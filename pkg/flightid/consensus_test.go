@@ -0,0 +1,148 @@
+package flightid
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/skypies/adsb"
+	fdb "github.com/skypies/flightdb"
+	"github.com/skypies/geo"
+	"github.com/skypies/pi/airspace"
+)
+
+// {{{ fakeAirspaceSource
+
+type fakeAirspaceSource struct {
+	name string
+	as   *airspace.Airspace
+}
+func (s fakeAirspaceSource)String() string { return s.name }
+func (s fakeAirspaceSource)FetchAirspace(box geo.LatlongBox) (*airspace.Airspace, error) {
+	if s.as == nil { return nil, fmt.Errorf("%s is down", s.name) }
+	return s.as, nil
+}
+
+func makeAircraftData(id string, t time.Time, lat float64) airspace.AircraftData {
+	msg := adsb.CompositeMsg{Msg: adsb.Msg{
+		Icao24: adsb.IcaoId(id),
+		GeneratedTimestampUTC: t,
+		Position: geo.Latlong{Lat:lat, Long:-121.99},
+	}}
+	return airspace.AircraftData{Msg: &msg}
+}
+
+// }}}
+
+// {{{ TestConsensus
+
+func TestConsensus(t *testing.T) {
+	tm := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// fr24 has the schedule, but an older position (and its odd prefix on the id)
+	as1 := airspace.NewAirspace()
+	ad1 := makeAircraftData("EEA00001", tm, 37.0)
+	ad1.Schedule = fdb.Schedule{IATA:"UA", Number:123}
+	as1.Aircraft["EEA00001"] = ad1
+
+	// fdb has a fresher position, and the airframe; and an aircraft that fr24 doesn't
+	as2 := airspace.NewAirspace()
+	ad2 := makeAircraftData("A00001", tm.Add(5*time.Second), 37.1)
+	ad2.Airframe = fdb.Airframe{Registration:"N12345", EquipmentType:"B738"}
+	as2.Aircraft["A00001"] = ad2
+	as2.Aircraft["A00002"] = makeAircraftData("A00002", tm, 37.2)
+
+	src := ConsensusAirspaceSource{Sources: []AirspaceSource{
+		fakeAirspaceSource{"fr24", &as1},
+		fakeAirspaceSource{"fdb", &as2},
+		fakeAirspaceSource{"aex", nil},
+	}}
+
+	cons,err := src.FetchConsensus(geo.Latlong{}.Box(60,60))
+	if err != nil { t.Fatal(err) }
+
+	if n := len(cons.Fused.Aircraft); n != 2 {
+		t.Fatalf("expected 2 fused aircraft, found %d", n)
+	}
+	fused := cons.Fused.Aircraft["A00001"]
+	if !fused.Msg.GeneratedTimestampUTC.Equal(tm.Add(5*time.Second)) || fused.Source != "fdb" {
+		t.Errorf("fused aircraft didn't get freshest position: %s from %s", fused.Msg, fused.Source)
+	}
+	if fused.Schedule.IataFlight() != "UA123" || fused.Airframe.Registration != "N12345" {
+		t.Errorf("fused aircraft didn't get schedule & airframe: %+v", fused)
+	}
+
+	down := ConsensusAirspaceSource{Sources: []AirspaceSource{fakeAirspaceSource{"aex", nil}}}
+	if _,err := down.FetchAirspace(geo.Latlong{}.Box(60,60)); err == nil {
+		t.Errorf("expected an error when all sources fail")
+	}
+}
+
+// }}}
+// {{{ TestConsensusRecord
+
+func TestConsensusRecord(t *testing.T) {
+	tm := time.Now()
+	pos := geo.Latlong{Lat:37.0, Long:-121.99}
+	algo := NewSelector("conservative")
+
+	flight := func(id string, lat float64, number int64) airspace.AircraftData {
+		ad := makeAircraftData(id, tm, lat)
+		ad.Msg.Altitude = 5000
+		ad.Schedule = fdb.Schedule{IATA:"UA", Number:number}
+		return ad
+	}
+
+	// fr24 only sees UA123, a little way off
+	as1 := airspace.NewAirspace()
+	as1.Aircraft["EEA00001"] = flight("EEA00001", 37.05, 123)
+
+	// fdb sees it too; and UA456, right overhead
+	as2 := airspace.NewAirspace()
+	as2.Aircraft["A00001"] = flight("A00001", 37.05, 123)
+
+	tests := []struct{
+		extra    bool   // fdb also sees UA456
+		fused    string
+		agreed   bool
+	}{
+		{false, "UA123", true},
+		{true,  "UA456", false},
+	}
+
+	for _,test := range tests {
+		if test.extra {
+			as2.Aircraft["A00002"] = flight("A00002", 37.0, 456)
+		}
+		src := ConsensusAirspaceSource{Sources: []AirspaceSource{
+			fakeAirspaceSource{"fr24", &as1},
+			fakeAirspaceSource{"fdb", &as2},
+			fakeAirspaceSource{"aex", nil},
+		}}
+		cons,err := src.FetchConsensus(pos.Box(60,60))
+		if err != nil { t.Fatal(err) }
+
+		res := IdentifyOverhead(&cons.Fused, pos, 0, algo)
+		if PickIdent(res.Flight) != test.fused {
+			t.Fatalf("fused airspace picked %v, expected %s\n%s", res.Flight, test.fused, res.Debug)
+		}
+
+		r := cons.Record(pos, 0, algo, res.Flight)
+		if len(r.Sources) != 2 || len(r.Failed) != 1 || len(r.Picks) != 2 {
+			t.Errorf("bad consensus record: %s", r)
+		}
+		if r.FusedPick != test.fused || r.Agreed != test.agreed {
+			t.Errorf("expected fused=%s, agreed=%v; got %s", test.fused, test.agreed, r)
+		}
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,111 @@
+package flightid
+
+// Scoring selectors against ground truth. The only ground truth we have is users correcting
+// the flightnumber on their complaints; each such correction becomes a Case.
+
+import(
+	"fmt"
+	"strings"
+)
+
+// Case is one identification where we know the right answer.
+type Case struct {
+	Truth    string    // What the user said it was (BestIdent); "" means no flight
+	Recorded string    // What was automatically picked at the time (BestIdent)
+	Snapshot *Snapshot // The airspace it was picked from, if we recorded it
+}
+
+// SelectorScore counts how one selector did over a set of cases.
+type SelectorScore struct {
+	Name      string
+	N         int // How many cases it was scored on
+	Picked    int // ... how many times it picked something
+	Correct   int // ... how many of those picks agreed with the truth
+	Abstained int // ... how many times it didn't pick anything
+	Positives int // How many cases had a flight in the truth
+}
+
+// Precision: of the picks made, the fraction that were right.
+func (s SelectorScore)Precision() float64 { return ratio(s.Correct, s.Picked) }
+
+// Recall: of the cases where there was a flight to find, the fraction that were found.
+func (s SelectorScore)Recall() float64 { return ratio(s.Correct, s.Positives) }
+
+// AbstentionRate: the fraction of cases where no pick was made.
+func (s SelectorScore)AbstentionRate() float64 { return ratio(s.Abstained, s.N) }
+
+func (s SelectorScore)String() string {
+	return fmt.Sprintf("%-14.14s n=%5d precision=%5.1f%% recall=%5.1f%% abstention=%5.1f%%",
+		s.Name, s.N, 100*s.Precision(), 100*s.Recall(), 100*s.AbstentionRate())
+}
+
+func ratio(a,b int) float64 {
+	if b == 0 { return 0.0 }
+	return float64(a) / float64(b)
+}
+
+// samePick compares idents from BestIdent; users correct unscheduled aircraft by typing in the
+// registration (e.g. "N12345"), which BestIdent would have given as "r:N12345".
+func samePick(pick, truth string) bool {
+	return strings.EqualFold(bareIdent(pick), bareIdent(truth))
+}
+
+func bareIdent(ident string) string {
+	ident = strings.TrimSpace(ident)
+	for _,prefix := range []string{"r:", "icao:"} {
+		ident = strings.TrimPrefix(ident, prefix)
+	}
+	return ident
+}
+
+// CaseIdent is how picks are compared in Cases; the BestIdent, or "" for no pick.
+func CaseIdent(a *Aircraft) string {
+	if a == nil { return "" }
+	return a.BestIdent()
+}
+
+func (s *SelectorScore)add(pick, truth string) {
+	s.N++
+	if truth != "" { s.Positives++ }
+	if pick == "" {
+		s.Abstained++
+		return
+	}
+	s.Picked++
+	if samePick(pick, truth) { s.Correct++ }
+}
+
+// {{{ EvaluateSelectors
+
+// EvaluateSelectors scores the recorded picks (over all cases), and then each named selector
+// (replayed over the cases that have snapshots). The recorded score is first in the list.
+func EvaluateSelectors(cases []Case, selectorNames []string) []SelectorScore {
+	recorded := SelectorScore{Name: "(recorded)"}
+	scores := make([]SelectorScore, len(selectorNames))
+	for i,name := range selectorNames {
+		scores[i].Name = name
+	}
+
+	for _,c := range cases {
+		truth := strings.TrimSpace(c.Truth)
+		recorded.add(c.Recorded, truth)
+
+		if c.Snapshot == nil { continue }
+		for i,name := range selectorNames {
+			oh,_ := Replay(*c.Snapshot, NewSelectorWithParams(name, c.Snapshot.Params))
+			scores[i].add(CaseIdent(oh), truth)
+		}
+	}
+
+	return append([]SelectorScore{recorded}, scores...)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -9,34 +9,37 @@ import(
 	"time"
 
 	fdb "github.com/skypies/flightdb"
-	"github.com/skypies/flightdb/fr24"
 	"github.com/skypies/geo"
 	"github.com/skypies/pi/airspace"
+
+	"github.com/skypies/complaints/pkg/config"
 )
 
 // {{{ AirspaceHandler
 
 func AirspaceHandler(w http.ResponseWriter, r *http.Request) {
-	client := &http.Client{}
 	pos := geo.Latlong{37.060312,-121.990814}
 	str := ""
-	
-	var as *airspace.Airspace
-	var err error
-		
-	if r.FormValue("aex") != "" {
-		as,err = airspace.Fetch(client, "", "aex", pos.Box(60,60))
 
+	// &src=fdb, &src=file:as.json, etc; &aex=1 and &fdb=1 are older forms. Files can only be
+	// read from the airspace.snapshotdir directory.
+	src := DefaultAirspaceSource()
+	if r.FormValue("aex") != "" {
+		src = NewAirspaceSource("aex")
 	} else if r.FormValue("fdb") != "" {
-		as,err = airspace.Fetch(client, "", "fdb", pos.Box(60,60))
-
-	} else {
-		// TODO: also fetch from fdb ? It has the memcache entries for schedule/airframe backfill.
-		as,err = fr24.GRPCFetchAirspace(pos.Box(60,60))
+		src = NewAirspaceSource("fdb")
+	} else if r.FormValue("src") != "" {
+		var err error
+		if src,err = NewAirspaceSourceInDir(r.FormValue("src"), config.Get("airspace.snapshotdir")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
-	
+
+	// TODO: also fetch from fdb ? It has the memcache entries for schedule/airframe backfill.
+	as,err := src.FetchAirspace(pos.Box(60,60))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("FetchAirspace: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("FetchAirspace(%s): %v", src, err), http.StatusInternalServerError)
 		return
 	}
 
@@ -44,9 +47,9 @@ func AirspaceHandler(w http.ResponseWriter, r *http.Request) {
 	//names = append([]string{"conservative"}, names...)
 	for _,name := range names {
 		algo := NewSelector(name)
-		oh,debstr := IdentifyOverhead(as, pos, 0.0, algo)
+		res := IdentifyOverhead(as, pos, 0.0, algo)
 		str += fmt.Sprintf("--{ IdentifyOverhead, algo: %s }--\n -{ OH: %s }-\n\n%s\n",
-			algo, oh, debstr)
+			algo, res.Flight, res.Debug)
 	}
 	
 	w.Header().Set("Content-Type", "text/plain")
@@ -66,9 +69,7 @@ func AirspaceToLocalizedAircraft(as *airspace.Airspace, pos geo.Latlong, elev fl
 		tp := fdb.TrackpointFromADSB(ad.Msg)
 		altitudeDelta := tp.Altitude - elev
 
-		icaoid := string(ad.Msg.Icao24)
-		icaoid = strings.TrimPrefix(icaoid, "EE") // fr24 airspaces use this prefix
-		icaoid = strings.TrimPrefix(icaoid, "FF") // fa airspaces use this prefix
+		icaoid := string(NormalizeIcaoId(ad.Msg.Icao24))
 		
 		a := Aircraft{
 			Dist: pos.DistKM(tp.Latlong),
@@ -141,16 +142,17 @@ func AircraftToString(list []Aircraft) string {
 // }}}
 // {{{ FilterAircraft
 
-func FilterAircraft(in []Aircraft) []Aircraft {
+func FilterAircraft(in []Aircraft, p Params) []Aircraft {
 	out := []Aircraft{}
+	p = p.WithDefaults()
 
 	for _,a := range in {
 		age := time.Since(time.Unix(int64(a.Epoch),0))
 
-		if age > 30 * time.Second { continue } // Data too old to use
-		if a.FlightNumber == "" { continue }   // Drop unscheduled traffic; poor way of skipping GA
-		if a.Altitude > 28000 { continue }     // Too high to be the problem
-		if a.Altitude <   750 { continue }     // Too low to be the problem
+		if age > p.MaxAge() { continue }                   // Data too old to use
+		if a.FlightNumber == "" && !p.AllowUnscheduled { continue } // Poor way of skipping GA
+		if a.Altitude > p.MaxAltitudeFeet { continue }     // Too high to be the problem
+		if a.Altitude < p.MinAltitudeFeet { continue }     // Too low to be the problem
 
 		out = append(out, a)
 	}
@@ -193,27 +195,61 @@ func TimeSyncAircraft(in []Aircraft, pos geo.Latlong, elev float64, targetAge ti
 // }}}
 // {{{ IdentifyOverhead
 
-func IdentifyOverhead(as *airspace.Airspace, pos geo.Latlong, elev float64, algo Selector) (*Aircraft, string) {
-	if as == nil { return (*Aircraft)(nil), "** airspace was nil\n" }	
+// IdentifyOverhead runs the selector over the nearby aircraft. The Result has the pick (if any),
+// and also every candidate, scored and ranked; and a Trace of how it got there.
+func IdentifyOverhead(as *airspace.Airspace, pos geo.Latlong, elev float64, algo Selector) Result {
+	tr := IdentificationTrace{
+		Time: time.Now(),
+		Selector: SelectorName(algo),
+		SelectorDesc: algo.String(),
+		Params: algo.Parameters().WithDefaults(),
+	}
+
+	if as == nil {
+		tr.OutcomeCode, tr.Error = OutcomeNoAirspace, "airspace was nil"
+		return Result{Err:fmt.Errorf("airspace was nil"), Trace:tr, Debug:tr.String()}
+	}
 
 	nearby := AirspaceToLocalizedAircraft(as, pos, elev)
-	filtered := FilterAircraft(nearby)
+	filtered := FilterAircraft(nearby, algo.Parameters())
 
 	if true {
 		targetAge := 6 * time.Second
 		filtered = TimeSyncAircraft(filtered, pos, elev, targetAge)
 	}
 
-	ret, outcome := (*Aircraft)(nil), "nothing found in the sky"
+	res := Result{Outcome: "nothing found in the sky"}
+	for i,_ := range nearby { res.All = append(res.All, &nearby[i]) }
+	for i,_ := range filtered { res.Filtered = append(res.Filtered, &filtered[i]) }
+
 	if len(filtered) > 0 {
-		ret,outcome = algo.Identify(pos,elev,filtered)
+		res.Flight,res.Outcome = algo.Identify(pos,elev,filtered)
+		res.Candidates = ScoreAircraft(algo, pos, elev, filtered)
+		if explainer,ok := algo.(Explainer); ok {
+			tr.Explanation = explainer.Explain(pos, elev, filtered)
+		}
+	}
+	if res.Flight != nil {
+		for _,c := range res.Candidates {
+			if c.Id2 == res.Flight.Id2 { res.Confidence = c.Score }
+		}
+		tr.OutcomeCode = OutcomePicked
+		tr.Procedure = res.Flight.TagProcedure().String()
+		res.Flight.AddRegistration()
 	}
 
-	str := fmt.Sprintf("**** identification method: %s\n**** outcome: %s\n\n", algo, outcome)
-	str += fmt.Sprintf("** Processed [%d] **\n%s\n", len(filtered), AircraftToString(filtered))
-	str += fmt.Sprintf("** Raw [%d] **\n%s\n", len(nearby), AircraftToString(nearby))
+	tr.Outcome = res.Outcome
+	tr.Pick = PickIdent(res.Flight)
+	tr.Confidence = res.Confidence
+	tr.Raw = nearby
+	tr.Filtered = filtered
+	tr.Candidates = res.Candidates
+	tr.Finish()
 
-	return ret,str
+	res.Trace = tr
+	res.Debug = tr.String()
+
+	return res
 }
 
 // }}}
@@ -260,6 +296,8 @@ This is approximate; speed of sound will vary with atmosphere.
 A jet at 10,000', and 4KM lateral, is ~5KM away.
 A cessna at 2,000', and 4KM lateral, is <5KM away, and would be closest.
 
+This is now AlgoAcoustic (see acoustic.go); select it as "acoustic".
+
  */
 
 
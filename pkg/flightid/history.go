@@ -0,0 +1,298 @@
+package flightid
+
+import(
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/skypies/adsb"
+	fdb "github.com/skypies/flightdb"
+	"github.com/skypies/geo"
+	"github.com/skypies/pi/airspace"
+
+	"github.com/skypies/complaints/pkg/config"
+)
+
+// HistoricalAirspaceSource is a role for things that can reconstruct what was in the sky at some
+// point in the past; e.g. to re-identify complaints after the fact.
+type HistoricalAirspaceSource interface {
+	String() string
+	AirspaceAt(box geo.LatlongBox, t time.Time) (*airspace.Airspace, error)
+}
+
+// TrackSource is a role for stores of historical flight tracks, that past airspaces can be
+// rebuilt from (see TrackHistorySource).
+type TrackSource interface {
+	String() string
+
+	// FlightTracks returns the flights that were inside the box at some point between s and e,
+	// each with its track (which may extend beyond s and e).
+	FlightTracks(box geo.LatlongBox, s,e time.Time) ([]FlightTrack, error)
+}
+
+// FlightTrack is one flight's track, and what we know about the flight.
+type FlightTrack struct {
+	IcaoId   string
+	Callsign string
+	Schedule fdb.Schedule
+	Airframe fdb.Airframe
+	Track    Track
+}
+
+const KHistoryMaxGap = 60 * time.Second // Don't extrapolate tracks further than this
+
+// {{{ NewHistorySource, DefaultHistorySource
+
+// NewHistorySource parses names like "fdb" (tracks from flightdb, at the "airspace.host"
+// config value), "fdb:host", or "corpus:gcs:my-bucket", which rebuilds airspaces from the tracks
+// seen in a snapshot corpus (see NewSnapshotCorpus). The name "corpus" on its own uses the
+// "airspace.corpus" config value.
+func NewHistorySource(name string) (HistoricalAirspaceSource, error) {
+	tracks,err := NewTrackSource(name)
+	if err != nil {
+		return nil, fmt.Errorf("NewHistorySource: %v", err)
+	}
+	return TrackHistorySource{Tracks: tracks}, nil
+}
+
+// NewTrackSource parses the same names as NewHistorySource.
+func NewTrackSource(name string) (TrackSource, error) {
+	if name == "fdb" {
+		return FdbTrackSource{Host: config.Get("airspace.host")}, nil
+	} else if strings.HasPrefix(name, "fdb:") {
+		return FdbTrackSource{Host: strings.TrimPrefix(name, "fdb:")}, nil
+	}
+
+	if name == "corpus" {
+		name = "corpus:" + config.Get("airspace.corpus")
+	}
+	if strings.HasPrefix(name, "corpus:") {
+		corpus := NewSnapshotCorpus(strings.TrimPrefix(name, "corpus:"))
+		if corpus == nil {
+			return nil, fmt.Errorf("NewTrackSource: bad corpus in '%s'", name)
+		}
+		return NewSnapshotTrackSource(corpus), nil
+	}
+	return nil, fmt.Errorf("NewTrackSource: unknown source '%s'", name)
+}
+
+// DefaultHistorySource is configured by "airspace.history".
+func DefaultHistorySource() (HistoricalAirspaceSource, error) {
+	return NewHistorySource(config.Get("airspace.history"))
+}
+
+// }}}
+// {{{ TrackHistorySource
+
+// TrackHistorySource rebuilds past airspaces by interpolating each flight's track to the time
+// in question. Flights whose tracks end more than MaxGap before (or start after) that time are
+// left out.
+type TrackHistorySource struct {
+	Tracks TrackSource
+	MaxGap time.Duration // Defaults to KHistoryMaxGap
+}
+
+func (s TrackHistorySource)String() string { return "tracks:" + s.Tracks.String() }
+
+func (s TrackHistorySource)maxGap() time.Duration {
+	if s.MaxGap == 0 { return KHistoryMaxGap }
+	return s.MaxGap
+}
+
+func (s TrackHistorySource)AirspaceAt(box geo.LatlongBox, t time.Time) (*airspace.Airspace, error) {
+	gap := s.maxGap()
+	flights,err := s.Tracks.FlightTracks(box, t.Add(-1 * gap), t.Add(gap))
+	if err != nil {
+		return nil, fmt.Errorf("TrackHistorySource: %v", err)
+	}
+
+	return AirspaceFromTracks(flights, t, gap, s.String()), nil
+}
+
+// AirspaceFromTracks builds the airspace at time t, by interpolating each flight's track. Flights
+// whose tracks end more than gap before (or start after) t are left out.
+func AirspaceFromTracks(flights []FlightTrack, t time.Time, gap time.Duration, source string) *airspace.Airspace {
+	as := airspace.NewAirspace()
+	for _,f := range flights {
+		if len(f.Track) == 0 { continue }
+		if t.Sub(f.Track[len(f.Track)-1].TimestampUTC) > gap || f.Track[0].TimestampUTC.Sub(t) > gap {
+			continue
+		}
+
+		tp := f.Track.PositionAt(t)
+		msg := adsb.CompositeMsg{Msg: adsb.Msg{
+			Type: "MSG",
+			Icao24: adsb.IcaoId(f.IcaoId),
+			Callsign: f.Callsign,
+			GeneratedTimestampUTC: t,
+			Position: tp.Latlong,
+			Altitude: int64(tp.Altitude),
+			GroundSpeed: int64(tp.GroundSpeed),
+			Track: int64(tp.Heading),
+			VerticalRate: int64(tp.VerticalRate),
+		}}
+		as.Aircraft[msg.Icao24] = airspace.AircraftData{
+			Msg: &msg,
+			Airframe: f.Airframe,
+			Schedule: f.Schedule,
+			Source: source,
+		}
+	}
+	return &as
+}
+
+// TracksBefore returns the tracks of the flights in the box over the window before t, without
+// going via airspaces (see TracksFromHistory).
+func (s TrackHistorySource)TracksBefore(box geo.LatlongBox, t time.Time, window time.Duration) (TrackHistory, error) {
+	flights,err := s.Tracks.FlightTracks(box, t.Add(-1 * window), t)
+	if err != nil {
+		return nil, fmt.Errorf("TrackHistorySource: %v", err)
+	}
+	h := TrackHistory{}
+	for _,f := range flights {
+		h[f.IcaoId] = f.Track
+	}
+	return h, nil
+}
+
+// }}}
+// {{{ SnapshotTrackSource
+
+// SnapshotTrackSource pieces together tracks from the airspaces recorded in a snapshot corpus;
+// any aircraft seen in several snapshots (e.g. by different users' complaints) gets a track. Only
+// the snapshots from around the window asked for get read. The snapshots only cover the sky
+// near complaints, when they were made, so this is a fallback for when flightdb can't be used.
+type SnapshotTrackSource struct {
+	Corpus SnapshotCorpus
+}
+
+func NewSnapshotTrackSource(corpus SnapshotCorpus) *SnapshotTrackSource {
+	return &SnapshotTrackSource{Corpus: corpus}
+}
+
+func (s *SnapshotTrackSource)String() string { return "corpus:" + s.Corpus.String() }
+
+func (s *SnapshotTrackSource)FlightTracks(box geo.LatlongBox, st,en time.Time) ([]FlightTrack, error) {
+	// Snapshots hold messages from a little before they were taken
+	snaps,err := s.Corpus.SnapshotsBetween(context.Background(), st, en.Add(KHistoryMaxGap))
+	if err != nil {
+		return nil, fmt.Errorf("SnapshotTrackSource: %v", err)
+	}
+
+	flights := map[string]*FlightTrack{}
+	seen := map[string]map[time.Time]bool{} // Snapshots often overlap; don't repeat points
+	for _,snap := range snaps {
+		for _,ad := range snap.Airspace.Aircraft {
+			if ad.Msg == nil { continue }
+			id := string(NormalizeIcaoId(ad.Msg.Icao24))
+			f := flights[id]
+			if f == nil {
+				f = &FlightTrack{IcaoId: id}
+				flights[id] = f
+				seen[id] = map[time.Time]bool{}
+			}
+			if seen[id][ad.Msg.GeneratedTimestampUTC] { continue }
+			seen[id][ad.Msg.GeneratedTimestampUTC] = true
+
+			f.Track = append(f.Track, fdb.TrackpointFromADSB(ad.Msg))
+			if ad.Msg.Callsign != "" { f.Callsign = ad.Msg.Callsign }
+			if ad.Schedule.IataFlight() != "" { f.Schedule = ad.Schedule }
+			if ad.Airframe.Registration != "" || ad.Airframe.EquipmentType != "" {
+				f.Airframe = ad.Airframe
+			}
+		}
+	}
+
+	ret := []FlightTrack{}
+	for _,f := range flights {
+		sort.Sort(f.Track)
+		if f.Track.Crosses(box, st, en) {
+			ret = append(ret, *f)
+		}
+	}
+	sort.Slice(ret, func(i,j int) bool { return ret[i].IcaoId < ret[j].IcaoId })
+	return ret, nil
+}
+
+// }}}
+// {{{ FdbTrackSource
+
+// FdbTrackSource fetches tracks from a skypi/flightdb frontend (fdb.serfr1.org by default), via
+// its /fdb/tracks endpoint; it covers every flight flightdb saw, not just those near complaints.
+// The query is for all the flights in a box over a timespan (rather than an idspec), and the
+// response is JSON, a list of FlightTracks.
+type FdbTrackSource struct {
+	Host   string // defaults to fdb.serfr1.org
+	Client *http.Client
+}
+
+func (s FdbTrackSource)String() string { return "fdb:" + s.host() }
+
+func (s FdbTrackSource)host() string {
+	if s.Host == "" { return "fdb.serfr1.org" }
+	return s.Host
+}
+
+func (s FdbTrackSource)FlightTracks(box geo.LatlongBox, st,en time.Time) ([]FlightTrack, error) {
+	client := s.Client
+	if client == nil { client = &http.Client{Timeout: 30 * time.Second} }
+
+	url := fmt.Sprintf("http://%s/fdb/tracks?json=1&s=%d&e=%d&%s", s.host(), st.Unix(), en.Unix(),
+		box.ToCGIArgs("box"))
+	resp,err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("FdbTrackSource: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("FdbTrackSource: bad status: %v", resp.Status)
+	}
+
+	flights := []FlightTrack{}
+	if err := json.NewDecoder(resp.Body).Decode(&flights); err != nil {
+		return nil, fmt.Errorf("FdbTrackSource: %v", err)
+	}
+
+	ret := []FlightTrack{}
+	for _,f := range flights {
+		f.IcaoId = strings.ToUpper(string(NormalizeIcaoId(adsb.IcaoId(f.IcaoId))))
+		sort.Sort(f.Track)
+		if f.Track.Crosses(box, st, en) { // Don't trust the server's filtering
+			ret = append(ret, f)
+		}
+	}
+	sort.Slice(ret, func(i,j int) bool { return ret[i].IcaoId < ret[j].IcaoId })
+	return ret, nil
+}
+
+// }}}
+// {{{ IdentifyAt
+
+// IdentifyAt runs IdentifyOverhead against an airspace from time t in the past. The airspace is
+// shifted forwards first, as if it had just been fetched, so that it survives the age checks.
+// Any tracks the selector has get shifted along with it.
+func IdentifyAt(as *airspace.Airspace, t time.Time, pos geo.Latlong, elev float64, algo Selector) Result {
+	if as == nil { return IdentifyOverhead(nil, pos, elev, algo) }
+	d := time.Since(t)
+	if traj,ok := algo.(AlgoTrajectory); ok && traj.Tracks != nil {
+		traj.Tracks = traj.Tracks.Shift(d)
+		algo = traj
+	}
+	shifted := ShiftAirspace(*as, d)
+	return IdentifyOverhead(&shifted, pos, elev, algo)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,163 @@
+package flightid
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	fdb "github.com/skypies/flightdb"
+	"github.com/skypies/geo"
+)
+
+// {{{ fakeTrackSource
+
+type fakeTrackSource []FlightTrack
+func (s fakeTrackSource)String() string { return "fake" }
+func (s fakeTrackSource)FlightTracks(box geo.LatlongBox, st,en time.Time) ([]FlightTrack, error) {
+	return s, nil
+}
+
+func makeTrack(t time.Time, step time.Duration, lats ...float64) Track {
+	track := Track{}
+	for i,lat := range lats {
+		track = append(track, fdb.Trackpoint{
+			TimestampUTC: t.Add(time.Duration(i) * step),
+			Latlong: geo.Latlong{Lat:lat, Long:-121.99},
+			Altitude: 5000,
+		})
+	}
+	return track
+}
+
+// }}}
+
+// {{{ TestTrackHistorySource
+
+func TestTrackHistorySource(t *testing.T) {
+	tm := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	pos := geo.Latlong{Lat:37.05, Long:-121.99}
+
+	hist := TrackHistorySource{Tracks: fakeTrackSource{
+		{IcaoId:"A00001", Schedule:fdb.Schedule{IATA:"UA", Number:1}, Track:makeTrack(tm, time.Minute, 37.0, 37.1)},
+		{IcaoId:"A00002", Track:makeTrack(tm.Add(-10*time.Minute), time.Minute, 37.0, 37.1)}, // Long gone
+	}}
+
+	as,err := hist.AirspaceAt(pos.Box(64,64), tm.Add(30*time.Second))
+	if err != nil { t.Fatal(err) }
+	if len(as.Aircraft) != 1 {
+		t.Fatalf("expected 1 aircraft, got %s", as)
+	}
+	ad := as.Aircraft["A00001"]
+	if math.Abs(ad.Msg.Position.Lat - 37.05) > 0.001 || ad.Msg.Altitude != 5000 || ad.Schedule.IataFlight() != "UA1" {
+		t.Errorf("bad rebuilt aircraft: %s, %+v", ad.Msg, ad.Schedule)
+	}
+
+	// The rebuilt airspace can be identified from
+	res := IdentifyAt(as, tm.Add(30*time.Second), pos, 0, NewSelector("conservative"))
+	if PickIdent(res.Flight) != "UA1" {
+		t.Errorf("expected UA1 to be picked, got %v\n%s", res.Flight, res.Debug)
+	}
+
+	// Tracks come straight from the source, not sampled from airspaces
+	tracks,err := TracksFromHistory(hist, pos.Box(64,64), tm.Add(time.Minute), time.Minute, time.Second)
+	if err != nil { t.Fatal(err) }
+	if len(tracks["A00001"]) != 2 {
+		t.Errorf("expected the whole track, got %v", tracks)
+	}
+}
+
+// }}}
+// {{{ TestSnapshotTrackSource
+
+func TestSnapshotTrackSource(t *testing.T) {
+	ctx := context.Background()
+	corpus := NewSnapshotCorpus("dir:" + t.TempDir())
+
+	tm := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _,snapTime := range []time.Time{tm, tm.Add(20*time.Second), tm.Add(20*time.Second), tm.Add(time.Hour)} {
+		if err := corpus.Record(ctx, makeSnapshot(snapTime, "A00001", "EEA00002")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src := NewSnapshotTrackSource(corpus)
+	box := geo.Latlong{Lat:37.06, Long:-121.99}.Box(10,10)
+	flights,err := src.FlightTracks(box, tm.Add(-time.Minute), tm.Add(time.Minute))
+	if err != nil { t.Fatal(err) }
+	if len(flights) != 2 || flights[0].IcaoId != "A00001" || flights[1].IcaoId != "A00002" {
+		t.Fatalf("bad flights: %v", flights)
+	}
+	if n := len(flights[0].Track); n != 2 {
+		t.Errorf("expected 2 trackpoints (repeats, and snapshots outside the window, dropped), got %d", n)
+	}
+
+	if flights,_ := src.FlightTracks(box, tm.Add(2*time.Hour), tm.Add(3*time.Hour)); len(flights) != 0 {
+		t.Errorf("expected no flights later on, got %v", flights)
+	}
+	if flights,_ := src.FlightTracks(geo.Latlong{}.Box(10,10), tm, tm.Add(time.Minute)); len(flights) != 0 {
+		t.Errorf("expected no flights elsewhere, got %v", flights)
+	}
+
+	if _,err := NewHistorySource("corpus:nonsense"); err == nil {
+		t.Errorf("expected an error for a bad corpus")
+	}
+}
+
+// }}}
+// {{{ TestFdbTrackSource
+
+func TestFdbTrackSource(t *testing.T) {
+	tm := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/fdb/tracks" {
+			http.NotFound(w, r)
+			return
+		}
+		query = r.URL.Query()
+		json.NewEncoder(w).Encode([]FlightTrack{
+			{IcaoId: "a00002", Callsign: "UAL1", Track: makeTrack(tm, 10*time.Second, 37.04, 37.06)},
+			{IcaoId: "A00001", Track: makeTrack(tm, 10*time.Second, 37.10, 37.06)},
+			{IcaoId: "A00003", Track: makeTrack(tm, 10*time.Second, 10.0, 10.1)}, // Elsewhere
+		})
+	}))
+	defer srv.Close()
+
+	src,err := NewTrackSource("fdb:" + strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil { t.Fatal(err) }
+	box := geo.Latlong{Lat:37.06, Long:-121.99}.Box(10,10)
+	flights,err := src.FlightTracks(box, tm, tm.Add(time.Minute))
+	if err != nil { t.Fatal(err) }
+
+	if query.Get("s") != fmt.Sprintf("%d", tm.Unix()) || query.Get("json") != "1" {
+		t.Errorf("bad query: %v", query)
+	}
+	if len(flights) != 2 || flights[0].IcaoId != "A00001" || flights[1].IcaoId != "A00002" {
+		t.Fatalf("bad flights: %v", flights)
+	} else if flights[1].Callsign != "UAL1" || len(flights[1].Track) != 2 {
+		t.Errorf("flight lost data: %+v", flights[1])
+	}
+
+	srv.Close()
+	if _,err := src.FlightTracks(box, tm, tm.Add(time.Minute)); err == nil {
+		t.Errorf("expected an error when flightdb is down")
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,181 @@
+package flightid
+
+import(
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Params are the knobs for identification; which aircraft get considered at all (see
+// FilterAircraft), and the thresholds the selectors use to pick between them. They can be set
+// per selector (NewSelectorWithParams), and users can store their own on their profile.
+//
+// Zero values mean "use the default", so an empty Params (e.g. from an old profile) behaves
+// just like the original hardcoded values. To really use a zero (e.g. no minimum altitude, for
+// small airfields), set it via Set, which records it in the Explicit mask.
+type Params struct {
+	MaxAgeSecs       float64 `datastore:",noindex"` // Ignore aircraft data older than this
+	MinAltitudeFeet  float64 `datastore:",noindex"` // Too low to be the problem
+	MaxAltitudeFeet  float64 `datastore:",noindex"` // Too high to be the problem
+	AllowUnscheduled bool    `datastore:",noindex"` // Consider aircraft without a flightnumber
+
+	MaxDistKM        float64 `datastore:",noindex"` // Don't pick anything further away than this
+	MinSeparationKM  float64 `datastore:",noindex"` // Give up if the 2nd closest is this close
+	ConeAngleDeg     float64 `datastore:",noindex"` // Half-angle of the cone, from vertical
+
+	SpeedOfSoundMPS  float64 `datastore:",noindex"` // For the acoustic selector
+	HandicapSecs     float64 `datastore:",noindex"` // ... user reaction time (negative also means none)
+
+	TrajectoryWindowSecs float64 `datastore:",noindex"` // For the trajectory selector
+
+	Explicit         int64   `datastore:",noindex"` // Bitmask of the ParamNames set via Set
+}
+
+// ParamNames are the numeric params, by field name. The order gives each one its bit in
+// Params.Explicit, so only ever append to it.
+var ParamNames = []string{
+	"MaxAgeSecs", "MinAltitudeFeet", "MaxAltitudeFeet", "MaxDistKM", "MinSeparationKM",
+	"ConeAngleDeg", "SpeedOfSoundMPS", "HandicapSecs", "TrajectoryWindowSecs",
+}
+
+// paramRanges are the sane values for each param, inclusive. Anything outside these (or not
+// a number at all) would either break the selectors or make them never pick anything.
+var paramRanges = map[string][2]float64{
+	"MaxAgeSecs":           {0, 600},
+	"MinAltitudeFeet":      {0, 60000},
+	"MaxAltitudeFeet":      {0, 60000},
+	"MaxDistKM":            {0, 100},
+	"MinSeparationKM":      {0, 100},
+	"ConeAngleDeg":         {0, 90},
+	"SpeedOfSoundMPS":      {250, 400},
+	"HandicapSecs":         {-60, 60},
+	"TrajectoryWindowSecs": {0, 3600},
+}
+
+func DefaultParams() Params {
+	return Params{
+		MaxAgeSecs: 30,
+		MinAltitudeFeet: 750,
+		MaxAltitudeFeet: 28000,
+		MaxDistKM: 12.0,
+		MinSeparationKM: 4.0,
+		ConeAngleDeg: 60,
+		SpeedOfSoundMPS: KSpeedOfSoundMPS,
+		HandicapSecs: KAcousticHandicap.Seconds(),
+		TrajectoryWindowSecs: KTrajectoryWindow.Seconds(),
+	}
+}
+
+// field returns a pointer to the named param, and its bit in the Explicit mask.
+func (p *Params)field(name string) (*float64, int64) {
+	ptrs := []*float64{
+		&p.MaxAgeSecs, &p.MinAltitudeFeet, &p.MaxAltitudeFeet, &p.MaxDistKM, &p.MinSeparationKM,
+		&p.ConeAngleDeg, &p.SpeedOfSoundMPS, &p.HandicapSecs, &p.TrajectoryWindowSecs,
+	}
+	for i,n := range ParamNames {
+		if n == name { return ptrs[i], 1<<uint(i) }
+	}
+	return nil, 0
+}
+
+// checkRange says why v is no good for the named param, or returns nil.
+func checkRange(name string, v float64) error {
+	r := paramRanges[name]
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("%s: %v is not a number", name, v)
+	} else if v < r[0] || v > r[1] {
+		return fmt.Errorf("%s: %v is outside [%v, %v]", name, v, r[0], r[1])
+	}
+	return nil
+}
+
+// Set sets the named param, such that WithDefaults will leave it alone even if it is zero.
+// Values outside the param's range are refused.
+func (p *Params)Set(name string, v float64) error {
+	ptr,bit := p.field(name)
+	if ptr == nil {
+		return fmt.Errorf("Params.Set: no param '%s'", name)
+	} else if err := checkRange(name, v); err != nil {
+		return fmt.Errorf("Params.Set: %v", err)
+	}
+	*ptr = v
+	p.Explicit |= bit
+	return nil
+}
+
+// IsSet says whether the named param has a value of its own, rather than the default.
+func (p Params)IsSet(name string) bool {
+	ptr,bit := p.field(name)
+	return ptr != nil && (*ptr != 0 || p.Explicit & bit != 0)
+}
+
+// WithDefaults fills in any unset values from DefaultParams.
+func (p Params)WithDefaults() Params {
+	def := DefaultParams()
+	for _,name := range ParamNames {
+		if !p.IsSet(name) {
+			ptr,_ := p.field(name)
+			defPtr,_ := def.field(name)
+			*ptr = *defPtr
+		}
+	}
+	return p
+}
+
+// Validate checks the values that have been set (however they were set), and that the
+// altitude band isn't upside down once the defaults are filled in.
+func (p Params)Validate() error {
+	for _,name := range ParamNames {
+		if !p.IsSet(name) { continue }
+		ptr,_ := p.field(name)
+		if err := checkRange(name, *ptr); err != nil {
+			return fmt.Errorf("Params.Validate: %v", err)
+		}
+	}
+	if full := p.WithDefaults(); full.MinAltitudeFeet > full.MaxAltitudeFeet {
+		return fmt.Errorf("Params.Validate: MinAltitudeFeet (%.0f) > MaxAltitudeFeet (%.0f)",
+			full.MinAltitudeFeet, full.MaxAltitudeFeet)
+	}
+	return nil
+}
+
+func (p Params)IsZero() bool { return p == Params{} }
+
+func (p Params)MaxAge() time.Duration {
+	return time.Duration(p.MaxAgeSecs * float64(time.Second))
+}
+
+func (p Params)Handicap() time.Duration {
+	if p.HandicapSecs < 0 { return 0 }
+	return time.Duration(p.HandicapSecs * float64(time.Second))
+}
+
+func (p Params)TrajectoryWindow() time.Duration {
+	return time.Duration(p.TrajectoryWindowSecs * float64(time.Second))
+}
+
+func (p Params)String() string {
+	strs := []string{
+		fmt.Sprintf("age<%.0fs", p.MaxAgeSecs),
+		fmt.Sprintf("alt=%.0f-%.0fft", p.MinAltitudeFeet, p.MaxAltitudeFeet),
+		fmt.Sprintf("dist<%.1fKM", p.MaxDistKM),
+		fmt.Sprintf("sep>%.1fKM", p.MinSeparationKM),
+		fmt.Sprintf("cone=%.0fdeg", p.ConeAngleDeg),
+		fmt.Sprintf("sound=%.0fm/s", p.SpeedOfSoundMPS),
+		fmt.Sprintf("handicap=%s", p.Handicap()),
+		fmt.Sprintf("window=%s", p.TrajectoryWindow()),
+	}
+	if p.AllowUnscheduled {
+		strs = append(strs, "unscheduled")
+	}
+	return "{" + strings.Join(strs, ",") + "}"
+}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
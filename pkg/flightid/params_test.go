@@ -0,0 +1,109 @@
+package flightid
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/skypies/geo"
+)
+
+// {{{ TestParams
+
+func TestParams(t *testing.T) {
+	if p := (Params{}).WithDefaults(); p != DefaultParams() {
+		t.Errorf("empty params didn't get defaults: %s", p)
+	}
+
+	p := Params{MinAltitudeFeet:200, AllowUnscheduled:true}.WithDefaults()
+	if p.MinAltitudeFeet != 200 || p.MaxAltitudeFeet != DefaultParams().MaxAltitudeFeet {
+		t.Errorf("WithDefaults clobbered a set value: %s", p)
+	}
+
+	// Explicit zeroes survive
+	p0 := Params{}
+	if err := p0.Set("MinAltitudeFeet", 0); err != nil {
+		t.Fatal(err)
+	}
+	if p0.Set("NoSuchParam", 1) == nil {
+		t.Errorf("Set accepted a bad name")
+	}
+	if p0 = p0.WithDefaults(); p0.MinAltitudeFeet != 0 || p0.MinSeparationKM != DefaultParams().MinSeparationKM {
+		t.Errorf("WithDefaults clobbered an explicit zero: %s", p0)
+	}
+	if !p0.IsSet("MinAltitudeFeet") || (Params{}).IsSet("MinAltitudeFeet") {
+		t.Errorf("IsSet is wrong")
+	}
+
+	// Junk values are refused, by Set and by Validate
+	for _,bad := range []struct{name string; v float64}{
+		{"MaxDistKM", math.NaN()}, {"MaxDistKM", math.Inf(1)}, {"MaxDistKM", -1},
+		{"ConeAngleDeg", 120}, {"SpeedOfSoundMPS", 0}, {"MaxAgeSecs", math.Inf(-1)},
+	} {
+		pp := Params{}
+		if pp.Set(bad.name, bad.v) == nil {
+			t.Errorf("Set accepted %s=%v", bad.name, bad.v)
+		}
+	}
+	if err := (Params{MaxDistKM:math.NaN()}).Validate(); err == nil {
+		t.Errorf("Validate accepted a NaN")
+	}
+	if err := (Params{MinAltitudeFeet:30000}).Validate(); err == nil {
+		t.Errorf("Validate accepted min altitude above the default max")
+	}
+	if err := p0.Validate(); err != nil {
+		t.Errorf("Validate refused good params: %v", err)
+	}
+	hc := Params{}
+	if err := hc.Set("HandicapSecs", -1); err != nil {
+		t.Errorf("negative handicap (no handicap) refused: %v", err)
+	}
+
+	now := float64(time.Now().Unix())
+	in := []Aircraft{
+		{FlightNumber:"UA1", Altitude:5000, Epoch:now},
+		{Registration:"N12345", Altitude:500, Epoch:now},       // Low GA
+		{FlightNumber:"UA2", Altitude:5000, Epoch:now - 60},     // Stale
+	}
+	if out := FilterAircraft(in, Params{}); len(out) != 1 {
+		t.Errorf("default filter kept %d, expected 1", len(out))
+	}
+	if out := FilterAircraft(in, p); len(out) != 2 {
+		t.Errorf("tuned filter kept %d, expected 2", len(out))
+	}
+
+	// Congestion thresholds
+	pos := geo.Latlong{Lat:37.060312, Long:-121.990814}
+	close := []Aircraft{{FlightNumber:"UA1", Dist3:3.0}, {FlightNumber:"UA2", Dist3:5.0}}
+	if oh,_ := NewSelector("conservative").Identify(pos, 0, close); oh != nil {
+		t.Errorf("default conservative should have given up, picked %s", oh)
+	}
+	sel := NewSelectorWithParams("conservative", Params{MinSeparationKM:1.0})
+	if oh,str := sel.Identify(pos, 0, close); oh == nil || oh.FlightNumber != "UA1" {
+		t.Errorf("tuned conservative should have picked UA1, got %v (%s)", oh, str)
+	}
+	if sel.Parameters().MaxDistKM != DefaultParams().MaxDistKM {
+		t.Errorf("selector params didn't get defaults: %s", sel.Parameters())
+	}
+
+	// A zero separation means just pick the closest
+	zero := Params{}
+	zero.Set("MinSeparationKM", 0)
+	tied := []Aircraft{{FlightNumber:"UA1", Dist3:3.0}, {FlightNumber:"UA2", Dist3:3.5}}
+	if oh,str := NewSelectorWithParams("conservative", zero).Identify(pos, 0, tied); oh == nil {
+		t.Errorf("zero-separation conservative should have picked UA1 (%s)", str)
+	}
+	if out := FilterAircraft([]Aircraft{{FlightNumber:"UA1", Altitude:0, Epoch:now}}, p0); len(out) != 1 {
+		t.Errorf("zero min altitude filtered out a ground-level aircraft")
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,259 @@
+package flightid
+
+// Procedure matching tags an identified flight with the arrival or departure procedure it was
+// flying when the complaint was made (e.g. SERFR2), and the last waypoint on that procedure that it
+// flew through. We only have a single position and track for the aircraft, so the match is
+// against a corridor around each leg of the procedure, rather than the whole flightpath.
+
+import(
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/skypies/geo"
+	"github.com/skypies/geo/sfo"
+)
+
+const(
+	KProcedureCorridorKM = 3.0         // How far off the leg we can be, and still be on the procedure
+	KProcedureMaxHeadingDelta = 30.0   // Track has to be this aligned with the leg
+	KProcedureAltitudeSlopFeet = 3000.0 // Slack on the published altitude restrictions
+	KProcedureLegSlop = 0.05           // Allow a little overshoot beyond each end of a leg
+)
+
+// {{{ KProcedures
+
+// KProcedures are the procedures we match against. The waypoints are populated from
+// sfo.KFixes (plus the airports themselves) at init time; any fix missing from there makes the
+// procedure unusable.
+var KProcedures = []geo.Procedure{
+	// http://flightaware.com/resources/airport/SFO/STAR/SERFR+TWO+(RNAV)/pdf
+	// (sfo.Serfr1 has a typo in NRRLI, so we keep our own copy)
+	{
+		Name: "SERFR2",
+		Airport: "SFO",
+		Waypoints: []geo.Waypoint{
+			{FixName:"SERFR"},
+			{FixName:"NRRLI", MinAltitude:20000, MaxAltitude:20000, MaxAirspeed:280},
+			{FixName:"WWAVS", MinAltitude:15000, MaxAltitude:19000, MaxAirspeed:280},
+			{FixName:"EPICK", MinAltitude:10000, MaxAltitude:15000, MaxAirspeed:280},
+			{FixName:"EDDYY", MinAltitude: 6000, MaxAltitude: 6000, MaxAirspeed:240},
+			{FixName:"SWELS", MinAltitude: 4700, MaxAltitude: 4700, MaxAirspeed:240},
+			{FixName:"MENLO", MinAltitude: 4000, MaxAltitude: 4000, MaxAirspeed:230},
+		},
+	},
+	{
+		Name: "BSR2",
+		Airport: "SFO",
+		Waypoints: []geo.Waypoint{
+			{FixName:"CARME"},
+			{FixName:"ANJEE"},
+			{FixName:"SKUNK"},
+			{FixName:"BOLDR"},
+			{FixName:"MENLO"},
+		},
+	},
+	{
+		Name: "SILCN3",
+		Airport: "SJC",
+		Waypoints: []geo.Waypoint{
+			{FixName:"VLLEY"},
+			{FixName:"GUUYY"},
+			{FixName:"SSEBB"},
+			{FixName:"GSTEE"},
+			{FixName:"KLIDE"},
+		},
+	},
+
+	// Departures. We only have the initial fixes of each SID, not the transitions (nor the
+	// version numbers, which change too often to be useful here).
+	{
+		Name: "SSTIK",
+		Airport: "SFO",
+		Departure: true,
+		Waypoints: []geo.Waypoint{
+			{FixName:"KSFO"},
+			{FixName:"SSTIK"},
+			{FixName:"PORTE"},
+		},
+	},
+	{
+		Name: "TRUKN",
+		Airport: "SFO",
+		Departure: true,
+		Waypoints: []geo.Waypoint{
+			{FixName:"KSFO"},
+			{FixName:"TRUKN"},
+		},
+	},
+}
+
+func init() {
+	fixes := map[string]geo.Latlong{
+		"KSFO": sfo.KLatlongSFO,
+		"KSJC": sfo.KLatlongSJC,
+	}
+	for name,pos := range sfo.KFixes {
+		fixes[name] = pos
+	}
+	for i,_ := range KProcedures {
+		KProcedures[i].Populate(fixes)
+	}
+}
+
+// }}}
+
+// {{{ ProcedureMatch
+
+// ProcedureMatch is where an aircraft was, relative to a procedure.
+type ProcedureMatch struct {
+	Name         string  // e.g. SERFR2
+	Departure    bool    // If false, it was an arrival
+	LastWaypoint string  // The start of the leg the aircraft was on
+	NextWaypoint string
+	DistKM       float64 // How far off the leg the aircraft was
+}
+
+func (m ProcedureMatch)IsZero() bool { return m.Name == "" }
+
+func (m ProcedureMatch)String() string {
+	if m.IsZero() { return "no procedure" }
+	return fmt.Sprintf("%s/%s (%.1fKM off the %s-%s leg)", m.Name, m.LastWaypoint, m.DistKM,
+		m.LastWaypoint, m.NextWaypoint)
+}
+
+// }}}
+// {{{ MatchProcedureLeg
+
+// MatchProcedureLeg looks at a single leg (from waypoint i to i+1), and returns how far off the
+// leg the aircraft was. The bool is false if the aircraft was not flying the leg.
+func MatchProcedureLeg(a Aircraft, from, to geo.Waypoint) (float64, bool) {
+	if from.Latlong.IsNil() || to.Latlong.IsNil() { return 0, false }
+
+	pos := a.Latlong()
+	line := from.Latlong.LineTo(to.Latlong)
+
+	if along := line.DistAlongLine(pos); along < -KProcedureLegSlop || along > 1+KProcedureLegSlop {
+		return 0, false
+	}
+
+	dist := line.ClosestDistance(pos)
+	if dist > KProcedureCorridorKM { return 0, false }
+
+	legBearing := from.Latlong.BearingTowards(to.Latlong)
+	if math.Abs(geo.HeadingDelta(a.Track, legBearing)) > KProcedureMaxHeadingDelta {
+		return 0, false
+	}
+
+	// Between the two waypoints, the altitude should be within the restrictions of both
+	if from.MaxAltitude > 0 && a.Altitude > from.MaxAltitude + KProcedureAltitudeSlopFeet {
+		return 0, false
+	}
+	if to.MinAltitude > 0 && a.Altitude < to.MinAltitude - KProcedureAltitudeSlopFeet {
+		return 0, false
+	}
+
+	return dist, true
+}
+
+// }}}
+// {{{ MatchProcedure
+
+// MatchProcedure finds the procedure leg that best fits the aircraft. Only procedures for its
+// origin (departures) or destination (arrivals) are considered.
+func MatchProcedure(a Aircraft) ProcedureMatch {
+	best := ProcedureMatch{}
+
+	for _,proc := range KProcedures {
+		if proc.Departure && proc.Airport != a.Origin { continue }
+		if !proc.Departure && proc.Airport != a.Destination { continue }
+
+		for i:=0; i<len(proc.Waypoints)-1; i++ {
+			from,to := proc.Waypoints[i], proc.Waypoints[i+1]
+			if dist,ok := MatchProcedureLeg(a, from, to); !ok {
+				continue
+			} else if best.IsZero() || dist < best.DistKM {
+				best = ProcedureMatch{
+					Name: proc.Name,
+					Departure: proc.Departure,
+					LastWaypoint: from.FixName,
+					NextWaypoint: to.FixName,
+					DistKM: dist,
+				}
+			}
+		}
+	}
+
+	return best
+}
+
+// }}}
+// {{{ a.TagProcedure
+
+// TagProcedure fills in the aircraft's procedure fields, and adds the procedure to its tags. It
+// returns the match, which is zero if the aircraft wasn't on a known procedure.
+func (a *Aircraft)TagProcedure() ProcedureMatch {
+	m := MatchProcedure(*a)
+	if m.IsZero() { return m }
+
+	if m.Departure {
+		a.DepartureProcedureName = m.Name
+		a.DepartureProcedureLastWaypoint = m.LastWaypoint
+	} else {
+		a.ArrivalProcedureName = m.Name
+		a.ArrivalProcedureLastWaypoint = m.LastWaypoint
+	}
+
+	a.AddTag(m.Name)
+
+	return m
+}
+
+// }}}
+// {{{ a.AddTag, a.HasTag
+
+func (a Aircraft)TagList() []string {
+	if a.Tags == "" { return []string{} }
+	return strings.Split(a.Tags, ",")
+}
+
+func (a Aircraft)HasTag(tag string) bool {
+	for _,t := range a.TagList() {
+		if t == tag { return true }
+	}
+	return false
+}
+
+func (a *Aircraft)AddTag(tag string) {
+	if tag == "" || a.HasTag(tag) { return }
+	a.Tags = strings.Join(append(a.TagList(), tag), ",")
+}
+
+// }}}
+// {{{ a.ProcedureName, a.ProcedureString
+
+// ProcedureName is the arrival or departure procedure the aircraft was flying, if known.
+func (a Aircraft)ProcedureName() string {
+	if a.ArrivalProcedureName != "" { return a.ArrivalProcedureName }
+	return a.DepartureProcedureName
+}
+
+// ProcedureString is PROCEDURE/LAST-ON-PROCEDURE-WAYPOINT, or empty if no procedure is known.
+func (a Aircraft)ProcedureString() string {
+	if a.ArrivalProcedureName != "" {
+		return a.ArrivalProcedureName + "/" + a.ArrivalProcedureLastWaypoint
+	} else if a.DepartureProcedureName != "" {
+		return a.DepartureProcedureName + "/" + a.DepartureProcedureLastWaypoint
+	}
+	return ""
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,89 @@
+package flightid
+
+import (
+	"testing"
+
+	"github.com/skypies/geo/sfo"
+)
+
+// {{{ TestMatchProcedure
+
+func TestMatchProcedure(t *testing.T) {
+	// Halfway down the EPICK-EDDYY leg of SERFR2, descending into SFO
+	epick,eddyy := sfo.KFixes["EPICK"], sfo.KFixes["EDDYY"]
+	pos := epick.InterpolateTo(eddyy, 0.5)
+	onProc := Aircraft{
+		FlightNumber: "UA123",
+		Destination: "SFO",
+		Lat: pos.Lat,
+		Long: pos.Long,
+		Track: epick.BearingTowards(eddyy),
+		Altitude: 9000,
+	}
+
+	tests := []struct{
+		Name string
+		Mod func(a *Aircraft)
+		Expected string
+	}{
+		{"on procedure", func(a *Aircraft){}, "SERFR2/EPICK"},
+		{"wrong airport", func(a *Aircraft){ a.Destination = "SJC" }, ""},
+		{"wrong direction", func(a *Aircraft){ a.Track += 180 }, ""},
+		{"too high", func(a *Aircraft){ a.Altitude = 25000 }, ""},
+		{"vectored away", func(a *Aircraft){
+			off := pos.MoveKM(a.Track+90, 10)
+			a.Lat,a.Long = off.Lat,off.Long
+		}, ""},
+	}
+
+	for _,test := range tests {
+		a := onProc
+		test.Mod(&a)
+		m := a.TagProcedure()
+		if actual := a.ProcedureString(); actual != test.Expected {
+			t.Errorf("%s: expected %q, got %q (%s)", test.Name, test.Expected, actual, m)
+		}
+		if test.Expected != "" && !a.HasTag("SERFR2") {
+			t.Errorf("%s: procedure not tagged: %q", test.Name, a.Tags)
+		}
+	}
+
+	// Departures get tagged too
+	sstik,porte := sfo.KFixes["SSTIK"], sfo.KFixes["PORTE"]
+	pos = sstik.InterpolateTo(porte, 0.5)
+	dep := Aircraft{
+		FlightNumber: "UA456",
+		Origin: "SFO",
+		Lat: pos.Lat,
+		Long: pos.Long,
+		Track: sstik.BearingTowards(porte),
+		Altitude: 6000,
+	}
+	if m := dep.TagProcedure(); !m.Departure || dep.DepartureProcedureName != "SSTIK" ||
+		dep.ProcedureString() != "SSTIK/SSTIK" || !dep.HasTag("SSTIK") {
+		t.Errorf("departure not tagged: %s, %q", m, dep.ProcedureString())
+	}
+	dep.Origin = "OAK"
+	if m := MatchProcedure(dep); !m.IsZero() {
+		t.Errorf("departure from the wrong airport matched: %s", m)
+	}
+
+	// Tagging twice shouldn't duplicate the tag
+	a := onProc
+	a.Tags = "foo"
+	a.TagProcedure()
+	a.TagProcedure()
+	if a.Tags != "foo,SERFR2" {
+		t.Errorf("tags got mangled: %q", a.Tags)
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,99 @@
+package flightid
+
+import(
+	"fmt"
+	"strings"
+)
+
+// {{{ Replay
+
+// Replay runs a recorded snapshot back through IdentifyOverhead, as if the airspace had just
+// been fetched (the snapshot's timestamps are shifted forwards, to defeat the age checks).
+func Replay(snap Snapshot, algo Selector) (*Aircraft, string) {
+	res := IdentifyAt(&snap.Airspace, snap.Time, snap.Pos, snap.Elev, algo)
+	return res.Flight, res.Debug
+}
+
+// }}}
+// {{{ ReplayResult, ReplayCorpus
+
+// ReplayResult holds the picks that each selector made for one snapshot.
+type ReplayResult struct {
+	Snapshot Snapshot
+	Picks    map[string]string // selector name -> PickIdent()
+}
+
+// Changed is true if the selectors didn't all agree with each other (and, if compareRecorded
+// is set, with whatever was picked at the time the snapshot was recorded).
+func (rr ReplayResult)Changed(compareRecorded bool) bool {
+	seen := map[string]bool{}
+	for _,pick := range rr.Picks { seen[pick] = true }
+	if compareRecorded { seen[rr.Snapshot.Pick] = true }
+	return len(seen) > 1
+}
+
+// ReplayCorpus replays each snapshot through each selector; the params recorded in the snapshot
+// (i.e. the user's) are used for all of them.
+func ReplayCorpus(snaps []Snapshot, selectorNames []string) []ReplayResult {
+	results := []ReplayResult{}
+	for _,snap := range snaps {
+		rr := ReplayResult{Snapshot:snap, Picks:map[string]string{}}
+		for _,name := range selectorNames {
+			oh,_ := Replay(snap, NewSelectorWithParams(name, snap.Params))
+			rr.Picks[name] = PickIdent(oh)
+		}
+		results = append(results, rr)
+	}
+	return results
+}
+
+// }}}
+// {{{ ReplayReport
+
+// ReplayReport lists the snapshots where the picks changed; the first column is what was
+// picked at the time, followed by a column per selector. It ends with some totals.
+func ReplayReport(results []ReplayResult, selectorNames []string) string {
+	str := fmt.Sprintf("%-20.20s %-10.10s", "Time", "(recorded)")
+	for _,name := range selectorNames { str += fmt.Sprintf(" %-12.12s", name) }
+	str += "\n"
+
+	pickStr := func(s string) string { if s == "" { return "-" }; return s }
+
+	nChanged, nChangedVsRecorded := 0, 0
+	nPicked := map[string]int{}
+	for _,rr := range results {
+		for _,name := range selectorNames {
+			if rr.Picks[name] != "" { nPicked[name]++ }
+		}
+		if rr.Changed(false) { nChanged++ }
+		if !rr.Changed(true) { continue }
+		nChangedVsRecorded++
+
+		line := fmt.Sprintf("%-20.20s %-10.10s", rr.Snapshot.Time.Format("2006/01/02 15:04:05"),
+			pickStr(rr.Snapshot.Pick))
+		for _,name := range selectorNames {
+			line += fmt.Sprintf(" %-12.12s", pickStr(rr.Picks[name]))
+		}
+		str += line + "\n"
+	}
+
+	str += fmt.Sprintf("\n%d snapshots; selectors disagreed on %d, and %d differed from the "+
+		"recorded pick\n", len(results), nChanged, nChangedVsRecorded)
+	picked := []string{}
+	for _,name := range selectorNames {
+		picked = append(picked, fmt.Sprintf("%s=%d", name, nPicked[name]))
+	}
+	str += fmt.Sprintf("picks made: %s\n", strings.Join(picked, ", "))
+
+	return str
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
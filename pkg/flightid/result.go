@@ -1,15 +1,150 @@
 package flightid
 
-import()
+import(
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/skypies/geo"
+)
 
 // Result has all the data retrieved from our attempt to identify the aircraft overhead
 type Result struct {
-	Flight     *Aircraft
+	Flight     *Aircraft   // What the selector picked; nil if it didn't pick anything
 	Err         error
 
-	All      []*Aircraft
-	Filtered []*Aircraft
+	All      []*Aircraft   // Everything nearby
+	Filtered []*Aircraft   // What survived filtering & time syncing; the selector picked from these
+
+	Candidates []Candidate // All of Filtered, scored and ranked (see ScoreAircraft)
+	Confidence  float64    // The score of Flight; 0.0 if there was no pick
+
+	Outcome     string     // The selector's oneline explanation
+	Trace       IdentificationTrace
+	Debug       string     // Trace.String()
+}
+
+// Alternates returns the n best-ranked candidates that weren't picked.
+func (r Result)Alternates(n int) []Candidate {
+	ret := []Candidate{}
+	for _,c := range r.Candidates {
+		if len(ret) >= n { break }
+		if r.Flight != nil && c.Id2 == r.Flight.Id2 { continue }
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+// {{{ Candidate
+
+// Candidate is one of the aircraft that might have made the noise, with a score. The scores of
+// all the candidates from a single identification add up to 1.0.
+type Candidate struct {
+	FlightNumber string  `datastore:",noindex"`
+	Callsign     string  `datastore:",noindex"`
+	Registration string  `datastore:",noindex"`
+	Id2          string  `datastore:",noindex"` // ModeS
+	EquipType    string  `datastore:",noindex"`
+	Dist3        float64 `datastore:",noindex"`
+	Score        float64 `datastore:",noindex"`
+}
+
+func NewCandidate(a Aircraft, score float64) Candidate {
+	return Candidate{
+		FlightNumber: a.FlightNumber,
+		Callsign: a.Callsign,
+		Registration: a.Registration,
+		Id2: a.Id2,
+		EquipType: a.EquipType,
+		Dist3: a.Dist3,
+		Score: score,
+	}
+}
+
+// Ident is the same as Aircraft.BestIdent
+func (c Candidate)Ident() string {
+	a := Aircraft{FlightNumber:c.FlightNumber, Registration:c.Registration, Callsign:c.Callsign,
+		Id2:c.Id2}
+	return a.BestIdent()
+}
+
+func (c Candidate)String() string { return fmt.Sprintf("%s (%.2f)", c.Ident(), c.Score) }
 
-	Debug       string
+type CandidatesByScore []Candidate
+func (s CandidatesByScore) Len() int      { return len(s) }
+func (s CandidatesByScore) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s CandidatesByScore) Less(i, j int) bool { return s[i].Score > s[j].Score }
+
+// CandidatesString renders a ranked list as "probably UA123 (0.80), possibly AS45 (0.15)".
+func CandidatesString(cands []Candidate) string {
+	strs := []string{}
+	for i,c := range cands {
+		if i == 0 {
+			strs = append(strs, "probably "+c.String())
+		} else {
+			strs = append(strs, "possibly "+c.String())
+		}
+	}
+	return strings.Join(strs, ", ")
 }
 
+// }}}
+// {{{ ScoreAircraft
+
+// Scorer is an optional role for selectors that have their own idea of how likely each aircraft
+// is to be the culprit. Scores are relative; they don't need to add up to anything.
+type Scorer interface {
+	Score(pos geo.Latlong, elev float64, aircraft []Aircraft) []float64
+}
+
+// Explainer is an optional role for selectors that have more to say about each aircraft than
+// fits in the outcome string; it gets added to the debug output.
+type Explainer interface {
+	Explain(pos geo.Latlong, elev float64, aircraft []Aircraft) string
+}
+
+const kMinScoringDistKM = 0.5 // Don't let anything get an infinite score
+
+// InverseSquareScore is the default scoring; sound intensity falls off with the square of the
+// distance, so that's how likely we consider each aircraft to be.
+func InverseSquareScore(dist3KM float64) float64 {
+	d := math.Max(dist3KM, kMinScoringDistKM)
+	return 1.0 / (d*d)
+}
+
+// ScoreAircraft scores all the aircraft, normalizes the scores to add up to 1.0, and ranks them
+// by descending score. It uses the selector's own scoring, if it has any.
+func ScoreAircraft(algo Selector, pos geo.Latlong, elev float64, in []Aircraft) []Candidate {
+	scores := []float64{}
+	if scorer,ok := algo.(Scorer); ok {
+		scores = scorer.Score(pos, elev, in)
+	} else {
+		for _,a := range in {
+			scores = append(scores, InverseSquareScore(a.Dist3))
+		}
+	}
+
+	total := 0.0
+	for _,s := range scores { total += s }
+
+	ret := []Candidate{}
+	for i,a := range in {
+		score := 0.0
+		if total > 0 { score = scores[i] / total }
+		ret = append(ret, NewCandidate(a, score))
+	}
+
+	sort.Stable(CandidatesByScore(ret))
+	return ret
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,49 @@
+package flightid
+
+import (
+	"math"
+	"testing"
+
+	"github.com/skypies/geo"
+)
+
+// {{{ TestScoreAircraft
+
+func TestScoreAircraft(t *testing.T) {
+	pos := geo.Latlong{Lat:37.060312, Long:-121.990814}
+	in := []Aircraft{
+		{FlightNumber:"UA123", Id2:"A00001", Dist3:6.0},
+		{FlightNumber:"AS45", Id2:"A00002", Dist3:2.0},
+	}
+
+	cands := ScoreAircraft(AlgoConservativeNoCongestion{}, pos, 0, in)
+	if len(cands) != 2 || cands[0].FlightNumber != "AS45" {
+		t.Fatalf("candidates not ranked by score: %v", cands)
+	}
+	if total := cands[0].Score + cands[1].Score; math.Abs(total - 1.0) > 1e-9 {
+		t.Errorf("scores add up to %f", total)
+	}
+	if math.Abs(cands[0].Score - 0.9) > 1e-9 {
+		t.Errorf("expected 3x closer to score 9x higher, got %v", cands)
+	}
+
+	res := Result{Flight:&in[1], Candidates:cands}
+	if alts := res.Alternates(3); len(alts) != 1 || alts[0].FlightNumber != "UA123" {
+		t.Errorf("alternates should exclude the pick: %v", alts)
+	}
+
+	expected := "probably AS45 (0.90), possibly UA123 (0.10)"
+	if str := CandidatesString(cands); str != expected {
+		t.Errorf("expected %q, got %q", expected, str)
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -18,19 +18,51 @@ type Selector interface {
 	// was identified, returns nil (but the string will explain why).
 	// The aircraft slice is initially sorted by Dist3.
 	Identify(pos geo.Latlong, elev float64, aircraft []Aircraft) (*Aircraft, string)
+
+	// The params this selector was created with (including the filtering IdentifyOverhead
+	// should do before calling Identify).
+	Parameters() Params
 }
 
-// SelectorNames is the list of selectors we want users to be able to pick from
-var SelectorNames = []string{"conservative","cone"}
+// SelectorNames is the list of selectors we want users to be able to pick from. The trajectory
+// selector needs recent tracks (see TrackSource) as well as the airspace.
+var SelectorNames = []string{"conservative","cone","acoustic","trajectory"}
+
+// LiveSelectorName maps a profile's selector onto one that can run on the airspace alone, for
+// when the tracks can't be fetched.
+func LiveSelectorName(name string) string {
+	if name == "trajectory" { return "conservative" }
+	return name
+}
 
 func NewSelector(name string) Selector {
+	return NewSelectorWithParams(name, DefaultParams())
+}
+
+// NewSelectorWithParams returns the named selector, using the params (unset values get defaults).
+func NewSelectorWithParams(name string, p Params) Selector {
+	p = p.WithDefaults()
 	switch name {
-	case "random": return AlgoRandom{}
-	case "conservative": return AlgoConservativeNoCongestion{}
-	case "cone": return AlgoLowestInCone{}
-	default: return AlgoConservativeNoCongestion{}
+	case "random": return AlgoRandom{Params:p}
+	case "conservative": return AlgoConservativeNoCongestion{Params:p}
+	case "cone": return AlgoLowestInCone{Params:p}
+	case "acoustic": return AlgoAcoustic{Params:p}
+	case "trajectory": return AlgoTrajectory{Params:p}
+	default: return AlgoConservativeNoCongestion{Params:p}
 	}
 }
+// SelectorName is the inverse of NewSelectorWithParams.
+func SelectorName(algo Selector) string {
+	switch algo.(type) {
+	case AlgoRandom: return "random"
+	case AlgoConservativeNoCongestion: return "conservative"
+	case AlgoLowestInCone: return "cone"
+	case AlgoAcoustic: return "acoustic"
+	case AlgoTrajectory: return "trajectory"
+	default: return fmt.Sprintf("%T", algo)
+	}
+}
+
 func ListSelectors() [][]string {
 	ret := [][]string{}
 	for _,name := range SelectorNames {
@@ -39,8 +71,11 @@ func ListSelectors() [][]string {
 	return ret
 }
 
-type AlgoRandom struct{}
+type AlgoRandom struct{
+	Params Params
+}
 func (a AlgoRandom)String() string { return "Picks at random" }
+func (a AlgoRandom)Parameters() Params { return a.Params }
 func (a AlgoRandom)Identify(pos geo.Latlong, elev float64, in []Aircraft) (*Aircraft,string) {
 	if len(in) == 0 {
 		return nil, "list was empty"
@@ -50,32 +85,42 @@ func (a AlgoRandom)Identify(pos geo.Latlong, elev float64, in []Aircraft) (*Airc
 }
 
 // The original, "no congestion allowed" heuristic ...
-type AlgoConservativeNoCongestion struct{}
+type AlgoConservativeNoCongestion struct{
+	Params Params
+}
 func (a AlgoConservativeNoCongestion)String() string { return "Conservative, gives up on congestion" } 
+func (a AlgoConservativeNoCongestion)Parameters() Params { return a.Params }
 func (a AlgoConservativeNoCongestion)Identify(pos geo.Latlong, elev float64, in []Aircraft) (*Aircraft,string) {
+	p := a.Params.WithDefaults()
 	if len(in) == 0 {
 		return nil, "nothing in list"
-	} else if (in[0].Dist3 >= 12.0) {
-		return nil, "not picked; 1st closest was too far away (>12KM)"
-	} else if (len(in) == 1) || (in[1].Dist3 - in[0].Dist3) > 4.0 {
+	} else if (in[0].Dist3 >= p.MaxDistKM) {
+		return nil, fmt.Sprintf("not picked; 1st closest was too far away (>%.0fKM)", p.MaxDistKM)
+	} else if (len(in) == 1) || (in[1].Dist3 - in[0].Dist3) > p.MinSeparationKM {
 		return &in[0], "selected 1st closest"
 	} else {
-		return nil, "not picked; 2nd closest was too close to 1st (<4KM)"
+		return nil, fmt.Sprintf("not picked; 2nd closest was too close to 1st (<%.0fKM)",
+			p.MinSeparationKM)
 	}
 }
 
 
-type AlgoLowestInCone struct{}
+type AlgoLowestInCone struct{
+	Params Params
+}
 func (a AlgoLowestInCone)String() string {
-	return "Picks lowest inside a 60deg cone [EXPERIMENTAL]"
+	return fmt.Sprintf("Picks lowest inside a %.0fdeg cone [EXPERIMENTAL]",
+		a.Params.WithDefaults().ConeAngleDeg)
 }
+func (a AlgoLowestInCone)Parameters() Params { return a.Params }
 
 func (a AlgoLowestInCone)Identify(pos geo.Latlong, elev float64, in []Aircraft) (*Aircraft,string) {
+	p := a.Params.WithDefaults()
 	if len(in) == 0 {
 		return nil, "nothing in list"
-	} else if (in[0].Dist3 >= 12.0) {
-		return nil, "not picked; 1st closest was too far away (>12KM)"
-	} else if (len(in) == 1) || (in[1].Dist3 - in[0].Dist3) > 4.0 {
+	} else if (in[0].Dist3 >= p.MaxDistKM) {
+		return nil, fmt.Sprintf("not picked; 1st closest was too far away (>%.0fKM)", p.MaxDistKM)
+	} else if (len(in) == 1) || (in[1].Dist3 - in[0].Dist3) > p.MinSeparationKM {
 		return &in[0], "selected 1st closest"
 	}
 
@@ -85,13 +130,13 @@ func (a AlgoLowestInCone)Identify(pos geo.Latlong, elev float64, in []Aircraft)
 		// angle between a vertical line from pos, and the line from pos to the aircraft.
 		horizDistKM := a.Dist
 		vertDistKM := (a.Altitude - elev) / geo.KFeetPerKM
-		if angle := math.Atan2(horizDistKM,vertDistKM) * (180.0 / math.Pi); angle <= 60 {
+		if angle := math.Atan2(horizDistKM,vertDistKM) * (180.0 / math.Pi); angle <= p.ConeAngleDeg {
 			enconed = append(enconed, a)
 		}
 	}
 
 	if len(enconed) == 0 {
-		return nil, "not picked; nothing found inside 60deg cone."
+		return nil, fmt.Sprintf("not picked; nothing found inside %.0fdeg cone.", p.ConeAngleDeg)
 	}
 
 	sort.Sort(AircraftByAltitude(enconed))
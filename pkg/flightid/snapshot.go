@@ -0,0 +1,325 @@
+package flightid
+
+// Snapshots record exactly what the identification code saw when a complaint was made (the raw
+// airspace, and where the observer was), so that selector changes can be checked against
+// real past conditions (see replay.go).
+
+import(
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/skypies/geo"
+	"github.com/skypies/pi/airspace"
+	"github.com/skypies/util/gcp/gcs"
+
+	"github.com/skypies/complaints/pkg/config"
+)
+
+// Snapshot is a single identification's input, plus what was picked at the time.
+type Snapshot struct {
+	Id        string            // Unique; complaints refer to their snapshot with this
+	Time      time.Time         // When the airspace was fetched
+	Pos       geo.Latlong       // The observer
+	Elev      float64           // The observer's elevation, in feet
+	Source    string            // Which AirspaceSource it came from
+	Selector  string            // The name of the selector used at the time
+	Params    Params            // ... and its params
+	Pick      string            // What it picked (see PickIdent)
+	Airspace  airspace.Airspace
+}
+
+func (s Snapshot)String() string {
+	return fmt.Sprintf("%s %s (%d aircraft, %s picked %q)", s.Time.Format(time.RFC3339), s.Pos,
+		len(s.Airspace.Aircraft), s.Selector, s.Pick)
+}
+
+// SnapshotId generates an id for a snapshot of an airspace fetched at t.
+func SnapshotId(t time.Time) string { return fmt.Sprintf("%d", t.UnixNano()) }
+
+// PickIdent is how we compare picks; the flightnumber, else the ModeS, else "" for no pick.
+func PickIdent(a *Aircraft) string {
+	if a == nil { return "" }
+	if a.FlightNumber != "" { return a.FlightNumber }
+	return a.Id2
+}
+
+// SnapshotCorpus is a role for places we can store snapshots.
+type SnapshotCorpus interface {
+	String() string
+	Record(ctx context.Context, snap Snapshot) error
+	Snapshots(ctx context.Context) ([]Snapshot, error) // All of them, in time order
+
+	// SnapshotsBetween returns the snapshots taken between s and e (inclusive), in time order,
+	// reading only as much of the corpus as it needs to.
+	SnapshotsBetween(ctx context.Context, s,e time.Time) ([]Snapshot, error)
+}
+
+// {{{ NewSnapshotCorpus, DefaultSnapshotCorpus
+
+// NewSnapshotCorpus parses names like "dir:/var/snapshots" and "gcs:my-bucket". It returns nil
+// for an empty name (or an unparseable one).
+func NewSnapshotCorpus(name string) SnapshotCorpus {
+	if strings.HasPrefix(name, "dir:") {
+		return &DirSnapshotCorpus{Dir: strings.TrimPrefix(name, "dir:")}
+	} else if strings.HasPrefix(name, "gcs:") {
+		return GCSSnapshotCorpus{Bucket: strings.TrimPrefix(name, "gcs:")}
+	}
+	return nil
+}
+
+// DefaultSnapshotCorpus is configured by "airspace.corpus"; it is usually unset, i.e. nil.
+func DefaultSnapshotCorpus() SnapshotCorpus {
+	return NewSnapshotCorpus(config.Get("airspace.corpus"))
+}
+
+// LookupSnapshots fetches the snapshots with the given ids from the corpus, reading only the
+// parts of it around the times in the ids (see SnapshotId). Ids that aren't found are left out.
+func LookupSnapshots(ctx context.Context, corpus SnapshotCorpus, ids []string) (map[string]*Snapshot, error) {
+	byDay := map[string][]time.Time{} // UTC day -> snapshot times
+	for _,id := range ids {
+		nanos,err := strconv.ParseInt(id, 10, 64)
+		if err != nil { continue }
+		t := time.Unix(0, nanos).UTC()
+		day := t.Format("20060102")
+		byDay[day] = append(byDay[day], t)
+	}
+
+	wanted := map[string]bool{}
+	for _,id := range ids { wanted[id] = true }
+
+	ret := map[string]*Snapshot{}
+	for _,times := range byDay {
+		s,e := times[0], times[0]
+		for _,t := range times {
+			if t.Before(s) { s = t }
+			if t.After(e) { e = t }
+		}
+		snaps,err := corpus.SnapshotsBetween(ctx, s, e)
+		if err != nil {
+			return nil, fmt.Errorf("LookupSnapshots: %v", err)
+		}
+		for i := range snaps {
+			if wanted[snaps[i].Id] { ret[snaps[i].Id] = &snaps[i] }
+		}
+	}
+	return ret, nil
+}
+
+// }}}
+// {{{ WriteSnapshot, ReadSnapshots
+
+// WriteSnapshot writes a snapshot as its own gzip member; members can be concatenated
+// into a single file, and read back with ReadSnapshots.
+func WriteSnapshot(w io.Writer, snap Snapshot) error {
+	gzw := gzip.NewWriter(w)
+	if err := json.NewEncoder(gzw).Encode(snap); err != nil {
+		return err
+	}
+	return gzw.Close()
+}
+
+func ReadSnapshots(r io.Reader) ([]Snapshot, error) {
+	snaps := []Snapshot{}
+	gzr,err := gzip.NewReader(r)
+	if err == io.EOF {
+		return snaps, nil // empty file
+	} else if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(gzr) // gzip.Reader reads through concatenated members by default
+	for {
+		snap := Snapshot{}
+		if err := dec.Decode(&snap); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, snap)
+	}
+
+	return snaps, nil
+}
+
+func sortSnapshots(snaps []Snapshot) {
+	sort.SliceStable(snaps, func(i,j int) bool { return snaps[i].Time.Before(snaps[j].Time) })
+}
+
+// filterSnapshots keeps the ones between s and e (inclusive).
+func filterSnapshots(snaps []Snapshot, s,e time.Time) []Snapshot {
+	ret := []Snapshot{}
+	for _,snap := range snaps {
+		if !snap.Time.Before(s) && !snap.Time.After(e) {
+			ret = append(ret, snap)
+		}
+	}
+	return ret
+}
+
+// dayOverlaps is whether the UTC day named (as per "20060102") overlaps s to e.
+func dayOverlaps(day string, s,e time.Time) bool {
+	start,err := time.Parse("20060102", day)
+	if err != nil { return true } // Not ours to judge; let the caller read it
+	return !start.After(e) && start.Add(24*time.Hour).After(s)
+}
+
+// }}}
+
+// {{{ DirSnapshotCorpus
+
+// DirSnapshotCorpus appends snapshots to one file per (UTC) day, in a local directory.
+type DirSnapshotCorpus struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+func (c *DirSnapshotCorpus)String() string { return "dir:"+c.Dir }
+
+func (c *DirSnapshotCorpus)filename(t time.Time) string {
+	return filepath.Join(c.Dir, t.UTC().Format("20060102")+".snapshots.gz")
+}
+
+func (c *DirSnapshotCorpus)Record(ctx context.Context, snap Snapshot) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return fmt.Errorf("DirSnapshotCorpus.Record: %v", err)
+	}
+	f,err := os.OpenFile(c.filename(snap.Time), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("DirSnapshotCorpus.Record: %v", err)
+	}
+	if err := WriteSnapshot(f, snap); err != nil {
+		f.Close()
+		return fmt.Errorf("DirSnapshotCorpus.Record: %v", err)
+	}
+	return f.Close()
+}
+
+func (c *DirSnapshotCorpus)Snapshots(ctx context.Context) ([]Snapshot, error) {
+	return c.read(func(string) bool { return true })
+}
+
+func (c *DirSnapshotCorpus)SnapshotsBetween(ctx context.Context, s,e time.Time) ([]Snapshot, error) {
+	snaps,err := c.read(func(filename string) bool {
+		return dayOverlaps(strings.TrimSuffix(filepath.Base(filename), ".snapshots.gz"), s, e)
+	})
+	if err != nil { return nil, err }
+	return filterSnapshots(snaps, s, e), nil
+}
+
+// read reads the day files that match.
+func (c *DirSnapshotCorpus)read(match func(filename string) bool) ([]Snapshot, error) {
+	filenames,err := filepath.Glob(filepath.Join(c.Dir, "*.snapshots.gz"))
+	if err != nil { return nil, err }
+
+	snaps := []Snapshot{}
+	for _,filename := range filenames {
+		if !match(filename) { continue }
+		f,err := os.Open(filename)
+		if err != nil { return nil, err }
+		fileSnaps,err := ReadSnapshots(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("DirSnapshotCorpus.Snapshots(%s): %v", filename, err)
+		}
+		snaps = append(snaps, fileSnaps...)
+	}
+
+	sortSnapshots(snaps)
+	return snaps, nil
+}
+
+// }}}
+// {{{ GCSSnapshotCorpus
+
+// GCSSnapshotCorpus writes each snapshot into its own object, as GCS can't append.
+type GCSSnapshotCorpus struct {
+	Bucket string
+}
+
+const kGCSSnapshotPrefix = "airspace-snapshots/"
+
+func (c GCSSnapshotCorpus)String() string { return "gcs:"+c.Bucket }
+
+func (c GCSSnapshotCorpus)Record(ctx context.Context, snap Snapshot) error {
+	id := snap.Id
+	if id == "" { id = SnapshotId(snap.Time) }
+	filename := fmt.Sprintf("%s%s/%s.gz", kGCSSnapshotPrefix, snap.Time.UTC().Format("20060102"), id)
+
+	h,err := gcs.OpenRW(ctx, c.Bucket, filename, "application/gzip")
+	if err != nil {
+		return fmt.Errorf("GCSSnapshotCorpus.Record: %v", err)
+	}
+	if err := WriteSnapshot(h.IOWriter(), snap); err != nil {
+		h.Close()
+		return fmt.Errorf("GCSSnapshotCorpus.Record: %v", err)
+	}
+	return h.Close()
+}
+
+func (c GCSSnapshotCorpus)Snapshots(ctx context.Context) ([]Snapshot, error) {
+	return c.read(ctx, func(string) bool { return true })
+}
+
+// SnapshotsBetween only opens the objects whose names (day, and id) fall in the window.
+func (c GCSSnapshotCorpus)SnapshotsBetween(ctx context.Context, s,e time.Time) ([]Snapshot, error) {
+	snaps,err := c.read(ctx, func(filename string) bool {
+		day,id := path.Split(strings.TrimPrefix(filename, kGCSSnapshotPrefix))
+		if !dayOverlaps(strings.TrimSuffix(day, "/"), s, e) {
+			return false
+		} else if nanos,err := strconv.ParseInt(strings.TrimSuffix(id, ".gz"), 10, 64); err == nil {
+			t := time.Unix(0, nanos)
+			return !t.Before(s) && !t.After(e)
+		}
+		return true
+	})
+	if err != nil { return nil, err }
+	return filterSnapshots(snaps, s, e), nil
+}
+
+// read reads the objects that match.
+func (c GCSSnapshotCorpus)read(ctx context.Context, match func(filename string) bool) ([]Snapshot, error) {
+	filenames,err := gcs.ListBucket(ctx, c.Bucket)
+	if err != nil { return nil, err }
+
+	snaps := []Snapshot{}
+	for _,filename := range filenames {
+		if !strings.HasPrefix(filename, kGCSSnapshotPrefix) || !match(filename) { continue }
+
+		h,err := gcs.OpenR(ctx, c.Bucket, filename)
+		if err != nil { return nil, err }
+		fileSnaps,err := ReadSnapshots(h.IOReader())
+		h.Close()
+		if err != nil {
+			return nil, fmt.Errorf("GCSSnapshotCorpus.Snapshots(%s): %v", filename, err)
+		}
+		snaps = append(snaps, fileSnaps...)
+	}
+
+	sortSnapshots(snaps)
+	return snaps, nil
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
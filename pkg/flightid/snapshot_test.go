@@ -0,0 +1,144 @@
+package flightid
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+	"github.com/skypies/pi/airspace"
+)
+
+// {{{ makeSnapshot
+
+func makeSnapshot(t time.Time, icaoids ...string) Snapshot {
+	as := airspace.NewAirspace()
+	for i,id := range icaoids {
+		msg := adsb.CompositeMsg{Msg: adsb.Msg{
+			Type: "MSG",
+			Icao24: adsb.IcaoId(id),
+			GeneratedTimestampUTC: t.Add(-1 * time.Duration(i) * time.Second),
+			Altitude: 5000,
+			Position: geo.Latlong{Lat:37.06, Long:-121.99},
+		}}
+		as.Aircraft[msg.Icao24] = airspace.AircraftData{Msg: &msg}
+	}
+	return Snapshot{
+		Time: t,
+		Pos: geo.Latlong{Lat:37.060312, Long:-121.990814},
+		Elev: 100,
+		Selector: "conservative",
+		Airspace: as,
+	}
+}
+
+// }}}
+
+// {{{ TestDirSnapshotCorpus
+
+func TestDirSnapshotCorpus(t *testing.T) {
+	ctx := context.Background()
+	corpus := NewSnapshotCorpus("dir:" + t.TempDir())
+
+	tm := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _,snapTime := range []time.Time{tm.Add(24*time.Hour), tm, tm.Add(time.Minute)} {
+		if err := corpus.Record(ctx, makeSnapshot(snapTime, "A00001", "A00002")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snaps,err := corpus.Snapshots(ctx)
+	if err != nil { t.Fatal(err) }
+	if len(snaps) != 3 {
+		t.Fatalf("expected 3 snapshots, found %d", len(snaps))
+	}
+	if !snaps[0].Time.Equal(tm) || !snaps[2].Time.Equal(tm.Add(24*time.Hour)) {
+		t.Errorf("snapshots not in time order: %s, %s", snaps[0], snaps[2])
+	}
+	if n := len(snaps[1].Airspace.Aircraft); n != 2 {
+		t.Errorf("expected 2 aircraft in snapshot, found %d", n)
+	}
+	if snaps[1].Elev != 100 || snaps[1].Selector != "conservative" {
+		t.Errorf("snapshot fields lost: %+v", snaps[1])
+	}
+
+	if snaps,err := corpus.SnapshotsBetween(ctx, tm, tm.Add(time.Minute)); err != nil || len(snaps) != 2 {
+		t.Errorf("expected 2 snapshots in the first minute, got %d (%v)", len(snaps), err)
+	}
+	if snaps,err := corpus.SnapshotsBetween(ctx, tm.Add(time.Hour), tm.Add(48*time.Hour)); err != nil || len(snaps) != 1 {
+		t.Errorf("expected 1 snapshot the next day, got %d (%v)", len(snaps), err)
+	}
+}
+
+// }}}
+// {{{ TestShiftAirspace
+
+func TestShiftAirspace(t *testing.T) {
+	tm := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	snap := makeSnapshot(tm, "A00001", "A00002")
+
+	shifted := ShiftAirspace(snap.Airspace, time.Hour)
+	for id,ad := range shifted.Aircraft {
+		orig := snap.Airspace.Aircraft[id].Msg.GeneratedTimestampUTC
+		if ad.Msg.GeneratedTimestampUTC.Sub(orig) != time.Hour {
+			t.Errorf("%s not shifted: %s vs %s", id, orig, ad.Msg.GeneratedTimestampUTC)
+		}
+	}
+	if !snap.Airspace.Aircraft["A00001"].Msg.GeneratedTimestampUTC.Equal(tm) {
+		t.Errorf("original airspace was mutated")
+	}
+
+	RetimeAirspace(&snap.Airspace, tm.Add(time.Minute))
+	if got := snap.Airspace.Aircraft["A00002"].Msg.GeneratedTimestampUTC; !got.Equal(tm.Add(59*time.Second)) {
+		t.Errorf("retime lost relative ages: %s", got)
+	}
+}
+
+// }}}
+// {{{ TestNewAirspaceSourceInDir
+
+func TestNewAirspaceSourceInDir(t *testing.T) {
+	tests := []struct{
+		name     string
+		dir      string
+		expected string // blank means it should be refused
+	}{
+		{"fdb", "", "fdb"},
+		{"file:as.json", "", ""},
+		{"file:as.json", "/snaps", "file:/snaps/as.json"},
+		{"file:/etc/passwd", "/snaps", "file:/snaps/etc/passwd"},
+		{"file:../etc/passwd", "/snaps", ""},
+		{"file:a/../../snaps2/as.json", "/snaps", ""},
+		{"consensus:fdb,file:as.json", "/snaps", "consensus:fdb,file:/snaps/as.json"},
+		{"consensus:fdb,file:../as.json", "/snaps", ""},
+		{"consensus", "/snaps", "consensus:fr24,fdb,aex"},
+		{"consensus:consensus:file:/etc/passwd", "/snaps", ""},
+		{"consensus:fdb,consensus", "/snaps", ""},
+		{"nosuch", "/snaps", ""},
+		{"consensus:fdb,nosuch", "/snaps", ""},
+		{"", "/snaps", ""},
+	}
+
+	for _,test := range tests {
+		src,err := NewAirspaceSourceInDir(test.name, test.dir)
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("%s in '%s': expected refusal, got %s", test.name, test.dir, src)
+			}
+		} else if err != nil || src.String() != test.expected {
+			t.Errorf("%s in '%s': got %v (err=%v), expected %s", test.name, test.dir, src, err, test.expected)
+		}
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,146 @@
+package flightid
+
+// An IdentificationTrace records everything that went into one identification attempt, in a
+// structured form that can be stored (as JSON) alongside the complaint. Batch jobs and admin
+// tools should look at OutcomeCode, rather than pattern-matching the human-readable text.
+
+import(
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Outcome codes. Anything that isn't OutcomePicked means there was no aircraft.
+const(
+	OutcomePicked      = "picked"       // The selector picked an aircraft
+	OutcomeAbstained   = "abstained"    // There were candidates, but the selector declined them
+	OutcomeAllFiltered = "all-filtered" // There were aircraft nearby, but none survived filtering
+	OutcomeNothingNear = "nothing-near" // The airspace was empty around the user
+	OutcomeNoAirspace  = "no-airspace"  // We didn't have an airspace to look at
+	OutcomeFetchFailed = "fetch-failed" // We tried to fetch an airspace, but it failed
+)
+
+// {{{ IdentificationTrace
+
+type IdentificationTrace struct {
+	Time           time.Time
+	Sources        []string    // Where the airspace came from (several, for a consensus)
+	FetchLatencyMS int64       // How long it took to fetch the airspace
+	QNH            float64     // If altitudes were corrected to geometric heights, the hPa used
+
+	Selector       string      // The selector's name, e.g. "conservative"
+	SelectorDesc   string      // ... and its description
+	Params         Params      // The params actually used (i.e. after defaults)
+
+	OutcomeCode    string      // One of the Outcome* constants
+	Outcome        string      // The selector's oneline explanation
+	Reason         string      // If nothing was picked, why not
+	Error          string      // If OutcomeCode is no-airspace or fetch-failed, the error
+
+	Pick           string      // PickIdent() of the aircraft picked
+	Confidence     float64
+	Procedure      string      // The procedure the pick was flying, if any
+
+	Raw            []Aircraft  // Everything nearby
+	Filtered       []Aircraft  // What survived filtering & time syncing
+	Candidates     []Candidate // Filtered, scored and ranked
+	Explanation    string      // From the selector, if it is an Explainer
+
+	Previous       *IdentificationTrace `json:",omitempty"` // If this is a reidentification
+}
+
+func (t IdentificationTrace)IsZero() bool { return t.OutcomeCode == "" }
+
+// Picked is true if the trace ended with an aircraft.
+func (t IdentificationTrace)Picked() bool { return t.OutcomeCode == OutcomePicked }
+
+// Summary is a oneline version, e.g. "conservative: abstained (too many aircraft in the way)".
+func (t IdentificationTrace)Summary() string {
+	str := fmt.Sprintf("%s: %s", t.Selector, t.OutcomeCode)
+	if t.Picked() {
+		str += " " + t.Pick
+	} else if t.Reason != "" {
+		str += " (" + t.Reason + ")"
+	}
+	return str
+}
+
+// }}}
+// {{{ t.Finish
+
+// Finish fills in the outcome code and reason from the rest of the trace.
+func (t *IdentificationTrace)Finish() {
+	switch {
+	case t.OutcomeCode != "":
+		// Already set, e.g. OutcomePicked
+	case len(t.Raw) == 0:
+		t.OutcomeCode, t.Reason = OutcomeNothingNear, "nothing found in the sky"
+	case len(t.Filtered) == 0:
+		t.OutcomeCode = OutcomeAllFiltered
+		t.Reason = fmt.Sprintf("all %d nearby aircraft were filtered out", len(t.Raw))
+	default:
+		t.OutcomeCode, t.Reason = OutcomeAbstained, t.Outcome
+	}
+}
+
+// }}}
+// {{{ t.String
+
+// String renders the trace as the human-readable debug text we used to store on complaints.
+func (t IdentificationTrace)String() string {
+	str := ""
+	if t.Previous != nil {
+		str += t.Previous.String() + "\n**** reidentified\n"
+	}
+	if len(t.Sources) > 0 {
+		str += fmt.Sprintf("**** sources: %v (fetched in %dms)\n", t.Sources, t.FetchLatencyMS)
+	}
+	if t.QNH != 0 {
+		str += fmt.Sprintf("**** altitudes corrected for QNH %.1fhPa\n", t.QNH)
+	}
+	if t.Error != "" {
+		return str + fmt.Sprintf("**** outcome: %s: %s\n", t.OutcomeCode, t.Error)
+	}
+
+	str += fmt.Sprintf("**** identification method: %s\n**** params: %s\n**** outcome: %s\n",
+		t.SelectorDesc, t.Params, t.Outcome)
+	str += fmt.Sprintf("**** outcome code: %s", t.OutcomeCode)
+	if t.Reason != "" { str += " (" + t.Reason + ")" }
+	str += "\n"
+	if t.Picked() {
+		str += fmt.Sprintf("**** procedure: %s\n", t.Procedure)
+	}
+	str += fmt.Sprintf("**** candidates: %s\n\n", CandidatesString(t.Candidates))
+	if t.Explanation != "" {
+		str += t.Explanation + "\n"
+	}
+	str += fmt.Sprintf("** Processed [%d] **\n%s\n", len(t.Filtered), AircraftToString(t.Filtered))
+	str += fmt.Sprintf("** Raw [%d] **\n%s\n", len(t.Raw), AircraftToString(t.Raw))
+	return str
+}
+
+// }}}
+// {{{ t.ToJSON, TraceFromJSON
+
+func (t IdentificationTrace)ToJSON() ([]byte, error) {
+	return json.Marshal(t)
+}
+
+func TraceFromJSON(b []byte) (IdentificationTrace, error) {
+	t := IdentificationTrace{}
+	if len(b) == 0 { return t, nil }
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, fmt.Errorf("TraceFromJSON: %v", err)
+	}
+	return t, nil
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
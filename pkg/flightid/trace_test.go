@@ -0,0 +1,82 @@
+package flightid
+
+import (
+	"testing"
+)
+
+// {{{ TestTraceOutcomes
+
+func TestTraceOutcomes(t *testing.T) {
+	ac := Aircraft{FlightNumber:"UA1", Id2:"A00001"}
+
+	tests := []struct{
+		tr   IdentificationTrace
+		code string
+	}{
+		{IdentificationTrace{}, OutcomeNothingNear},
+		{IdentificationTrace{Raw:[]Aircraft{ac}}, OutcomeAllFiltered},
+		{IdentificationTrace{Raw:[]Aircraft{ac}, Filtered:[]Aircraft{ac}, Outcome:"too busy"}, OutcomeAbstained},
+		{IdentificationTrace{OutcomeCode:OutcomePicked, Pick:"UA1"}, OutcomePicked},
+	}
+
+	for i,_ := range tests {
+		test := &tests[i]
+		test.tr.Finish()
+		if test.tr.OutcomeCode != test.code {
+			t.Errorf("[%d] expected %q, got %q", i, test.code, test.tr.OutcomeCode)
+		}
+		if !test.tr.Picked() && test.tr.Reason == "" {
+			t.Errorf("[%d] no reason given for %s", i, test.tr.OutcomeCode)
+		}
+	}
+
+	// Selector abstentions keep the selector's explanation as the reason
+	if tests[2].tr.Reason != "too busy" {
+		t.Errorf("abstention reason: %q", tests[2].tr.Reason)
+	}
+}
+
+// }}}
+// {{{ TestTraceJSON
+
+func TestTraceJSON(t *testing.T) {
+	orig := IdentificationTrace{
+		Sources: []string{"fr24","fdb"},
+		FetchLatencyMS: 230,
+		Selector: "conservative",
+		Params: DefaultParams(),
+		Filtered: []Aircraft{{FlightNumber:"UA1", Id2:"A00001", Dist3:2.5}},
+		Candidates: []Candidate{{FlightNumber:"UA1", Id2:"A00001", Score:1.0}},
+		Pick: "UA1",
+		OutcomeCode: OutcomePicked,
+	}
+	prev := orig
+	orig.Previous = &prev
+
+	b,err := orig.ToJSON()
+	if err != nil { t.Fatal(err) }
+	tr,err := TraceFromJSON(b)
+	if err != nil { t.Fatal(err) }
+
+	if tr.Selector != "conservative" || tr.FetchLatencyMS != 230 || len(tr.Sources) != 2 {
+		t.Errorf("trace didn't round trip: %#v", tr)
+	} else if len(tr.Filtered) != 1 || tr.Filtered[0].Dist3 != 2.5 || tr.Params != orig.Params {
+		t.Errorf("aircraft/params didn't round trip: %#v", tr)
+	} else if tr.Previous == nil || tr.Previous.Pick != "UA1" {
+		t.Errorf("previous trace didn't round trip")
+	}
+
+	if tr,err := TraceFromJSON(nil); err != nil || !tr.IsZero() {
+		t.Errorf("empty trace: %v, %#v", err, tr)
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,253 @@
+package flightid
+
+// The trajectory selector looks at where each aircraft has been over the last minute or so,
+// rather than where it is right now. With dense arrivals, the aircraft closest at the instant
+// the button was pressed is often not the one that passed closest (and loudest) a little earlier.
+
+import(
+	"fmt"
+	"sort"
+	"time"
+
+	fdb "github.com/skypies/flightdb"
+	"github.com/skypies/geo"
+)
+
+const(
+	KTrajectoryWindow = 60 * time.Second // How far back before the button press to look
+	KTrajectoryStep = time.Second        // How finely to sample the slant range
+	KTrajectoryHistoryStep = 15 * time.Second // Spacing of airspaces, when building from history
+)
+
+// {{{ Track, TrackHistory
+
+// A Track is a short history of trackpoints for one aircraft, in time order.
+type Track []fdb.Trackpoint
+
+func (t Track) Len() int      { return len(t) }
+func (t Track) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t Track) Less(i, j int) bool { return t[i].TimestampUTC.Before(t[j].TimestampUTC) }
+
+// PositionAt interpolates the track to time tm. Outside the track's timespan, the nearest end
+// point is extrapolated (so a one-point track is just dead reckoning).
+func (t Track)PositionAt(tm time.Time) fdb.Trackpoint {
+	if len(t) == 0 { return fdb.Trackpoint{} }
+
+	first,last := t[0], t[len(t)-1]
+	if !tm.After(first.TimestampUTC) {
+		return first.RepositionByTime(tm.Sub(first.TimestampUTC))
+	} else if !tm.Before(last.TimestampUTC) {
+		return last.RepositionByTime(tm.Sub(last.TimestampUTC))
+	}
+
+	i := sort.Search(len(t), func(i int) bool { return t[i].TimestampUTC.After(tm) })
+	from,to := t[i-1], t[i]
+	ratio := float64(tm.Sub(from.TimestampUTC)) / float64(to.TimestampUTC.Sub(from.TimestampUTC))
+
+	tp := from
+	tp.TimestampUTC = tm
+	tp.Latlong = from.Latlong.InterpolateTo(to.Latlong, ratio)
+	tp.Altitude = from.Altitude + ratio * (to.Altitude - from.Altitude)
+	tp.Heading = geo.InterpolateHeading(from.Heading, to.Heading, ratio)
+	tp.GroundSpeed = from.GroundSpeed + ratio * (to.GroundSpeed - from.GroundSpeed)
+	return tp
+}
+
+// Crosses is whether any of the track's points were inside the box, between s and e.
+func (t Track)Crosses(box geo.LatlongBox, s,e time.Time) bool {
+	for _,tp := range t {
+		if !tp.TimestampUTC.Before(s) && !tp.TimestampUTC.After(e) && box.Contains(tp.Latlong) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrackHistory holds tracks keyed by IcaoId (Aircraft.Id2).
+type TrackHistory map[string]Track
+
+// Shift moves all the tracks forward in time by d (see ShiftAirspace).
+func (h TrackHistory)Shift(d time.Duration) TrackHistory {
+	out := TrackHistory{}
+	for k,track := range h {
+		new := Track{}
+		for _,tp := range track {
+			tp.TimestampUTC = tp.TimestampUTC.Add(d)
+			new = append(new, tp)
+		}
+		out[k] = new
+	}
+	return out
+}
+
+// }}}
+// {{{ TracksFromHistory
+
+// TracksFromHistory builds tracks by fetching historical airspaces every step across the window
+// before time t; or, if the source holds tracks already, just asks it for them.
+func TracksFromHistory(hist HistoricalAirspaceSource, box geo.LatlongBox, t time.Time, window, step time.Duration) (TrackHistory, error) {
+	if th,ok := hist.(TrackHistorySource); ok {
+		return th.TracksBefore(box, t, window)
+	}
+
+	h := TrackHistory{}
+	for tm := t.Add(-1 * window); !tm.After(t); tm = tm.Add(step) {
+		as,err := hist.AirspaceAt(box, tm)
+		if err != nil {
+			return h, fmt.Errorf("TracksFromHistory/AirspaceAt(%s): %v", tm, err)
+		}
+		for _,ad := range as.Aircraft {
+			if ad.Msg == nil { continue }
+			icaoid := string(NormalizeIcaoId(ad.Msg.Icao24))
+			h[icaoid] = append(h[icaoid], fdb.TrackpointFromADSB(ad.Msg))
+		}
+	}
+	for k,_ := range h {
+		sort.Sort(h[k])
+	}
+	return h, nil
+}
+
+// }}}
+// {{{ ClosestApproach
+
+// ClosestApproach is the closest point of approach (CPA) of an aircraft to the observer.
+type ClosestApproach struct {
+	Time  time.Time
+	Dist  float64 // In KM, horizontal
+	Dist3 float64 // In KM, the slant range
+	Pos   fdb.Trackpoint
+}
+
+// FindClosestApproach samples the slant range from the observer to the track, every step
+// from s to e.
+func FindClosestApproach(t Track, pos geo.Latlong, elev float64, s,e time.Time, step time.Duration) ClosestApproach {
+	cpa := ClosestApproach{}
+	for tm := s; !tm.After(e); tm = tm.Add(step) {
+		tp := t.PositionAt(tm)
+		dist3 := pos.Dist3(tp.Latlong, tp.Altitude-elev)
+		if cpa.Time.IsZero() || dist3 < cpa.Dist3 {
+			cpa = ClosestApproach{
+				Time: tm,
+				Dist: pos.DistKM(tp.Latlong),
+				Dist3: dist3,
+				Pos: tp,
+			}
+		}
+	}
+	return cpa
+}
+
+// }}}
+
+// {{{ AlgoTrajectory
+
+// AlgoTrajectory picks the aircraft whose closest point of approach, over a window before the
+// button press, was closest. If it has a track for an aircraft (e.g. via TracksFromHistory) it
+// uses that; else it assumes the aircraft flew a straight line to its current position.
+type AlgoTrajectory struct {
+	Params      Params
+	ButtonPress time.Time    // When the button was pressed; zero means now.
+	Tracks      TrackHistory // Optional
+}
+
+func (a AlgoTrajectory)String() string {
+	return fmt.Sprintf("Closest approach over the last %s [EXPERIMENTAL]",
+		a.Params.WithDefaults().TrajectoryWindow())
+}
+
+func (a AlgoTrajectory)Parameters() Params { return a.Params }
+
+func (a AlgoTrajectory)buttonPress() time.Time {
+	if a.ButtonPress.IsZero() { return time.Now() }
+	return a.ButtonPress
+}
+
+// TrackFor returns the track we have for the aircraft, plus its current position.
+func (a AlgoTrajectory)TrackFor(ac Aircraft) Track {
+	track := Track{}
+	if a.Tracks != nil {
+		track = append(track, a.Tracks[ac.Id2]...)
+	}
+	current := ac.Trackpoint()
+	if len(track) == 0 || current.TimestampUTC.After(track[len(track)-1].TimestampUTC) {
+		track = append(track, current)
+	}
+	sort.Sort(track)
+	return track
+}
+
+// ClosestApproaches finds the CPA for each aircraft, over the window.
+func (a AlgoTrajectory)ClosestApproaches(pos geo.Latlong, elev float64, in []Aircraft) []ClosestApproach {
+	e := a.buttonPress()
+	s := e.Add(-1 * a.Params.WithDefaults().TrajectoryWindow())
+	ret := []ClosestApproach{}
+	for _,ac := range in {
+		ret = append(ret, FindClosestApproach(a.TrackFor(ac), pos, elev, s, e, KTrajectoryStep))
+	}
+	return ret
+}
+
+// }}}
+// {{{ a.Score
+
+// Score implements Scorer, based on the slant range at the CPA.
+func (a AlgoTrajectory)Score(pos geo.Latlong, elev float64, in []Aircraft) []float64 {
+	scores := []float64{}
+	for _,cpa := range a.ClosestApproaches(pos, elev, in) {
+		scores = append(scores, InverseSquareScore(cpa.Dist3))
+	}
+	return scores
+}
+
+// }}}
+// {{{ a.Explain
+
+// Explain implements Explainer, listing the CPA of each aircraft.
+func (a AlgoTrajectory)Explain(pos geo.Latlong, elev float64, in []Aircraft) string {
+	tPress := a.buttonPress()
+	str := fmt.Sprintf("** Closest approaches, over %s before button press **\n",
+		a.Params.WithDefaults().TrajectoryWindow())
+	for i,cpa := range a.ClosestApproaches(pos, elev, in) {
+		str += fmt.Sprintf(" %-8.8s CPA %4.1fKM (%4.1fKM 2D, %6.0fft) at %4.0fs; now %4.1fKM (%d trackpoints)\n",
+			in[i].BestIdent(), cpa.Dist3, cpa.Dist, cpa.Pos.Altitude,
+			cpa.Time.Sub(tPress).Seconds(), in[i].Dist3, len(a.TrackFor(in[i])))
+	}
+	return str
+}
+
+// }}}
+// {{{ a.Identify
+
+func (a AlgoTrajectory)Identify(pos geo.Latlong, elev float64, in []Aircraft) (*Aircraft,string) {
+	if len(in) == 0 {
+		return nil, "nothing in list"
+	}
+
+	p := a.Params.WithDefaults()
+	cpas := a.ClosestApproaches(pos, elev, in)
+
+	best := 0
+	for i,cpa := range cpas {
+		if cpa.Dist3 < cpas[best].Dist3 { best = i }
+	}
+
+	tAgo := a.buttonPress().Sub(cpas[best].Time).Seconds()
+	if cpas[best].Dist3 >= p.MaxDistKM {
+		return nil, fmt.Sprintf("not picked; closest approach was too far away (%.1fKM, >%.0fKM)",
+			cpas[best].Dist3, p.MaxDistKM)
+	}
+
+	return &in[best], fmt.Sprintf("picked closest approach (%.1fKM away, %.0fs before button press)",
+		cpas[best].Dist3, tAgo)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,70 @@
+package flightid
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	fdb "github.com/skypies/flightdb"
+	"github.com/skypies/geo"
+)
+
+// {{{ TestTrajectorySelector
+
+func TestTrajectorySelector(t *testing.T) {
+	pos := geo.Latlong{Lat:37.060312, Long:-121.990814}
+	now := time.Now().Truncate(time.Second)
+
+	tp := func(ll geo.Latlong, ago time.Duration) fdb.Trackpoint {
+		return fdb.Trackpoint{Latlong:ll, Altitude:3000, TimestampUTC:now.Add(-1 * ago)}
+	}
+	ac := func(id2 string, ll geo.Latlong) Aircraft {
+		return Aircraft{Id2:id2, FlightNumber:id2, Lat:ll.Lat, Long:ll.Long, Altitude:3000,
+			Epoch:float64(now.Unix()), Dist3:pos.Dist3(ll, 3000)}
+	}
+
+	// UA1 flew right overhead 30s ago, and is now 4KM east. UA2 has loitered 2.5KM to the north;
+	// it is the closest right now, but never came as close as UA1 did.
+	west,east,north := pos.MoveKM(270, 4), pos.MoveKM(90, 4), pos.MoveKM(0, 2.5)
+	tracks := TrackHistory{
+		"UA1": Track{tp(west, 60*time.Second), tp(pos, 30*time.Second)},
+		"UA2": Track{tp(north, 60*time.Second), tp(north, 30*time.Second)},
+	}
+	in := []Aircraft{ac("UA2", north), ac("UA1", east)}
+
+	algo := AlgoTrajectory{Params:DefaultParams(), ButtonPress:now, Tracks:tracks}
+
+	cpas := algo.ClosestApproaches(pos, 0, in)
+	if cpas[1].Dist3 > 1.0 || now.Sub(cpas[1].Time) != 30*time.Second {
+		t.Errorf("bad CPA for UA1: %.1fKM at %s", cpas[1].Dist3, now.Sub(cpas[1].Time))
+	}
+
+	if oh,str := algo.Identify(pos, 0, in); oh == nil || oh.FlightNumber != "UA1" {
+		t.Errorf("expected UA1, got %v (%s)", oh, str)
+	}
+
+	if cands := ScoreAircraft(algo, pos, 0, in); cands[0].FlightNumber != "UA1" {
+		t.Errorf("expected UA1 to score highest, got %s", CandidatesString(cands))
+	}
+
+	if str := algo.Explain(pos, 0, in); !strings.Contains(str, "CPA") {
+		t.Errorf("explanation missing CPAs:\n%s", str)
+	}
+
+	// Shifting the tracks moves the CPA, but not the distance
+	algo.Tracks = tracks.Shift(time.Hour)
+	algo.ButtonPress = now.Add(time.Hour)
+	if cpa := algo.ClosestApproaches(pos, 0, in)[1]; cpa.Dist3 > 1.0 {
+		t.Errorf("shifted tracks lost the CPA: %.1fKM", cpa.Dist3)
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,722 @@
+package memds
+
+// Package memds is an in-memory implementation of the util/gcp/ds DatastoreProvider
+// interface. It exists so that complaintdb (and the things built on it) can be tested, and
+// run offline, without any access to cloud datastore.
+//
+// Keys are real *datastore.Key values, and entities are (de)serialized with
+// datastore.SaveStruct and datastore.LoadStruct, so struct tags, flattening of embedded
+// structs, nested entities and field mismatches all behave as they do against the real
+// thing. Queries support kind, ancestor, filters (=,<,<=,>,>=,!=), a single sort order,
+// projections (with distinct), keys-only, and limits. Like the real thing, unindexed
+// (`datastore:",noindex"`) properties can't be filtered, sorted or projected on.
+
+import(
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"golang.org/x/net/context"
+
+	"github.com/skypies/util/gcp/ds"
+)
+
+var Debug = false
+
+// {{{ Provider{}, NewProvider()
+
+// Provider implements the ds.DatastoreProvider interface, entirely in memory. It is safe for
+// concurrent use.
+type Provider struct {
+	mu       sync.Mutex
+	entities map[string]datastore.Entity // Keyed by key.Encode(); each entity holds its key
+	nextID   int64                       // For completing incomplete keys
+}
+
+func NewProvider() *Provider {
+	return &Provider{
+		entities: map[string]datastore.Entity{},
+		nextID: 1,
+	}
+}
+
+// Len returns how many entities are currently stored.
+func (p *Provider)Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.entities)
+}
+
+// }}}
+
+// {{{ keyer helpers
+
+func unpackKeyer(in ds.Keyer) *datastore.Key {
+	if in == nil { return nil }
+	return in.(*datastore.Key)
+}
+
+// isAncestor returns true if anc is k, or one of k's parents.
+func isAncestor(anc, k *datastore.Key) bool {
+	for ; k != nil; k = k.Parent {
+		if k.Equal(anc) { return true }
+	}
+	return false
+}
+
+// Orders keys the way datastore does; by path, from the root down, with IDs before names.
+func keyPath(k *datastore.Key) []*datastore.Key {
+	path := []*datastore.Key{}
+	for ; k != nil; k = k.Parent {
+		path = append([]*datastore.Key{k}, path...)
+	}
+	return path
+}
+func compareKeys(a,b *datastore.Key) int {
+	pa,pb := keyPath(a), keyPath(b)
+	for i:=0; i<len(pa) && i<len(pb); i++ {
+		x,y := pa[i], pb[i]
+		if x.Kind != y.Kind { return strings.Compare(x.Kind, y.Kind) }
+		if (x.Name == "") != (y.Name == "") {
+			if x.Name == "" { return -1 } // IDs sort before names
+			return 1
+		}
+		if x.Name != "" {
+			if x.Name != y.Name { return strings.Compare(x.Name, y.Name) }
+		} else if x.ID != y.ID {
+			if x.ID < y.ID { return -1 }
+			return 1
+		}
+	}
+	return len(pa) - len(pb)
+}
+
+// }}}
+// {{{ property helpers
+
+// cloneValue deep copies the mutable parts of a property value, so that callers can't
+// reach into our storage via things they've loaded (or saved).
+func cloneValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case []byte:
+		return append([]byte{}, x...)
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i,elem := range x { out[i] = cloneValue(elem) }
+		return out
+	case *datastore.Entity:
+		if x == nil { return x }
+		return &datastore.Entity{Key: x.Key, Properties: cloneProps(x.Properties)}
+	case time.Time:
+		return x.Truncate(time.Microsecond) // Datastore's resolution; also strips monotonic clock
+	}
+	return v
+}
+func cloneProps(in []datastore.Property) []datastore.Property {
+	out := make([]datastore.Property, len(in))
+	for i,prop := range in {
+		out[i] = prop
+		out[i].Value = cloneValue(prop.Value)
+	}
+	return out
+}
+
+// lookupProp finds the values for a (possibly dotted) property name, descending into nested
+// entities as needed. Multi-valued properties yield multiple values. Unindexed properties
+// are not found, as they are invisible to queries.
+func lookupProp(props []datastore.Property, name string) ([]interface{}, bool) {
+	head,tail := name, ""
+	if i := strings.Index(name, "."); i >= 0 {
+		head,tail = name[:i], name[i+1:]
+	}
+
+	for _,prop := range props {
+		if prop.Name == name && tail != "" && !prop.NoIndex {
+			// A flattened property, already carrying the dotted name
+			return expandValue(prop.Value), true
+		}
+		if prop.Name != head { continue }
+
+		if tail == "" {
+			if prop.NoIndex { return nil, false }
+			return expandValue(prop.Value), true
+		}
+
+		vals := []interface{}{}
+		for _,v := range expandValue(prop.Value) {
+			if e,ok := v.(*datastore.Entity); ok && e != nil {
+				if sub,found := lookupProp(e.Properties, tail); found {
+					vals = append(vals, sub...)
+				}
+			}
+		}
+		return vals, len(vals)>0
+	}
+	return nil, false
+}
+
+func expandValue(v interface{}) []interface{} {
+	if arr,ok := v.([]interface{}); ok { return arr }
+	return []interface{}{v}
+}
+
+// }}}
+// {{{ value comparison
+
+// Datastore orders values of different types by type, then by value within a type.
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:                 return 0
+	case int64, float64:      return 1
+	case time.Time:           return 2
+	case bool:                return 3
+	case []byte:              return 4
+	case string:              return 5
+	case datastore.GeoPoint:  return 6
+	case *datastore.Key:      return 7
+	}
+	return 8
+}
+
+// normalizeValue maps the kinds of values callers put in filters onto the kinds of values
+// datastore stores.
+func normalizeValue(v interface{}) interface{} {
+	if v == nil { return nil }
+	if _,ok := v.(time.Time); ok { return cloneValue(v) }
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	}
+	return v
+}
+
+func compareValues(a,b interface{}) int {
+	a,b = normalizeValue(a), normalizeValue(b)
+	if ra,rb := typeRank(a), typeRank(b); ra != rb {
+		return ra - rb
+	}
+
+	switch x := a.(type) {
+	case int64:
+		if y,ok := b.(int64); ok {
+			if x < y { return -1 } else if x > y { return 1 }
+			return 0
+		}
+		return compareFloats(float64(x), b.(float64))
+	case float64:
+		if y,ok := b.(int64); ok { return compareFloats(x, float64(y)) }
+		return compareFloats(x, b.(float64))
+	case time.Time:
+		y := b.(time.Time)
+		if x.Before(y) { return -1 } else if x.After(y) { return 1 }
+		return 0
+	case bool:
+		y := b.(bool)
+		if x == y { return 0 } else if !x { return -1 }
+		return 1
+	case []byte:
+		return strings.Compare(string(x), string(b.([]byte)))
+	case string:
+		return strings.Compare(x, b.(string))
+	case datastore.GeoPoint:
+		y := b.(datastore.GeoPoint)
+		if c := compareFloats(x.Lat, y.Lat); c != 0 { return c }
+		return compareFloats(x.Lng, y.Lng)
+	case *datastore.Key:
+		return compareKeys(x, b.(*datastore.Key))
+	}
+	return 0
+}
+
+func compareFloats(x,y float64) int {
+	if x < y { return -1 } else if x > y { return 1 }
+	return 0
+}
+
+// }}}
+
+// {{{ filters
+
+type filter struct {
+	field string
+	op    string
+	val   interface{}
+}
+
+// Parses the cloud datastore style of filter string, e.g. "Timestamp >= ", "DataSharing ="
+func parseFilter(f ds.Filter) (filter, error) {
+	str := strings.TrimSpace(f.Field)
+	for _,op := range []string{">=", "<=", "!=", "=", ">", "<"} {
+		if strings.HasSuffix(str, op) {
+			field := strings.TrimSpace(strings.TrimSuffix(str, op))
+			if field == "" { break }
+			return filter{field:field, op:op, val:f.Value}, nil
+		}
+	}
+	return filter{}, fmt.Errorf("memds: bad filter '%s'", f.Field)
+}
+
+func (f filter)matchesValue(v interface{}) bool {
+	c := compareValues(v, f.val)
+	switch f.op {
+	case "=":  return c == 0
+	case "!=": return c != 0
+	case "<":  return c <  0
+	case "<=": return c <= 0
+	case ">":  return c >  0
+	case ">=": return c >= 0
+	}
+	return false
+}
+
+// Multi-valued properties match if any of their values match.
+func (f filter)matches(props []datastore.Property) bool {
+	vals,found := lookupProp(props, f.field)
+	if !found { return false }
+	for _,v := range vals {
+		if f.matchesValue(v) { return true }
+	}
+	return false
+}
+
+// }}}
+// {{{ dst reflection helpers
+
+// Both GetAll's *[]S and GetMulti's []S accept elements of type S or *S.
+func loadInto(elem reflect.Value, props []datastore.Property) error {
+	if elem.Kind() == reflect.Ptr {
+		if elem.IsNil() {
+			elem.Set(reflect.New(elem.Type().Elem()))
+		}
+		return datastore.LoadStruct(elem.Interface(), props)
+	}
+	return datastore.LoadStruct(elem.Addr().Interface(), props)
+}
+
+// mergeLoadErr keeps track of field mismatches, which are non-fatal; anything else is.
+func mergeLoadErr(sofar, err error) (error, bool) {
+	if err == nil { return sofar, false }
+	if _,ok := err.(*datastore.ErrFieldMismatch); ok { return ds.ErrFieldMismatch, false }
+	return err, true
+}
+
+// }}}
+
+// {{{ p.GetAll
+
+func (p *Provider)GetAll(ctx context.Context, q *ds.Query, dst interface{}) ([]ds.Keyer, error) {
+	filters := []filter{}
+	for _,f := range q.Filters {
+		if parsed,err := parseFilter(f); err != nil {
+			return nil, fmt.Errorf("GetAll{memds}: %v\nQuery: %s", err, q)
+		} else {
+			filters = append(filters, parsed)
+		}
+	}
+
+	orderField, desc := strings.TrimSpace(q.OrderStr), false
+	if strings.HasPrefix(orderField, "-") {
+		orderField, desc = strings.TrimSpace(orderField[1:]), true
+	}
+
+	var anc *datastore.Key
+	if q.AncestorKeyer != nil { anc = unpackKeyer(q.AncestorKeyer) }
+
+	// Grab copies of matching entities, so we can release the lock before loading
+	p.mu.Lock()
+	results := []datastore.Entity{}
+	for _,e := range p.entities {
+		if q.Kind != "" && e.Key.Kind != q.Kind { continue }
+		if anc != nil && !isAncestor(anc, e.Key) { continue }
+		matched := true
+		for _,f := range filters {
+			if !f.matches(e.Properties) { matched = false; break }
+		}
+		if !matched { continue }
+		if orderField != "" {
+			if _,found := lookupProp(e.Properties, orderField); !found { continue }
+		}
+		results = append(results, datastore.Entity{Key:e.Key, Properties:cloneProps(e.Properties)})
+	}
+	p.mu.Unlock()
+
+	sort.Slice(results, func(i,j int) bool {
+		if orderField != "" {
+			vi,_ := lookupProp(results[i].Properties, orderField)
+			vj,_ := lookupProp(results[j].Properties, orderField)
+			if c := compareValues(vi[0], vj[0]); c != 0 {
+				if desc { return c > 0 }
+				return c < 0
+			}
+		}
+		return compareKeys(results[i].Key, results[j].Key) < 0
+	})
+
+	if len(q.ProjectFields) > 0 {
+		results = project(results, q.ProjectFields, q.DistinctVals)
+	}
+
+	if q.LimitVal > 0 && len(results) > q.LimitVal {
+		results = results[:q.LimitVal]
+	}
+
+	keyers := []ds.Keyer{}
+	for _,e := range results {
+		keyers = append(keyers, ds.Keyer(e.Key))
+	}
+
+	if q.KeysOnlyVal || dst == nil {
+		return keyers, nil
+	}
+
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("GetAll{memds}: dst must be a pointer to a slice, not %T", dst)
+	}
+	slice := dv.Elem()
+
+	var loadErr error
+	for _,e := range results {
+		elem := reflect.New(slice.Type().Elem()).Elem()
+		err,fatal := mergeLoadErr(loadErr, loadInto(elem, e.Properties))
+		if fatal {
+			return nil, fmt.Errorf("GetAll{memds}: %v\nQuery: %s", err, q)
+		}
+		loadErr = err
+		slice.Set(reflect.Append(slice, elem))
+	}
+
+	return keyers, loadErr
+}
+
+// project reduces each entity down to the projected properties (with flattened, dotted
+// names, as the real datastore returns them). Entities lacking any of the properties are
+// dropped. Multi-valued properties yield one result per value.
+func project(in []datastore.Entity, fields []string, distinct bool) []datastore.Entity {
+	out := []datastore.Entity{}
+	seen := map[string]bool{}
+
+	for _,e := range in {
+		rows := [][]datastore.Property{{}}
+		for _,field := range fields {
+			vals,found := lookupProp(e.Properties, field)
+			if !found { rows = nil; break }
+			newRows := [][]datastore.Property{}
+			for _,row := range rows {
+				for _,v := range vals {
+					newRow := append(append([]datastore.Property{}, row...),
+						datastore.Property{Name:field, Value:v})
+					newRows = append(newRows, newRow)
+				}
+			}
+			rows = newRows
+		}
+
+		for _,row := range rows {
+			if distinct {
+				sig := fmt.Sprintf("%#v", row)
+				if seen[sig] { continue }
+				seen[sig] = true
+			}
+			out = append(out, datastore.Entity{Key:e.Key, Properties:row})
+		}
+	}
+
+	return out
+}
+
+// }}}
+// {{{ p.Get, p.GetMulti
+
+func (p *Provider)Get(ctx context.Context, keyer ds.Keyer, dst interface{}) error {
+	k := unpackKeyer(keyer)
+	if k == nil || k.Incomplete() {
+		return fmt.Errorf("Get{memds}: incomplete key")
+	}
+
+	p.mu.Lock()
+	e,exists := p.entities[k.Encode()]
+	var props []datastore.Property
+	if exists { props = cloneProps(e.Properties) }
+	p.mu.Unlock()
+
+	if !exists {
+		return ds.ErrNoSuchEntity
+	}
+
+	err := datastore.LoadStruct(dst, props)
+	if _,ok := err.(*datastore.ErrFieldMismatch); ok {
+		return ds.ErrFieldMismatch
+	}
+	return err
+}
+
+// GetMulti loads into dst, which should be a slice of the same length as keyers. If any
+// entity is missing it returns ds.ErrNoSuchEntity, but still loads everything else.
+func (p *Provider)GetMulti(ctx context.Context, keyers []ds.Keyer, dst interface{}) error {
+	slice := reflect.ValueOf(dst)
+	if slice.Kind() != reflect.Slice || slice.Len() != len(keyers) {
+		return fmt.Errorf("GetMulti{memds}: dst must be a slice of len %d, not %T", len(keyers), dst)
+	}
+
+	var retErr error
+	for i,keyer := range keyers {
+		k := unpackKeyer(keyer)
+		if k == nil || k.Incomplete() {
+			return fmt.Errorf("GetMulti{memds}: incomplete key")
+		}
+
+		p.mu.Lock()
+		e,exists := p.entities[k.Encode()]
+		var props []datastore.Property
+		if exists { props = cloneProps(e.Properties) }
+		p.mu.Unlock()
+
+		if !exists {
+			retErr = ds.ErrNoSuchEntity
+			continue
+		}
+
+		if err,fatal := mergeLoadErr(nil, loadInto(slice.Index(i), props)); fatal {
+			return fmt.Errorf("GetMulti{memds}: %v", err)
+		} else if err != nil && retErr == nil {
+			retErr = err
+		}
+	}
+
+	return retErr
+}
+
+// }}}
+// {{{ p.Put, p.PutMulti
+
+func (p *Provider)Put(ctx context.Context, keyer ds.Keyer, src interface{}) (ds.Keyer, error) {
+	k := unpackKeyer(keyer)
+	if k == nil {
+		return nil, fmt.Errorf("Put{memds}: nil key")
+	}
+
+	// SaveStruct wants a pointer; be as forgiving as the real API, and accept a struct too
+	if v := reflect.ValueOf(src); v.Kind() == reflect.Struct {
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		src = ptr.Interface()
+	}
+	props,err := datastore.SaveStruct(src)
+	if err != nil {
+		return nil, fmt.Errorf("Put{memds}: %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k.Incomplete() {
+		completed := *k
+		completed.ID = p.nextID
+		p.nextID++
+		k = &completed
+	}
+
+	p.entities[k.Encode()] = datastore.Entity{Key:k, Properties:cloneProps(props)}
+
+	return ds.Keyer(k), nil
+}
+
+func (p *Provider)PutMulti(ctx context.Context, keyers []ds.Keyer, src interface{}) ([]ds.Keyer, error) {
+	slice := reflect.ValueOf(src)
+	if slice.Kind() != reflect.Slice || slice.Len() != len(keyers) {
+		return nil, fmt.Errorf("PutMulti{memds}: src must be a slice of len %d, not %T", len(keyers), src)
+	}
+
+	out := []ds.Keyer{}
+	for i,keyer := range keyers {
+		elem := slice.Index(i)
+		if elem.Kind() != reflect.Ptr { elem = elem.Addr() }
+		if k,err := p.Put(ctx, keyer, elem.Interface()); err != nil {
+			return nil, err
+		} else {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+// }}}
+// {{{ p.Delete, p.DeleteMulti
+
+// Deleting a non-existent entity is not an error, as with the real datastore.
+func (p *Provider)Delete(ctx context.Context, keyer ds.Keyer) error {
+	k := unpackKeyer(keyer)
+	if k == nil || k.Incomplete() {
+		return fmt.Errorf("Delete{memds}: incomplete key")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.entities, k.Encode())
+	return nil
+}
+
+func (p *Provider)DeleteMulti(ctx context.Context, keyers []ds.Keyer) error {
+	for _,keyer := range keyers {
+		if err := p.Delete(ctx, keyer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// }}}
+// {{{ p.{New*Key,DecodeKey,KeyParent,KeyName}
+
+func (p *Provider)NewIncompleteKey(ctx context.Context, kind string, root ds.Keyer) ds.Keyer {
+	return ds.Keyer(datastore.IncompleteKey(kind, unpackKeyer(root)))
+}
+func (p *Provider)NewNameKey(ctx context.Context, kind, name string, root ds.Keyer) ds.Keyer {
+	return ds.Keyer(datastore.NameKey(kind, name, unpackKeyer(root)))
+}
+func (p *Provider)NewIDKey(ctx context.Context, kind string, id int64, root ds.Keyer) ds.Keyer {
+	return ds.Keyer(datastore.IDKey(kind, id, unpackKeyer(root)))
+}
+
+func (p *Provider)DecodeKey(encoded string) (ds.Keyer, error) {
+	key, err := datastore.DecodeKey(encoded)
+	return ds.Keyer(key), err
+}
+func (p *Provider)KeyParent(in ds.Keyer) ds.Keyer {
+	if parentKey := unpackKeyer(in).Parent; parentKey != nil {
+		return ds.Keyer(parentKey)
+	}
+	return nil
+}
+func (p *Provider)KeyName(in ds.Keyer) string { return unpackKeyer(in).Name }
+
+// }}}
+// {{{ p.HTTPClient, p.{Debugf,Infof,Errorf,Warningf,Criticalf}
+
+func (p *Provider)HTTPClient(ctx context.Context) *http.Client {
+	return &http.Client{}
+}
+
+func (p *Provider)Debugf(ctx context.Context, format string, args ...interface{}) {
+	if Debug {log.Printf(format, args...)}
+}
+func (p *Provider)Infof(ctx context.Context, format string,args ...interface{}) {
+	log.Printf(format, args...)
+}
+func (p *Provider)Errorf(ctx context.Context, format string,args ...interface{}) {
+	log.Printf(format, args...)
+}
+func (p *Provider)Warningf(ctx context.Context, format string,args ...interface{}) {
+	log.Printf(format, args...)
+}
+func (p *Provider)Criticalf(ctx context.Context, format string,args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+// }}}
+
+// {{{ p.Save, p.Load
+
+// The concrete types that can appear inside datastore.Property.Value
+func init() {
+	gob.Register(time.Time{})
+	gob.Register(datastore.GeoPoint{})
+	gob.Register(&datastore.Key{})
+	gob.Register(&datastore.Entity{})
+	gob.Register([]interface{}{})
+}
+
+type snapshot struct {
+	NextID   int64
+	Entities []datastore.Entity
+}
+
+// Save writes the full contents of the datastore to w, in gob format.
+func (p *Provider)Save(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	snap := snapshot{NextID: p.nextID}
+	for _,e := range p.entities {
+		snap.Entities = append(snap.Entities, e)
+	}
+	sort.Slice(snap.Entities, func(i,j int) bool {
+		return compareKeys(snap.Entities[i].Key, snap.Entities[j].Key) < 0
+	})
+
+	return gob.NewEncoder(w).Encode(snap)
+}
+
+// Load replaces the contents of the datastore with a snapshot previously written by Save.
+func (p *Provider)Load(r io.Reader) error {
+	snap := snapshot{}
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("Load{memds}: %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entities = map[string]datastore.Entity{}
+	p.nextID = snap.NextID
+	for _,e := range snap.Entities {
+		p.entities[e.Key.Encode()] = e
+	}
+	return nil
+}
+
+// }}}
+// {{{ LoadFile, p.SaveFile
+
+// LoadFile returns a provider populated from the snapshot file at path; if there is no such
+// file, the provider starts out empty.
+func LoadFile(path string) (*Provider, error) {
+	p := NewProvider()
+	f,err := os.Open(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return p, p.Load(f)
+}
+
+// SaveFile writes a snapshot to path, which can later be read back with LoadFile.
+func (p *Provider)SaveFile(path string) error {
+	f,err := os.Create(path)
+	if err != nil { return err }
+	if err := p.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
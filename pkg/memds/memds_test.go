@@ -0,0 +1,201 @@
+package memds
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/skypies/util/gcp/ds"
+)
+
+type inner struct {
+	Zip   string
+	Notes string `datastore:",noindex"`
+}
+type thing struct {
+	Name  string
+	N     int
+	T     time.Time
+	Tags  []string
+	Inner inner
+}
+type smallThing struct {
+	Name string
+}
+
+// {{{ populate
+
+func populate(t *testing.T, p *Provider) (context.Context, ds.Keyer) {
+	ctx := context.Background()
+	root := p.NewNameKey(ctx, "Root", "r1", nil)
+	tm := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	things := []thing{
+		{Name:"a", N:1, T:tm,                      Tags:[]string{"x","y"}, Inner:inner{"94301","n1"}},
+		{Name:"b", N:2, T:tm.Add(time.Minute),     Tags:[]string{"y"},     Inner:inner{"94301","n2"}},
+		{Name:"c", N:3, T:tm.Add(2*time.Minute),   Tags:[]string{"z"},     Inner:inner{"95060","n3"}},
+	}
+	for i,th := range things {
+		parent := root
+		if i == 2 { parent = nil }
+		if _,err := p.Put(ctx, p.NewIncompleteKey(ctx, "Thing", parent), &th); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ctx, root
+}
+
+// }}}
+
+// {{{ TestQueries
+
+func TestQueries(t *testing.T) {
+	p := NewProvider()
+	ctx,root := populate(t, p)
+
+	run := func(expected int, q *ds.Query) []thing {
+		results := []thing{}
+		if _,err := p.GetAll(ctx, q, &results); err != nil {
+			t.Fatalf("query %s: %v", q, err)
+		} else if len(results) != expected {
+			t.Errorf("query %s: expected %d, saw %d", q, expected, len(results))
+		}
+		return results
+	}
+
+	run(3, ds.NewQuery("Thing"))
+	run(0, ds.NewQuery("NoSuchKind"))
+	run(2, ds.NewQuery("Thing").Ancestor(root))
+	run(2, ds.NewQuery("Thing").Filter("N >= ", 2))
+	run(1, ds.NewQuery("Thing").Filter("N >", 1).Filter("N <", 3))
+	run(2, ds.NewQuery("Thing").Filter("Tags =", "y"))
+	run(2, ds.NewQuery("Thing").Filter("Inner.Zip = ", "94301"))
+	run(0, ds.NewQuery("Thing").Filter("Inner.Notes = ", "n1")) // noindex
+	run(1, ds.NewQuery("Thing").Filter("T > ", time.Date(2020,1,1,0,1,0,0,time.UTC)))
+	run(2, ds.NewQuery("Thing").Limit(2))
+	run(3, ds.NewQuery("Thing").Limit(-1))
+
+	if r := run(3, ds.NewQuery("Thing").Order("-N")); r != nil && r[0].Name != "c" {
+		t.Errorf("Order(-N) gave %q first", r[0].Name)
+	}
+	if r := run(3, ds.NewQuery("Thing").Order("T")); r != nil && r[0].Name != "a" {
+		t.Errorf("Order(T) gave %q first", r[0].Name)
+	}
+
+	if r := run(3, ds.NewQuery("Thing").Project("Inner.Zip","Name").Order("Name")); r != nil {
+		if r[0].Inner.Zip != "94301" || r[0].N != 0 {
+			t.Errorf("projection gave %+v", r[0])
+		}
+	}
+	run(2, ds.NewQuery("Thing").Project("Inner.Zip").Distinct())
+
+	if keyers,err := p.GetAll(ctx, ds.NewQuery("Thing").KeysOnly(), nil); err != nil {
+		t.Error(err)
+	} else if len(keyers) != 3 {
+		t.Errorf("KeysOnly returned %d keys", len(keyers))
+	}
+
+	if _,err := p.GetAll(ctx, ds.NewQuery("Thing").Filter("N", 1), nil); err == nil {
+		t.Errorf("filter without an operator should have failed")
+	}
+}
+
+// }}}
+// {{{ TestGetPutDelete
+
+func TestGetPutDelete(t *testing.T) {
+	p := NewProvider()
+	ctx,_ := populate(t, p)
+
+	keyers,err := p.GetAll(ctx, ds.NewQuery("Thing").Order("N"), nil)
+	if err != nil { t.Fatal(err) }
+
+	th := thing{}
+	if err := p.Get(ctx, keyers[0], &th); err != nil {
+		t.Errorf("Get: %v", err)
+	} else if th.Name != "a" || th.Inner.Notes != "n1" || len(th.Tags) != 2 {
+		t.Errorf("Get returned %+v", th)
+	}
+
+	// Loading into a struct that lacks fields is a mismatch, but the rest still loads
+	small := smallThing{}
+	if err := p.Get(ctx, keyers[0], &small); err != ds.ErrFieldMismatch {
+		t.Errorf("Get into smaller struct: expected ErrFieldMismatch, got %v", err)
+	} else if small.Name != "a" {
+		t.Errorf("Get into smaller struct didn't load Name")
+	}
+	smalls := []smallThing{}
+	if _,err := p.GetAll(ctx, ds.NewQuery("Thing"), &smalls); err != ds.ErrFieldMismatch {
+		t.Errorf("GetAll into smaller struct: expected ErrFieldMismatch, got %v", err)
+	} else if len(smalls) != 3 {
+		t.Errorf("GetAll into smaller struct loaded %d", len(smalls))
+	}
+
+	// Overwrite via an existing key
+	th.N = 100
+	if _,err := p.Put(ctx, keyers[0], &th); err != nil { t.Fatal(err) }
+	multi := make([]*thing, len(keyers))
+	if err := p.GetMulti(ctx, keyers, multi); err != nil {
+		t.Errorf("GetMulti: %v", err)
+	} else if multi[0].N != 100 {
+		t.Errorf("Put didn't overwrite: %+v", multi[0])
+	}
+
+	if err := p.Delete(ctx, keyers[0]); err != nil { t.Fatal(err) }
+	if err := p.Get(ctx, keyers[0], &th); err != ds.ErrNoSuchEntity {
+		t.Errorf("Get after Delete: expected ErrNoSuchEntity, got %v", err)
+	}
+	if err := p.GetMulti(ctx, keyers, make([]thing, len(keyers))); err != ds.ErrNoSuchEntity {
+		t.Errorf("GetMulti after Delete: expected ErrNoSuchEntity, got %v", err)
+	}
+	if p.Len() != 2 {
+		t.Errorf("expected 2 entities after delete, found %d", p.Len())
+	}
+
+	// Keys should survive the round trip through their encoded form
+	if k,err := p.DecodeKey(keyers[1].Encode()); err != nil {
+		t.Error(err)
+	} else if p.KeyName(p.KeyParent(k)) != "r1" {
+		t.Errorf("decoded key lost its parent: %v", k)
+	}
+}
+
+// }}}
+// {{{ TestSaveLoad
+
+func TestSaveLoad(t *testing.T) {
+	p := NewProvider()
+	ctx,root := populate(t, p)
+
+	var buf bytes.Buffer
+	if err := p.Save(&buf); err != nil { t.Fatal(err) }
+
+	p2 := NewProvider()
+	if err := p2.Load(&buf); err != nil { t.Fatal(err) }
+
+	results := []thing{}
+	if _,err := p2.GetAll(ctx, ds.NewQuery("Thing").Ancestor(root).Order("T"), &results); err != nil {
+		t.Fatal(err)
+	} else if len(results) != 2 || results[1].Inner.Notes != "n2" {
+		t.Errorf("after Load, got %+v", results)
+	}
+
+	// New keys shouldn't collide with loaded ones
+	if _,err := p2.Put(ctx, p2.NewIncompleteKey(ctx, "Thing", nil), &thing{Name:"d"}); err != nil {
+		t.Fatal(err)
+	} else if p2.Len() != 4 {
+		t.Errorf("expected 4 entities, found %d", p2.Len())
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,117 @@
+package noiseclass
+
+// A deliberately simple noise model: a reference level per class of aircraft at 1000ft, reduced
+// by spherical spreading and atmospheric absorption out to the observer. It ignores terrain,
+// weather, directivity and flap/gear settings, so it is good to a few dB at best; the point is to
+// have a physical quantity to put next to the complaint counts.
+
+import(
+	"fmt"
+	"math"
+)
+
+const(
+	KReferenceDistKM = 0.3048          // The reference levels are at 1000ft slant range
+	KMinModelDistKM = 0.1              // Don't let the spreading term blow up
+	KAbsorptionDBPerKM = 1.5           // Rough A-weighted atmospheric absorption (~1-2dB/KM)
+	KFloorDB = 20.0                    // Estimates don't go below this; it's quieter than ambient
+	KClimbThrustDB = 3.0               // Departures at climb power are louder ...
+	KClimbThresholdFPM = 500.0         // ... which we spot by the vertical speed
+)
+
+// Reference levels (dBA at 1000ft, roughly the LAmax at flyover power) by engine and weight.
+var kReferenceDB = map[string]map[string]float64{
+	"jet":        {"super":95, "heavy":92, "large":87, "small":82},
+	"turboprop":  {"large":84, "small":78},
+	"piston":     {"large":76, "small":73},
+	"helicopter": {"large":85, "small":82},
+}
+
+// Newer certification chapters are quieter; relative to a chapter 3 jet.
+var kChapterAdjustmentDB = map[int]float64{2:4, 3:0, 4:-3, 14:-6}
+
+// KAssumedInfo is used when we don't know the equipment type.
+var KAssumedInfo = Info{Engine:"jet", Engines:2, WeightClass:"large", Chapter:4}
+
+// {{{ i.ReferenceDB
+
+// ReferenceDB is the modelled level at KReferenceDistKM.
+func (i Info)ReferenceDB() float64 {
+	byWeight,exists := kReferenceDB[i.Engine]
+	if !exists { byWeight = kReferenceDB["jet"] }
+	db,exists := byWeight[i.WeightClass]
+	if !exists { db = kReferenceDB["jet"]["large"] }
+
+	if i.Engine == "jet" {
+		db += kChapterAdjustmentDB[i.Chapter]
+	}
+	return db
+}
+
+// }}}
+
+// {{{ Estimate
+
+// Estimate is the modelled sound level at the observer.
+type Estimate struct {
+	DB      float64 `datastore:",noindex"` // dBA at the observer; zero if no estimate was made
+	RefDB   float64 `datastore:",noindex"` // The aircraft's reference level at 1000ft
+	DistKM  float64 `datastore:",noindex"` // Slant range used
+	Class   string  `datastore:",noindex"` // Noise class used
+	Assumed bool    `datastore:",noindex"` // Equipment type unknown; KAssumedInfo was used
+}
+
+func (e Estimate)IsZero() bool { return e.DB == 0 }
+
+func (e Estimate)String() string {
+	if e.IsZero() { return "" }
+	str := fmt.Sprintf("~%.0fdBA", e.DB)
+	if e.Assumed { str += " (type unknown)" }
+	return str
+}
+
+// }}}
+// {{{ EstimateDB
+
+// EstimateDB models the level at an observer dist3KM away from an aircraft of the given
+// equipment type, climbing or descending at verticalSpeedFPM. It never goes below KFloorDB.
+func EstimateDB(equip string, dist3KM, verticalSpeedFPM float64) Estimate {
+	info,exists := Lookup(equip)
+	if !exists { info = KAssumedInfo }
+
+	dist := math.Max(dist3KM, KMinModelDistKM)
+	e := Estimate{
+		RefDB: info.ReferenceDB(),
+		DistKM: dist3KM,
+		Class: info.NoiseClass(),
+		Assumed: !exists,
+	}
+	if verticalSpeedFPM > KClimbThresholdFPM { e.RefDB += KClimbThrustDB }
+
+	e.DB = e.RefDB -
+		20.0 * math.Log10(dist / KReferenceDistKM) -
+		KAbsorptionDBPerKM * (dist - KReferenceDistKM)
+	e.DB = math.Max(e.DB, KFloorDB) // Far off aircraft are inaudible, not silent (or negative)
+
+	return e
+}
+
+// }}}
+// {{{ DBBucket
+
+// DBBucket groups a level into a 5dB band for reports, e.g. "65-70dBA".
+func DBBucket(db float64) string {
+	if db < 40 { return "<40dBA" }
+	lo := 5 * math.Floor(db/5)
+	return fmt.Sprintf("%.0f-%.0fdBA", lo, lo+5)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
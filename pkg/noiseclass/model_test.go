@@ -0,0 +1,70 @@
+package noiseclass
+
+import (
+	"math"
+	"testing"
+)
+
+// {{{ TestEstimateDB
+
+func TestEstimateDB(t *testing.T) {
+	// At the reference distance, we should get the reference level
+	if e := EstimateDB("B744", KReferenceDistKM, 0); math.Abs(e.DB - 92) > 0.01 || e.Assumed {
+		t.Errorf("B744 at 1000ft: %s (%+v)", e, e)
+	}
+
+	// Further is quieter; newer is quieter; climbing is louder
+	near,far := EstimateDB("B738", 1.0, 0), EstimateDB("B738", 4.0, 0)
+	if !(far.DB < near.DB - 12) {
+		t.Errorf("4x distance wasn't >12dB quieter: %.1f vs %.1f", near.DB, far.DB)
+	}
+	if neo := EstimateDB("A20N", 1.0, 0); !(neo.DB < near.DB) {
+		t.Errorf("A20N (%.1f) not quieter than B738 (%.1f)", neo.DB, near.DB)
+	}
+	if climb := EstimateDB("B738", 1.0, 2000); climb.DB != near.DB + KClimbThrustDB {
+		t.Errorf("climb thrust: %.1f vs %.1f", climb.DB, near.DB)
+	}
+
+	// Plausible levels at realistic distances (a B738 is chapter 4; ~84dBA at 1000ft)
+	levels := []struct{
+		equip      string
+		distKM     float64
+		lo,hi      float64
+	}{
+		{"B738", 1.0, 70, 76},
+		{"B738", 6.0, 45, 55},
+		{"B738", 12.0, 30, 40},  // Still inside the default MaxDistKM
+		{"C172", 9.0, 25, 35},
+		{"C172", 100.0, KFloorDB, KFloorDB},
+	}
+	for _,l := range levels {
+		if e := EstimateDB(l.equip, l.distKM, 0); e.DB < l.lo || e.DB > l.hi || e.IsZero() {
+			t.Errorf("%s at %.0fKM: %.1fdBA, expected %.0f-%.0f", l.equip, l.distKM, e.DB, l.lo, l.hi)
+		}
+	}
+
+	if e := EstimateDB("", 1.0, 0); !e.Assumed || e.Class != KAssumedInfo.NoiseClass() {
+		t.Errorf("unknown equipment: %+v", e)
+	}
+
+	if e := EstimateDB("C172", 0.0, 0); math.IsInf(e.DB, 0) || math.IsNaN(e.DB) {
+		t.Errorf("zero distance gave %f", e.DB)
+	}
+
+	buckets := map[float64]string{67.4:"65-70dBA", 70:"70-75dBA", 12:"<40dBA"}
+	for db,expected := range buckets {
+		if actual := DBBucket(db); actual != expected {
+			t.Errorf("DBBucket(%.1f): expected %s, got %s", db, expected, actual)
+		}
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,146 @@
+package noiseclass
+
+// Package noiseclass maps aircraft equipment types (e.g. B744) to the things that make them
+// loud or quiet; engine type, weight class, and noise certification chapter. The table is
+// compiled in from equipment.csv, and can be overlaid at runtime (see config "noiseclass.table").
+
+import(
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/skypies/complaints/pkg/config"
+)
+
+//go:embed equipment.csv
+var bundledTable string
+
+// {{{ Info
+
+// Info describes the noise-relevant characteristics of an equipment type.
+type Info struct {
+	EquipType   string // ICAO type designator, e.g. B744
+	Engine      string // jet, turboprop, piston, helicopter
+	Engines     int
+	WeightClass string // super, heavy, large, small
+	Chapter     int    // ICAO Annex 16 noise certification chapter; zero if unknown
+	Description string
+}
+
+func (i Info)IsZero() bool { return i.EquipType == "" }
+
+// NoiseClass is a coarse grouping for reports, e.g. "heavy jet, ch3".
+func (i Info)NoiseClass() string {
+	if i.IsZero() { return "" }
+	str := i.WeightClass + " " + i.Engine
+	if i.Chapter > 0 { str += fmt.Sprintf(", ch%d", i.Chapter) }
+	return str
+}
+
+func (i Info)String() string {
+	return fmt.Sprintf("%s [%s] (%d %s, %s, ch%d)", i.EquipType, i.Description, i.Engines,
+		i.Engine, i.WeightClass, i.Chapter)
+}
+
+// }}}
+// {{{ Table
+
+// Table maps normalized equipment types to their Info.
+type Table map[string]Info
+
+func NormalizeEquipType(equip string) string {
+	return strings.ToUpper(strings.TrimSpace(equip))
+}
+
+func (t Table)Lookup(equip string) (Info, bool) {
+	i,exists := t[NormalizeEquipType(equip)]
+	return i, exists
+}
+
+// ParseTable reads the CSV format used by equipment.csv; lines starting with '#' are comments.
+func ParseTable(r io.Reader) (Table, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 6
+
+	t := Table{}
+	for {
+		rec,err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("ParseTable: %v", err)
+		}
+
+		engines,err := strconv.Atoi(rec[2])
+		if err != nil { return nil, fmt.Errorf("ParseTable: %s: engines: %v", rec[0], err) }
+		chapter,err := strconv.Atoi(rec[4])
+		if err != nil { return nil, fmt.Errorf("ParseTable: %s: chapter: %v", rec[0], err) }
+
+		i := Info{
+			EquipType: NormalizeEquipType(rec[0]),
+			Engine: strings.TrimSpace(rec[1]),
+			Engines: engines,
+			WeightClass: strings.TrimSpace(rec[3]),
+			Chapter: chapter,
+			Description: strings.TrimSpace(rec[5]),
+		}
+		t[i.EquipType] = i
+	}
+
+	return t, nil
+}
+
+// Overlay adds all the entries from the other table, replacing any existing ones.
+func (t Table)Overlay(other Table) {
+	for k,v := range other { t[k] = v }
+}
+
+// }}}
+// {{{ DefaultTable
+
+var defaultTable = config.DefaultTable[Table]{
+	Bundled: bundledTable,
+	Key:     "noiseclass.table",
+	Parse:   ParseTable,
+	Overlay: Table.Overlay,
+	Empty:   func() Table { return Table{} },
+}
+
+// LoadDefaultTable returns the noise data for each equipment type. Local entries (from the file
+// named by config "noiseclass.table") replace the bundled ones from equipment.csv; if that
+// file is bad, the error says so and the bundled entries are used alone.
+func LoadDefaultTable() (Table, error) { return defaultTable.Load() }
+
+// DefaultTable is the table NoiseClassOf and the noise model use.
+func DefaultTable() Table { return defaultTable.Get() }
+
+// Lookup finds the equipment type in the default table.
+func Lookup(equip string) (Info, bool) {
+	return DefaultTable().Lookup(equip)
+}
+
+// NoiseClassOf is the noise class for the equipment type, with placeholders for the reports
+// when it is missing or not in the table.
+func NoiseClassOf(equip string) string {
+	if NormalizeEquipType(equip) == "" {
+		return "equipment unknown"
+	} else if i,exists := Lookup(equip); !exists {
+		return "unclassified"
+	} else {
+		return i.NoiseClass()
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
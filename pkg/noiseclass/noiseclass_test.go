@@ -0,0 +1,98 @@
+package noiseclass
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skypies/complaints/pkg/config"
+)
+
+// {{{ TestLookup
+
+func TestLookup(t *testing.T) {
+	if i,exists := Lookup(" b744"); !exists {
+		t.Errorf("B744 not found")
+	} else if i.NoiseClass() != "heavy jet, ch3" {
+		t.Errorf("B744 has wrong class: %s", i)
+	}
+
+	tests := map[string]string{
+		"A20N": "large jet, ch14",
+		"": "equipment unknown",
+		"ZZZZ": "unclassified",
+	}
+	for equip,expected := range tests {
+		if actual := NoiseClassOf(equip); actual != expected {
+			t.Errorf("%q: expected %q, got %q", equip, expected, actual)
+		}
+	}
+}
+
+// }}}
+// {{{ TestOverlay
+
+func TestOverlay(t *testing.T) {
+	overlay,err := ParseTable(strings.NewReader("# comment\nB744,jet,4,heavy,4,Hushed 747\nZZZZ,piston,1,small,0,Test\n"))
+	if err != nil {
+		t.Fatalf("ParseTable: %v", err)
+	}
+
+	table,_ := ParseTable(strings.NewReader(bundledTable))
+	n := len(table)
+	table.Overlay(overlay)
+
+	if len(table) != n+1 {
+		t.Errorf("overlay: expected %d entries, got %d", n+1, len(table))
+	}
+	if i,_ := table.Lookup("B744"); i.Chapter != 4 {
+		t.Errorf("overlay didn't replace B744: %s", i)
+	}
+	if i,_ := table.Lookup("ZZZZ"); i.NoiseClass() != "small piston" {
+		t.Errorf("bad class for ZZZZ: %q", i.NoiseClass())
+	}
+
+	if _,err := ParseTable(strings.NewReader("B744,jet,four,heavy,3,x\n")); err == nil {
+		t.Errorf("expected an error for a bad engine count")
+	}
+
+	// Loading via config; a bad overlay is an error, but the bundled data is still there
+	dir := t.TempDir()
+	for _,test := range []struct{
+		contents string // Blank means there is no overlay file
+		expectErr bool
+	}{
+		{"ZZZZ,piston,1,small,0,Test\n", false},
+		{"ZZZZ,piston,one,small,0,Test\n", true},
+		{"", true},
+	} {
+		filename := filepath.Join(dir, "overlay.csv")
+		os.Remove(filename)
+		if test.contents != "" {
+			if err := os.WriteFile(filename, []byte(test.contents), 0644); err != nil { t.Fatal(err) }
+		}
+		config.Set("noiseclass.testtable", filename)
+
+		table,err := config.LoadTable(bundledTable, "noiseclass.testtable", ParseTable, Table.Overlay)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%q: unexpected error state: %v", test.contents, err)
+		}
+		if _,exists := table.Lookup("B744"); !exists {
+			t.Errorf("%q: bundled data is missing", test.contents)
+		}
+		if _,exists := table.Lookup("ZZZZ"); exists == test.expectErr {
+			t.Errorf("%q: overlay data in wrong state", test.contents)
+		}
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
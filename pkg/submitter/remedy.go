@@ -0,0 +1,201 @@
+package submitter
+
+// When a submission fails, the Policy decides what to do about it, based on how it failed (see
+// Submission.ClassifyRejection). Each decision is recorded on the submission, as a Remedy.
+
+import(
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/skypies/complaints/pkg/complaintdb"
+)
+
+type Action string
+const(
+	ActionNone         = Action("none")          // It worked; nothing to do
+	ActionRetry        = Action("retry")         // Might be transient; let the task queue retry
+	ActionMarkAccepted = Action("mark-accepted") // A duplicate receipt; BKSV already have it
+	ActionRegeocode    = Action("regeocode")     // Address fields missing; re-geocode, resubmit
+	ActionBackoff      = Action("backoff")       // Bad API key; stop posting for a while, and alert
+	ActionDefer        = Action("deferred")      // Backend is paused; not posted, left for a rescan
+	ActionGiveUp       = Action("give-up")       // Retrying won't help; needs a human
+)
+
+// The fields that re-geocoding the profile's address might fix.
+var addressFields = map[string]bool{"address1":true, "city":true, "state":true, "zipcode":true}
+
+// {{{ Policy
+
+type Policy struct {
+	BackoffFor time.Duration // How long to stop posting to a backend after a bad API key
+
+	// Geocode re-derives the structured address from the profile's address string.
+	Geocode func(*complaintdb.ComplainerProfile) error
+
+	// Alert tells a human that something needs attention. Defaults to an error log.
+	Alert func(subject, body string)
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		BackoffFor: time.Hour,
+		Geocode: func(cp *complaintdb.ComplainerProfile) error { return cp.UpdateStructuredAddress() },
+	}
+}
+
+// }}}
+// {{{ p.Decide
+
+// Decide picks the action for a submission, and says why.
+func (p Policy)Decide(s complaintdb.Submission) (Action, string) {
+	switch s.Outcome {
+	case complaintdb.SubmissionNotAttempted, complaintdb.SubmissionAccepted:
+		return ActionNone, s.Outcome.String()
+	case complaintdb.SubmissionTimeout, complaintdb.SubmissionFailed:
+		return ActionRetry, s.Outcome.String()
+	}
+
+	srr,txt := s.ClassifyRejection()
+	reason := s.Outcome.String() + "/" + srr.String()
+
+	switch srr {
+	case complaintdb.SubmissionRejectDupeReceipt:
+		return ActionMarkAccepted, reason
+
+	case complaintdb.SubmissionRejectBadApiKey:
+		return ActionBackoff, reason
+
+	case complaintdb.SubmissionRejectConstraint:
+		return ActionRetry, reason // These come from racing submissions; they work on retry
+
+	case complaintdb.SubmissionRejectBadField:
+		reason += "-" + txt
+		for _,field := range strings.Split(strings.Trim(txt, "[]"), ",") {
+			if addressFields[field] && !s.HasRemedy(string(ActionRegeocode)) {
+				return ActionRegeocode, reason
+			}
+		}
+		return ActionGiveUp, reason
+	}
+
+	if s.Outcome == complaintdb.SubmissionInvalid {
+		return ActionGiveUp, reason // We'll only fail the same way next time
+	}
+	return ActionRetry, reason
+}
+
+// }}}
+// {{{ p.remediate
+
+// remediate acts on the policy's decision about the submission, records it, and returns the
+// (possibly updated, or replaced) submission. The error is nil if retrying the task won't help.
+func (p Policy)remediate(cdb complaintdb.ComplaintDB, r *Registry, c *complaintdb.Complaint, sub *complaintdb.Submission, err error) (*complaintdb.Submission, error) {
+	for {
+		action,reason := p.Decide(*sub)
+		if action == ActionNone {
+			return sub, err
+		}
+
+		remedy := complaintdb.Remedy{T:cdb.Now(), Reason:reason, Action:string(action)}
+
+		switch action {
+		case ActionRetry:
+			remedy.Note = "left for the task queue to retry"
+
+		case ActionMarkAccepted:
+			sub.Outcome, err = complaintdb.SubmissionAccepted, nil
+			remedy.Note = "the backend already has this complaint"
+
+		case ActionBackoff:
+			// Retrying would only be deferred; the complaint gets resent when its day is rescanned
+			err = nil
+			until := cdb.Now().Add(p.BackoffFor)
+			remedy.Note = fmt.Sprintf("paused %s until %s", sub.Backend, until.Format(time.RFC3339))
+			if first,pauseErr := Pause(cdb, sub.Backend, until); pauseErr != nil {
+				remedy.Note = fmt.Sprintf("could not pause %s: %v", sub.Backend, pauseErr)
+				p.alert(cdb, fmt.Sprintf("Submissions to %s not paused", sub.Backend),
+					fmt.Sprintf("%s rejected our API key; %s.\n\n%s", sub.Backend, remedy.Note, sub.Response))
+			} else if first {
+				p.alert(cdb, fmt.Sprintf("Submissions to %s paused", sub.Backend),
+					fmt.Sprintf("%s rejected our API key; %s.\n\n%s", sub.Backend, remedy.Note, sub.Response))
+			}
+
+		case ActionGiveUp:
+			err = nil
+			remedy.Note = "not retrying; the complaint or profile needs fixing by hand"
+
+		case ActionRegeocode:
+			cp,geoErr := p.regeocode(cdb, c.Profile.EmailAddress)
+			if geoErr != nil {
+				err = nil
+				remedy.Action = string(ActionGiveUp)
+				remedy.Note = fmt.Sprintf("could not re-geocode (%v); not retrying", geoErr)
+				break
+			}
+			remedy.Note = fmt.Sprintf("re-geocoded to %v; resubmitted", cp.StructuredAddress)
+			sub.Remedies = append(sub.Remedies, remedy)
+			sub.Log += fmt.Sprintf("Remedy: %s\n", remedy)
+
+			// Resubmit; then we'll decide what to do about how that went
+			c.Profile, c.Submission = *cp, *sub
+			sub2,err2 := r.Submit(cdb.HTTPClient(), *c)
+			if sub2 == nil {
+				return sub, err2
+			}
+			sub2.Remedies = sub.Remedies
+			sub,err = sub2,err2
+			continue
+		}
+
+		sub.Remedies = append(sub.Remedies, remedy)
+		sub.Log += fmt.Sprintf("Remedy: %s\n", remedy)
+		return sub, err
+	}
+}
+
+func (p Policy)alert(cdb complaintdb.ComplaintDB, subject, body string) {
+	if p.Alert != nil {
+		p.Alert(subject, body)
+	} else {
+		cdb.Errorf("ALERT: %s\n%s\n", subject, body)
+	}
+}
+
+// }}}
+// {{{ p.regeocode
+
+// regeocode updates the stored profile's structured address, and returns the profile.
+func (p Policy)regeocode(cdb complaintdb.ComplaintDB, email string) (*complaintdb.ComplainerProfile, error) {
+	if p.Geocode == nil {
+		return nil, fmt.Errorf("no geocoder")
+	}
+	cp,err := cdb.MustLookupProfile(email)
+	if err != nil {
+		return nil, err
+	} else if cp.Address == "" {
+		return nil, fmt.Errorf("profile has no address")
+	}
+
+	if err := p.Geocode(cp); err != nil {
+		return nil, err
+	}
+	if a := cp.StructuredAddress; a.Street == "" || a.City == "" || a.State == "" || a.Zip == "" {
+		return nil, fmt.Errorf("still incomplete: %v", a)
+	}
+
+	if err := cdb.PersistProfile(*cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
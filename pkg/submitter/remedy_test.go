@@ -0,0 +1,178 @@
+package submitter
+
+import(
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skypies/complaints/pkg/bksv/bksvtest"
+	"github.com/skypies/complaints/pkg/complaintdb"
+)
+
+// {{{ remedyFixture
+
+type remedyFixture struct {
+	t      *testing.T
+	srv    *bksvtest.Server
+	r      *Registry
+	cdb    complaintdb.ComplaintDB
+	alerts []string
+	policy Policy
+}
+
+func newRemedyFixture(t *testing.T) *remedyFixture {
+	f := &remedyFixture{t:t, cdb:newTestDB(t)}
+	f.r,f.srv = newTestRegistry(t)
+
+	f.policy = DefaultPolicy()
+	f.policy.Alert = func(subject, body string) { f.alerts = append(f.alerts, subject) }
+	f.policy.Geocode = func(cp *complaintdb.ComplainerProfile) error {
+		if !strings.Contains(cp.Address, "Palo Alto") { return nil } // Not found; nothing changes
+		cp.StructuredAddress = complaintdb.PostalAddress{
+			Number: "1", Street: "Some St", City: "Palo Alto", State: "CA", Zip: "94301",
+		}
+		return nil
+	}
+	return f
+}
+
+// add stores a profile and a complaint, and returns the complaint's key.
+func (f *remedyFixture)add(email, address string, structured complaintdb.PostalAddress) string {
+	p := complaintdb.ComplainerProfile{
+		EmailAddress: email,
+		FullName: "A Tester",
+		Address: address,
+		StructuredAddress: structured,
+	}
+	if err := f.cdb.PersistProfile(p); err != nil { f.t.Fatal(err) }
+	if err := f.cdb.PersistComplaint(complaintdb.Complaint{Timestamp:time.Now(), Profile:p}); err != nil {
+		f.t.Fatal(err)
+	}
+	all,err := f.cdb.LookupAll(f.cdb.CQByEmail(email))
+	if err != nil || len(all) != 1 { f.t.Fatalf("LookupAll: %v (%d)", err, len(all)) }
+	return all[0].DatastoreKey
+}
+
+func (f *remedyFixture)submit(key string) (complaintdb.Submission, error) {
+	_,err := SubmitComplaint(f.cdb, f.r, f.policy, key, false)
+	c,lookupErr := f.cdb.LookupKey(key, "")
+	if lookupErr != nil { f.t.Fatal(lookupErr) }
+	return c.Submission, err
+}
+
+// }}}
+
+// {{{ TestDecide
+
+func TestDecide(t *testing.T) {
+	tests := []struct{
+		outcome  complaintdb.SubmissionOutcome
+		response string
+		remedies []complaintdb.Remedy
+		expected Action
+	}{
+		{complaintdb.SubmissionAccepted, ``, nil, ActionNone},
+		{complaintdb.SubmissionTimeout, ``, nil, ActionRetry},
+		{complaintdb.SubmissionFailed, ``, nil, ActionRetry},
+		{complaintdb.SubmissionRejected, `{"error":"Duplicate entry 'x' for key 'receipt_key_UNIQUE'"}`, nil, ActionMarkAccepted},
+		{complaintdb.SubmissionRejected, `{"error":"validateSubmitKey returned false"}`, nil, ActionBackoff},
+		{complaintdb.SubmissionRejected, `{"error":"a foreign key constraint fails"}`, nil, ActionRetry},
+		{complaintdb.SubmissionRejected, `{"error":"something new"}`, nil, ActionRetry},
+		{complaintdb.SubmissionRejected, `{"submitted":{"zipcode":{"ok":false}}}`, nil, ActionRegeocode},
+		{complaintdb.SubmissionRejected, `{"submitted":{"surname":{"ok":false}}}`, nil, ActionGiveUp},
+		{complaintdb.SubmissionInvalid, `{"submitted":{"city":{"ok":false}}}`, nil, ActionRegeocode},
+		{complaintdb.SubmissionInvalid, `{"submitted":{"city":{"ok":false}}}`,
+			[]complaintdb.Remedy{{Action:"regeocode"}}, ActionGiveUp},
+		{complaintdb.SubmissionInvalid, `not json`, nil, ActionGiveUp},
+	}
+
+	for i,test := range tests {
+		s := complaintdb.Submission{Outcome:test.outcome, Response:[]byte(test.response), Remedies:test.remedies}
+		if action,reason := DefaultPolicy().Decide(s); action != test.expected {
+			t.Errorf("[%d] %s: got %s (%s), expected %s", i, test.response, action, reason, test.expected)
+		}
+	}
+}
+
+// }}}
+// {{{ TestRemedies
+
+func TestRemedies(t *testing.T) {
+	f := newRemedyFixture(t)
+	good := complaintdb.PostalAddress{Number:"1", Street:"Some St", City:"Palo Alto", State:"CA", Zip:"94301"}
+
+	// Dupe receipts mean BKSV already have it
+	f.srv.Script(bksvtest.DupeReceipt)
+	s,err := f.submit(f.add("dupe@b.cc", "1 Some St, Palo Alto", good))
+	if err != nil || s.Outcome != complaintdb.SubmissionAccepted || !s.HasRemedy("mark-accepted") {
+		t.Errorf("dupe: %s, %v, %v", s, err, s.Remedies)
+	}
+
+	// A missing city gets re-geocoded, and resubmitted; the profile gets fixed too
+	noCity := good
+	noCity.City = ""
+	s,err = f.submit(f.add("nocity@b.cc", "1 Some St, Palo Alto", noCity))
+	if err != nil || s.Outcome != complaintdb.SubmissionAccepted || !s.HasRemedy("regeocode") {
+		t.Errorf("regeocode: %s, %v, %v", s, err, s.Remedies)
+	}
+	if cp,err := f.cdb.MustLookupProfile("nocity@b.cc"); err != nil || cp.StructuredAddress.City != "Palo Alto" {
+		t.Errorf("profile not fixed: %v, %v", cp, err)
+	}
+
+	// A missing state counts as an incomplete address too
+	noState := good
+	noState.State = ""
+	s,err = f.submit(f.add("nostate@b.cc", "1 Some St, Palo Alto", noState))
+	if err != nil || s.Outcome != complaintdb.SubmissionAccepted || !s.HasRemedy("regeocode") {
+		t.Errorf("regeocode state: %s, %v, %v", s, err, s.Remedies)
+	}
+
+	// ... unless geocoding doesn't help; then we give up
+	s,err = f.submit(f.add("nowhere@b.cc", "Nowhere", noCity))
+	if last,_ := s.LastRemedy(); err != nil || s.Outcome != complaintdb.SubmissionInvalid || last.Action != "give-up" {
+		t.Errorf("give up: %s, %v, %v", s, err, s.Remedies)
+	}
+
+	// Backends that were never paused have no pause singleton; that's not an error
+	if until,err := PausedUntil(f.cdb, "nosuch"); err != nil || !until.IsZero() {
+		t.Errorf("never-paused backend: %v, %v", until, err)
+	}
+
+	// A bad API key pauses the backend, and alerts just once; it's not worth a task retry
+	f.srv.Script(bksvtest.BadAPIKey)
+	s,err = f.submit(f.add("apikey@b.cc", "1 Some St, Palo Alto", good))
+	if err != nil || !s.HasRemedy("backoff") || len(f.alerts) != 1 {
+		t.Errorf("bad api key: %s, %v, %v, alerts=%v", s, err, s.Remedies, f.alerts)
+	}
+	if until,err := PausedUntil(f.cdb, s.Backend); err != nil || until.IsZero() {
+		t.Errorf("backend %q not paused (%v)", s.Backend, err)
+	}
+
+	// Complaints to a paused backend are deferred, not posted, and not retried
+	nPosts := len(f.srv.Received())
+	s,err = f.submit(f.add("paused@b.cc", "1 Some St, Palo Alto", good))
+	if err != nil || s.Outcome == complaintdb.SubmissionAccepted || !s.HasRemedy("deferred") {
+		t.Errorf("paused: %s, %v, %v", s, err, s.Remedies)
+	}
+	if len(f.srv.Received()) != nPosts || len(f.alerts) != 1 {
+		t.Errorf("paused backend was posted to, or alerted again (%v)", f.alerts)
+	}
+
+	// The pause is shared via the datastore; another instance extending it doesn't count as first
+	if first,err := Pause(f.cdb, "other", time.Now().Add(time.Hour)); err != nil || !first {
+		t.Errorf("first pause: %v, %v", first, err)
+	}
+	if first,err := Pause(f.cdb, "other", time.Now().Add(2*time.Hour)); err != nil || first {
+		t.Errorf("second pause: %v, %v", first, err)
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,157 @@
+package submitter
+
+// BKSV is racey; if two complaints from the same user arrive too close together, one of them can
+// fail (usually as a duplicate receipt, or a DB constraint). So when we submit a batch, we plan
+// when each complaint gets sent, such that no user's complaints are closer than UserGap, while
+// still sending something every Interval for as long as we can.
+//
+// The plan is greedy: at each step, of the users who are free to send, pick the one with the
+// most complaints left (ties go to the alphabetically first). Busy users go first, so that their
+// long tails are spread out over the time that everyone else's complaints fill. The plan depends
+// only on the keys and owners, not the order they came in, so it's repeatable for debugging.
+
+import(
+	"container/heap"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/skypies/util/gcp/ds"
+
+	"github.com/skypies/complaints/pkg/complaintdb"
+)
+
+// {{{ Slot, Planner
+
+// Slot is when one complaint should be submitted, relative to the start of the plan.
+type Slot struct {
+	Key    string // Encoded datastore key
+	Owner  string
+	Offset time.Duration
+}
+
+type Planner struct {
+	Interval time.Duration // Minimum gap between any two submissions; sets the throughput
+	UserGap  time.Duration // Minimum gap between two submissions from the same user
+}
+
+// DefaultPlanner matches the submitreports queue's rate (100/m), and keeps each user's
+// complaints a minute apart.
+func DefaultPlanner() Planner {
+	return Planner{Interval: 600 * time.Millisecond, UserGap: time.Minute}
+}
+
+// }}}
+// {{{ user heaps
+
+type userQueue struct {
+	id    string // The owner, or for complaints without one, the key
+	owner string
+	keys  []string
+	next  time.Duration // When this user can next send
+}
+
+// readyHeap pops the user with the most complaints left
+type readyHeap []*userQueue
+func (h readyHeap)Len() int { return len(h) }
+func (h readyHeap)Less(i, j int) bool {
+	if len(h[i].keys) != len(h[j].keys) { return len(h[i].keys) > len(h[j].keys) }
+	return h[i].id < h[j].id
+}
+func (h readyHeap)Swap(i, j int) { h[i],h[j] = h[j],h[i] }
+func (h *readyHeap)Push(x interface{}) { *h = append(*h, x.(*userQueue)) }
+func (h *readyHeap)Pop() interface{} {
+	old := *h
+	u := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return u
+}
+
+// waitHeap pops the user who'll be free soonest
+type waitHeap struct{ readyHeap }
+func (h waitHeap)Less(i, j int) bool {
+	if h.readyHeap[i].next != h.readyHeap[j].next { return h.readyHeap[i].next < h.readyHeap[j].next }
+	return h.readyHeap[i].id < h.readyHeap[j].id
+}
+
+// }}}
+// {{{ p.Plan
+
+// Plan schedules the slots (which need only Key and Owner), and returns them in time order.
+// Slots with no owner are treated as having an owner of their own.
+func (p Planner)Plan(in []Slot) []Slot {
+	byOwner := map[string]*userQueue{}
+	for _,s := range in {
+		id := s.Owner
+		if id == "" { id = s.Key }
+		if byOwner[id] == nil {
+			byOwner[id] = &userQueue{id: id, owner: s.Owner}
+		}
+		byOwner[id].keys = append(byOwner[id].keys, s.Key)
+	}
+
+	ready := &readyHeap{}
+	for _,u := range byOwner {
+		sort.Strings(u.keys)
+		*ready = append(*ready, u)
+	}
+	heap.Init(ready)
+	waiting := &waitHeap{}
+
+	out := []Slot{}
+	t := time.Duration(0)
+	for ready.Len() > 0 || waiting.Len() > 0 {
+		for waiting.Len() > 0 && waiting.readyHeap[0].next <= t {
+			heap.Push(ready, heap.Pop(waiting))
+		}
+		if ready.Len() == 0 {
+			t = waiting.readyHeap[0].next // Everyone left is waiting; skip ahead
+			continue
+		}
+
+		u := heap.Pop(ready).(*userQueue)
+		out = append(out, Slot{Key: u.keys[0], Owner: u.owner, Offset: t})
+
+		u.keys = u.keys[1:]
+		if len(u.keys) > 0 {
+			u.next = t + p.UserGap
+			heap.Push(waiting, u)
+		}
+		t += p.Interval
+	}
+
+	return out
+}
+
+// }}}
+// {{{ PlanSubmissions, PlanSummary
+
+// PlanSubmissions looks up the owner of each complaint (from its key), and plans the submissions.
+func PlanSubmissions(cdb complaintdb.ComplaintDB, keyers []ds.Keyer, p Planner) []Slot {
+	in := make([]Slot, len(keyers))
+	for i,keyer := range keyers {
+		in[i] = Slot{Key: keyer.Encode(), Owner: cdb.ComplaintKeyOwner(keyer)}
+	}
+	return p.Plan(in)
+}
+
+// PlanSummary is a oneline description of a plan, for logging.
+func PlanSummary(slots []Slot) string {
+	if len(slots) == 0 { return "empty plan" }
+	owners := map[string]int{}
+	for _,s := range slots { owners[s.Owner]++ }
+	max := 0
+	for _,n := range owners { if n > max { max = n } }
+	return fmt.Sprintf("%d complaints from %d users (max %d), over %s", len(slots), len(owners),
+		max, slots[len(slots)-1].Offset)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,141 @@
+package submitter
+
+import(
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/skypies/complaints/pkg/complaintdb"
+)
+
+// {{{ makeSlots, checkPlan
+
+// makeSlots makes n complaints for each user; a user of "" makes n ownerless complaints.
+func makeSlots(users map[string]int) []Slot {
+	in := []Slot{}
+	for user,n := range users {
+		for i:=0; i<n; i++ {
+			in = append(in, Slot{Key: fmt.Sprintf("%s-%03d", user, i), Owner: user})
+		}
+	}
+	return in
+}
+
+// checkPlan checks that every key got just one slot, and that the gaps are respected.
+func checkPlan(t *testing.T, p Planner, in, plan []Slot) {
+	t.Helper()
+	if len(plan) != len(in) {
+		t.Fatalf("planned %d slots, expected %d", len(plan), len(in))
+	}
+
+	seen := map[string]bool{}
+	last := map[string]time.Duration{}
+	for i,s := range plan {
+		if seen[s.Key] {
+			t.Errorf("key %s planned twice", s.Key)
+		}
+		seen[s.Key] = true
+
+		if i > 0 && s.Offset - plan[i-1].Offset < p.Interval {
+			t.Errorf("slot %d (%s) only %s after the previous one", i, s.Key, s.Offset - plan[i-1].Offset)
+		}
+		if prev,exists := last[s.Owner]; exists && s.Owner != "" && s.Offset - prev < p.UserGap {
+			t.Errorf("slot %d (%s) only %s after %s's previous one", i, s.Key, s.Offset - prev, s.Owner)
+		}
+		last[s.Owner] = s.Offset
+	}
+}
+
+// }}}
+
+// {{{ TestPlan
+
+func TestPlan(t *testing.T) {
+	p := Planner{Interval: time.Second, UserGap: 10 * time.Second}
+
+	tests := []struct{
+		users    map[string]int
+		expected time.Duration // Offset of the last slot
+	}{
+		{map[string]int{}, 0},
+		{map[string]int{"a": 1}, 0},
+		{map[string]int{"a": 5}, 40 * time.Second},                          // All gaps
+		{map[string]int{"": 5}, 4 * time.Second},                            // Ownerless; no gaps
+		{map[string]int{"a":3, "b":3, "c":3, "d":3, "e":3, "f":3, "g":3, "h":3, "i":3, "j":3}, 29 * time.Second},
+		{map[string]int{"a":4, "b":1, "c":1, "d":1, "e":1}, 30 * time.Second}, // The busy user sets the pace
+		{map[string]int{"a":4, "b":2, "": 20}, 30 * time.Second},
+	}
+
+	for i,test := range tests {
+		in := makeSlots(test.users)
+		plan := p.Plan(in)
+		checkPlan(t, p, in, plan)
+		if len(plan) > 0 && plan[len(plan)-1].Offset != test.expected {
+			t.Errorf("[%d] %v: plan took %s, expected %s (%s)", i, test.users,
+				plan[len(plan)-1].Offset, test.expected, PlanSummary(plan))
+		}
+
+		// The plan doesn't depend on the input order
+		rand.Shuffle(len(in), func(i, j int) { in[i],in[j] = in[j],in[i] })
+		if plan2 := p.Plan(in); !reflect.DeepEqual(plan, plan2) {
+			t.Errorf("[%d] %v: plan changed when the input was shuffled", i, test.users)
+		}
+	}
+
+	// The busy user goes first, so their tail overlaps everyone else
+	plan := p.Plan(makeSlots(map[string]int{"a":1, "b":1, "z":3}))
+	if plan[0].Owner != "z" {
+		t.Errorf("expected z to go first, got %v", plan)
+	}
+}
+
+// }}}
+// {{{ TestPlanSubmissions
+
+func TestPlanSubmissions(t *testing.T) {
+	cdb := newTestDB(t)
+
+	tstamp := time.Now().Add(-time.Hour)
+	for i,email := range []string{"a@b.cc", "a@b.cc", "a@b.cc", "c@d.cc"} {
+		c := complaintdb.Complaint{
+			Timestamp: tstamp.Add(time.Duration(i) * time.Minute),
+			Profile: complaintdb.ComplainerProfile{EmailAddress: email},
+		}
+		if err := cdb.PersistComplaint(c); err != nil { t.Fatal(err) }
+	}
+
+	keyers,err := cdb.LookupAllKeys(cdb.NewComplaintQuery().ByTimespan(tstamp.Add(-time.Minute), time.Now()))
+	if err != nil || len(keyers) != 4 {
+		t.Fatalf("LookupAllKeys: %v (%d)", err, len(keyers))
+	}
+
+	p := DefaultPlanner()
+	plan := PlanSubmissions(cdb, keyers, p)
+	checkPlan(t, p, make([]Slot, len(keyers)), plan)
+
+	owners := map[string]int{}
+	for _,s := range plan {
+		owners[s.Owner]++
+		if _,err := cdb.LookupKey(s.Key, ""); err != nil {
+			t.Errorf("planned key %s doesn't decode: %v", s.Key, err)
+		}
+	}
+	if !reflect.DeepEqual(owners, map[string]int{"a@b.cc":3, "c@d.cc":1}) {
+		t.Errorf("bad owners: %v", owners)
+	}
+	if plan[len(plan)-1].Offset != 2 * p.UserGap {
+		t.Errorf("plan took %s (%s)", plan[len(plan)-1].Offset, PlanSummary(plan))
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
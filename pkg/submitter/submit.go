@@ -0,0 +1,100 @@
+package submitter
+
+import(
+	"fmt"
+	"time"
+
+	"github.com/skypies/complaints/pkg/complaintdb"
+)
+
+// {{{ SubmitComplaint
+
+// SubmitComplaint looks up the complaint by its datastore key, and sends it to its noise office,
+// unless it has already been accepted (and force isn't set; then the submission is nil). If it
+// fails, the policy decides what to do about it. If its backend is paused, it isn't sent, and
+// that is recorded as a deferred remedy. The submission is stored on the complaint, even if it
+// failed; the error is nil unless it's worth retrying.
+func SubmitComplaint(cdb complaintdb.ComplaintDB, r *Registry, p Policy, keyStr string, force bool) (*complaintdb.Submission, error) {
+	complaint, err := cdb.LookupKey(keyStr, "")
+	if err != nil {
+		return nil, fmt.Errorf("SubmitComplaint: bad lookup for id %s: %v", keyStr, err)
+	}
+
+	// FIXME - remove this hack at some point.
+	// Hack; we manually fixed up a ton of profiles on 2020.01.28.
+	// Complaints that were stored before this time may have crappy
+	// address data, so re-pull the profile and copy it over.
+	goodAddressesDate := time.Date(2020, time.February, 01, 0, 0, 0, 0, time.UTC)
+	if complaint.Timestamp.Before(goodAddressesDate) {
+		if cp,err := cdb.MustLookupProfile(complaint.Profile.EmailAddress); err == nil {
+			complaint.Profile = *cp
+		}
+	}
+
+	// Don't POST if this complaint has already been accepted.
+	if !force && complaint.Submission.Outcome == complaintdb.SubmissionAccepted {
+		return nil, nil
+	}
+
+	if sub := deferIfPaused(cdb, r, complaint); sub != nil {
+		return sub, nil
+	}
+
+	sub,postErr := r.Submit(cdb.HTTPClient(), *complaint)
+	if sub == nil {
+		return nil, fmt.Errorf("SubmitComplaint: %v", postErr)
+	}
+
+	sub.Remedies = complaint.Submission.Remedies
+	sub,postErr = p.remediate(cdb, r, complaint, sub, postErr)
+
+	// Store the submission outcome, even if the post failed
+	complaint.Submission = *sub
+	if err := cdb.PersistComplaint(*complaint); err != nil {
+		return sub, fmt.Errorf("SubmitComplaint: persisting outcome failed: %v", err)
+	}
+
+	return sub, postErr
+}
+
+// }}}
+// {{{ deferIfPaused
+
+// deferIfPaused records a deferred remedy on the complaint, if its backend is paused, and
+// returns the submission; else nil. Deferred complaints aren't accepted, so they get picked up
+// when their day is next scanned.
+func deferIfPaused(cdb complaintdb.ComplaintDB, r *Registry, c *complaintdb.Complaint) *complaintdb.Submission {
+	s,err := r.Route(*c)
+	if err != nil {
+		return nil // r.Submit will report it
+	}
+	until,err := PausedUntil(cdb, s.Name())
+	if err != nil {
+		cdb.Errorf("SubmitComplaint: %v", err)
+		return nil
+	} else if until.IsZero() {
+		return nil
+	}
+
+	sub := c.Submission
+	remedy := complaintdb.Remedy{T:cdb.Now(), Reason:"paused", Action:string(ActionDefer),
+		Note: fmt.Sprintf("%s is paused until %s; not posted", s.Name(), until.Format(time.RFC3339))}
+	sub.Remedies = append(sub.Remedies, remedy)
+	sub.Log += fmt.Sprintf("Remedy: %s\n", remedy)
+
+	c.Submission = sub
+	if err := cdb.PersistComplaint(*c); err != nil {
+		cdb.Errorf("SubmitComplaint: persisting deferral failed: %v", err)
+	}
+	return &sub
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
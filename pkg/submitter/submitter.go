@@ -0,0 +1,239 @@
+package submitter
+
+// Package submitter routes complaints to the noise office that should get them. Each airport's
+// office has its own backend; most run a BKSV site (see pkg/bksv), but there's room for others.
+// Offices are configured by config "submit.offices", a comma-separated list of
+// AIRPORT=vendor:spec, e.g.
+//
+//   KSFO=bksv:viewpoint.emsbk.com/sfo5,KSJC=bksv:viewpoint.emsbk.com/sjc1
+//
+// The first office listed is the default, for profiles that haven't picked an airport.
+
+import(
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skypies/util/gcp/ds"
+	sprovider "github.com/skypies/util/gcp/singleton"
+
+	"github.com/skypies/complaints/pkg/bksv"
+	"github.com/skypies/complaints/pkg/complaintdb"
+	"github.com/skypies/complaints/pkg/config"
+)
+
+// Submitter is a role for things that can post a complaint to a noise office.
+type Submitter interface {
+	Name() string // Recorded in Submission.Backend, e.g. "bksv:viewpoint.emsbk.com/sfo5"
+	Submit(client *http.Client, c complaintdb.Complaint) (*complaintdb.Submission, error)
+}
+
+// {{{ Vendors
+
+// A Vendor makes a Submitter for an airport's office, from the part of the spec after the colon.
+type Vendor func(airport, spec string) (Submitter, error)
+
+var vendors = map[string]Vendor{
+	"bksv": func(airport, spec string) (Submitter, error) { return bksv.NewSite(airport, spec) },
+}
+
+// RegisterVendor makes a new kind of backend available to ParseRegistry.
+func RegisterVendor(name string, v Vendor) { vendors[name] = v }
+
+// }}}
+// {{{ Registry
+
+type Registry struct {
+	Default  string // Airport to use when the profile doesn't say
+	offices  map[string]Submitter
+}
+
+func NewRegistry() *Registry {
+	return &Registry{offices: map[string]Submitter{}}
+}
+
+// Register adds (or replaces) the office for an airport. The first one becomes the default.
+func (r *Registry)Register(airport string, s Submitter) {
+	airport = strings.ToUpper(airport)
+	if r.Default == "" { r.Default = airport }
+	r.offices[airport] = s
+}
+
+func (r *Registry)Lookup(airport string) (Submitter, bool) {
+	s,exists := r.offices[strings.ToUpper(airport)]
+	return s, exists
+}
+
+// Airports lists the airports with offices, sorted.
+func (r *Registry)Airports() []string {
+	ret := []string{}
+	for airport,_ := range r.offices { ret = append(ret, airport) }
+	sort.Strings(ret)
+	return ret
+}
+
+// Route picks the office for a complaint; the one the complainer picked, else the default. An
+// airport that no longer has an office configured (or never did) also gets the default.
+func (r *Registry)Route(c complaintdb.Complaint) (Submitter, error) {
+	if s,exists := r.Lookup(c.Profile.Airport); exists {
+		return s, nil
+	} else if s,exists := r.Lookup(r.Default); exists {
+		return s, nil
+	}
+	return nil, fmt.Errorf("Route: no noise office configured for airport '%s', and no default",
+		c.Profile.Airport)
+}
+
+// Submit routes the complaint, and submits it. If it couldn't be routed, the submission is nil.
+// It doesn't check whether the backend is paused; see SubmitComplaint.
+func (r *Registry)Submit(client *http.Client, c complaintdb.Complaint) (*complaintdb.Submission, error) {
+	s,err := r.Route(c)
+	if err != nil {
+		return nil, err
+	}
+	sub,err := s.Submit(client, c)
+	if sub != nil && sub.Backend == "" {
+		sub.Backend = s.Name()
+	}
+	return sub, err
+}
+
+// List is for select widgets in templates; pairs of {airport, description}. The default comes
+// first, so that it's what gets picked for profiles that haven't chosen.
+func (r *Registry)List() [][]string {
+	ret := [][]string{}
+	if r.Default != "" {
+		ret = append(ret, []string{r.Default, r.Default + " (default)"})
+	}
+	for _,airport := range r.Airports() {
+		if airport != r.Default {
+			ret = append(ret, []string{airport, airport})
+		}
+	}
+	return ret
+}
+
+// }}}
+// {{{ Pause, PausedUntil
+
+// Pauses live in the datastore (as singletons), so that every instance sees them.
+func pauseName(backend string) string { return "submitter:paused:" + backend }
+
+// PausedUntil is zero if the backend (by name) isn't paused. Backends that have never been
+// paused have no singleton at all, which is the usual case and not worth a warning.
+func PausedUntil(cdb complaintdb.ComplaintDB, backend string) (time.Time, error) {
+	until := time.Time{}
+	sp := sprovider.NewProvider(cdb.Provider)
+	sp.ErrIfNotFound = true
+	if err := sp.ReadSingleton(cdb.Ctx(), pauseName(backend), nil, &until); err == ds.ErrNoSuchEntity {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("PausedUntil: %v", err)
+	} else if !cdb.Now().Before(until) {
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+// Pause stops submissions to the backend until the given time. It returns false if the backend
+// was already paused, so that only the instance that paused it sends an alert. (There are no
+// transactions, so two instances pausing it at the same moment could both get true; the
+// planner spaces submissions out, so that's rare.)
+func Pause(cdb complaintdb.ComplaintDB, backend string, until time.Time) (bool, error) {
+	prev,err := PausedUntil(cdb, backend)
+	if err != nil {
+		return false, fmt.Errorf("Pause: %v", err)
+	} else if !until.After(prev) {
+		return false, nil
+	}
+
+	sp := sprovider.NewProvider(cdb.Provider)
+	if err := sp.WriteSingleton(cdb.Ctx(), pauseName(backend), nil, &until); err != nil {
+		return false, fmt.Errorf("Pause: %v", err)
+	}
+	return prev.IsZero(), nil
+}
+
+// }}}
+// {{{ ParseRegistry
+
+// ParseRegistry builds a registry from a spec, as described at the top of this file.
+func ParseRegistry(spec string) (*Registry, error) {
+	r := NewRegistry()
+	for _,office := range strings.Split(spec, ",") {
+		office = strings.TrimSpace(office)
+		if office == "" { continue }
+
+		bits := strings.SplitN(office, "=", 2)
+		if len(bits) != 2 {
+			return nil, fmt.Errorf("ParseRegistry: '%s' is not AIRPORT=vendor:spec", office)
+		}
+		airport,backend := strings.TrimSpace(bits[0]), strings.TrimSpace(bits[1])
+
+		bits = strings.SplitN(backend, ":", 2)
+		if len(bits) != 2 {
+			return nil, fmt.Errorf("ParseRegistry: '%s' is not vendor:spec", backend)
+		}
+		vendor,exists := vendors[bits[0]]
+		if !exists {
+			return nil, fmt.Errorf("ParseRegistry: unknown vendor '%s'", bits[0])
+		}
+
+		s,err := vendor(airport, bits[1])
+		if err != nil {
+			return nil, fmt.Errorf("ParseRegistry: %v", err)
+		}
+		r.Register(airport, s)
+	}
+
+	if len(r.offices) == 0 {
+		return nil, fmt.Errorf("ParseRegistry: no offices in '%s'", spec)
+	}
+	return r, nil
+}
+
+// }}}
+// {{{ DefaultRegistry
+
+var(
+	defaultRegistry *Registry
+	defaultOnce sync.Once
+)
+
+// DefaultRegistry is from config "submit.offices". If that isn't set (or doesn't parse; that
+// gets logged), it's just SFO's BKSV site.
+func DefaultRegistry() *Registry {
+	defaultOnce.Do(func() {
+		if spec := config.Get("submit.offices"); spec != "" {
+			if r,err := ParseRegistry(spec); err != nil {
+				log.Printf("submitter: %v", err)
+			} else {
+				defaultRegistry = r
+			}
+		}
+		if defaultRegistry == nil {
+			defaultRegistry = NewRegistry()
+			defaultRegistry.Register(bksv.DefaultSite.Airport, bksv.DefaultSite)
+		}
+	})
+	return defaultRegistry
+}
+
+// Submit sends the complaint to its office, via the default registry.
+func Submit(client *http.Client, c complaintdb.Complaint) (*complaintdb.Submission, error) {
+	return DefaultRegistry().Submit(client, c)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,188 @@
+package submitter
+
+import(
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/skypies/complaints/pkg/bksv"
+	"github.com/skypies/complaints/pkg/bksv/bksvtest"
+	"github.com/skypies/complaints/pkg/complaintdb"
+	"github.com/skypies/complaints/pkg/memds"
+)
+
+// {{{ fakeSubmitter
+
+type fakeSubmitter string
+
+func (f fakeSubmitter)Name() string { return "fake:" + string(f) }
+func (f fakeSubmitter)Submit(client *http.Client, c complaintdb.Complaint) (*complaintdb.Submission, error) {
+	return &complaintdb.Submission{Outcome: complaintdb.SubmissionAccepted}, nil
+}
+
+// }}}
+// {{{ newTestDB, newTestRegistry
+
+// newTestDB is an empty, in-memory complaintdb.
+func newTestDB(t *testing.T) complaintdb.ComplaintDB {
+	cdb,err := complaintdb.New(context.Background(), complaintdb.WithProvider(memds.NewProvider()),
+		complaintdb.WithHTTPClient(&http.Client{Timeout: time.Second}))
+	if err != nil { t.Fatal(err) }
+	return cdb
+}
+
+// newTestRegistry routes everything to a fake BKSV site, which is closed when the test ends.
+func newTestRegistry(t *testing.T) (*Registry, *bksvtest.Server) {
+	srv := bksvtest.NewServer()
+	t.Cleanup(srv.Close)
+	r,err := ParseRegistry("KSFO=bksv:" + srv.SiteURL())
+	if err != nil { t.Fatal(err) }
+	return r, srv
+}
+
+// }}}
+// {{{ TestParseRegistry
+
+func TestParseRegistry(t *testing.T) {
+	r,err := ParseRegistry("KSFO=bksv:viewpoint.emsbk.com/sfo5, ksjc=bksv:http://localhost:8080/sjc1")
+	if err != nil { t.Fatal(err) }
+
+	if r.Default != "KSFO" {
+		t.Errorf("default: got %q", r.Default)
+	}
+	if s,exists := r.Lookup("KSJC"); !exists {
+		t.Errorf("KSJC not found")
+	} else if site := s.(bksv.Site); site.Airport != "KSJC" || site.URL != "http://localhost:8080/sjc1" {
+		t.Errorf("KSJC: got %#v", site)
+	}
+	if s,_ := r.Lookup("KSFO"); s.Name() != "bksv:viewpoint.emsbk.com/sfo5" {
+		t.Errorf("KSFO name: got %q", s.Name())
+	}
+	if s,_ := r.Lookup("KSJC"); s.Name() != "bksv:localhost:8080/sjc1" {
+		t.Errorf("KSJC name: got %q", s.Name())
+	}
+
+	for _,bad := range []string{"", "KSFO", "KSFO=bksv", "KSFO=nosuch:foo", "KSFO=bksv:"} {
+		if _,err := ParseRegistry(bad); err == nil {
+			t.Errorf("spec %q: expected an error", bad)
+		}
+	}
+}
+
+// }}}
+// {{{ TestRouting
+
+func TestRouting(t *testing.T) {
+	RegisterVendor("fake", func(airport, spec string) (Submitter, error) {
+		if spec == "" { return nil, fmt.Errorf("no spec") }
+		return fakeSubmitter(airport + "/" + spec), nil
+	})
+	r,err := ParseRegistry("KSFO=fake:sfo,KOAK=fake:oak")
+	if err != nil { t.Fatal(err) }
+
+	tests := []struct{
+		airport string
+		expected string
+	}{
+		{"", "fake:KSFO/sfo"},
+		{"KOAK", "fake:KOAK/oak"},
+		{"koak", "fake:KOAK/oak"},
+		{"KSJC", "fake:KSFO/sfo"}, // Not configured; goes to the default
+	}
+
+	for _,test := range tests {
+		c := complaintdb.Complaint{Profile: complaintdb.ComplainerProfile{Airport: test.airport}}
+		sub,err := r.Submit(nil, c)
+		if test.expected == "" {
+			if err == nil || sub != nil {
+				t.Errorf("%q: expected routing to fail, got %v", test.airport, sub)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.airport, err)
+		} else if sub.Backend != test.expected {
+			t.Errorf("%q: went to %q, expected %q", test.airport, sub.Backend, test.expected)
+		}
+	}
+
+	if l := r.List(); len(l) != 2 || l[0][1] != "KSFO (default)" || l[1][0] != "KOAK" {
+		t.Errorf("List: got %v", l)
+	}
+
+	if _,err := NewRegistry().Route(complaintdb.Complaint{}); err == nil {
+		t.Errorf("expected routing to fail with no offices")
+	}
+}
+
+// }}}
+// {{{ TestSubmitComplaint
+
+func TestSubmitComplaint(t *testing.T) {
+	r,srv := newTestRegistry(t)
+	cdb := newTestDB(t)
+
+	c := complaintdb.Complaint{
+		Timestamp: time.Now(),
+		Profile: complaintdb.ComplainerProfile{
+			EmailAddress: "a@b.cc",
+			FullName: "A Tester",
+			StructuredAddress: complaintdb.PostalAddress{
+				Number: "1", Street: "Some St", City: "Palo Alto", State: "CA", Zip: "94301",
+			},
+		},
+	}
+	if err := cdb.PersistComplaint(c); err != nil { t.Fatal(err) }
+	all,err := cdb.LookupAll(cdb.NewComplaintQuery())
+	if err != nil || len(all) != 1 { t.Fatalf("LookupAll: %v (%d)", err, len(all)) }
+	key := all[0].DatastoreKey
+
+	lookup := func() complaintdb.Submission {
+		c,err := cdb.LookupKey(key, "")
+		if err != nil { t.Fatal(err) }
+		return c.Submission
+	}
+
+	// A rejection gets stored, and reported as an error
+	srv.Script(bksvtest.Constraint)
+	if _,err := SubmitComplaint(cdb, r, DefaultPolicy(), key, false); err == nil {
+		t.Errorf("expected an error from a rejection")
+	}
+	if s := lookup(); s.Outcome != complaintdb.SubmissionRejected || s.Attempts != 1 {
+		t.Errorf("after rejection, stored %s", s)
+	} else if !s.HasRemedy(string(ActionRetry)) {
+		t.Errorf("after rejection, remedies: %v", s.Remedies)
+	}
+
+	// The retry works
+	if sub,err := SubmitComplaint(cdb, r, DefaultPolicy(), key, false); err != nil || sub == nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if s := lookup(); s.Outcome != complaintdb.SubmissionAccepted || s.Attempts != 2 || s.Backend == "" {
+		t.Errorf("after retry, stored %s (backend=%q)", s, s.Backend)
+	}
+
+	// Accepted complaints aren't resubmitted, unless forced
+	if sub,err := SubmitComplaint(cdb, r, DefaultPolicy(), key, false); err != nil || sub != nil {
+		t.Errorf("resubmitted an accepted complaint: %v, %v", sub, err)
+	}
+	if sub,err := SubmitComplaint(cdb, r, DefaultPolicy(), key, true); err != nil || sub == nil {
+		t.Errorf("forced resubmission: %v, %v", sub, err)
+	}
+	if n := len(srv.Received()); n != 3 {
+		t.Errorf("server received %d posts, expected 3", n)
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}